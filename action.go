@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// Action is what wsw does to a running child on a service event. Every part
+// that is set happens, in field order.
+type Action struct {
+	// Event sets the named Windows event, e.g. Global\myapp-rotate.
+	Event string
+	// Stdin writes the text and a newline to the child's stdin, which must
+	// be "pipe".
+	Stdin string
+	// Exec runs a command with Args in the child's directory and
+	// environment and waits for it.
+	Exec string
+	Args []string
+
+	// env is added to the command's environment.
+	env []string
+}
+
+func (c *child) runAction(a *Action) error {
+	if a.Event != "" {
+		if err := signalEvent(a.Event); err != nil {
+			return fmt.Errorf("Failed to signal event %q: %v", a.Event, err)
+		}
+	}
+	if a.Stdin != "" {
+		c.mu.Lock()
+		w := c.stdin
+		c.mu.Unlock()
+		if w == nil {
+			return fmt.Errorf("Stdin is not a pipe")
+		}
+		if _, err := fmt.Fprintln(w, a.Stdin); err != nil {
+			return fmt.Errorf("Failed to write to stdin: %v", err)
+		}
+	}
+	if a.Exec == "" {
+		return nil
+	}
+	full, err := c.lookExec(a.Exec)
+	if err != nil {
+		return fmt.Errorf("Failed to find executable %q: %v", a.Exec, err)
+	}
+	cmd := exec.Command(full, a.Args...)
+	cmd.Dir = c.dir
+	cmd.Env = append([]string{}, c.cmd.Env...)
+	if _, err := resolveCmdSecrets(newSecretResolver(c.prg.Config), cmd); err != nil {
+		return fmt.Errorf("%s: %v", a.Exec, err)
+	}
+	// What wsw adds may name references, which are not for resolving.
+	cmd.Env = append(cmd.Env, a.env...)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s: %v: %s", a.Exec, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// pause runs OnPause, or suspends the child and the processes it started.
+func (c *child) pause() error {
+	if c.OnPause != nil {
+		return c.runAction(c.OnPause)
+	}
+	return c.suspend(true)
+}
+
+// resume undoes pause with OnContinue or by resuming the child's processes.
+func (c *child) resume() error {
+	if c.OnContinue != nil {
+		return c.runAction(c.OnContinue)
+	}
+	return c.suspend(false)
+}
+
+func (c *child) suspend(suspend bool) error {
+	if c.proc == nil {
+		return nil
+	}
+	pids := []int{c.proc.Pid}
+	if c.group != nil {
+		if all, err := c.group.pids(); err == nil {
+			pids = all
+		}
+	}
+	for _, pid := range pids {
+		if err := suspendProcess(pid, suspend); err != nil {
+			return fmt.Errorf("PID %d: %v", pid, err)
+		}
+	}
+	return nil
+}
+
+// pause pauses the children, dependents first.
+func (p *program) pause() {
+	logger.Info("Pausing ", p.DisplayName)
+	for i := len(p.children) - 1; i >= 0; i-- {
+		if err := p.children[i].pause(); err != nil {
+			logger.Warningf("Failed to pause %s: %v", p.children[i].label(), err)
+		}
+	}
+}
+
+func (p *program) resume() {
+	logger.Info("Continuing ", p.DisplayName)
+	for _, c := range p.children {
+		if err := c.resume(); err != nil {
+			logger.Warningf("Failed to continue %s: %v", c.label(), err)
+		}
+	}
+}
+
+func checkControls(proc *Process) error {
+	for code := range proc.Controls {
+		if n, err := strconv.Atoi(code); err != nil || n < 128 || n > 255 {
+			return fmt.Errorf("Control code %q is not between 128 and 255", code)
+		}
+	}
+	return nil
+}
+
+// customControl runs the Controls action of every child for a user defined
+// SCM control code.
+func (p *program) customControl(code uint32) {
+	key := strconv.Itoa(int(code))
+	handled := false
+	for _, c := range p.children {
+		a := c.Controls[key]
+		if a == nil {
+			continue
+		}
+		handled = true
+		logger.Infof("Control code %d for %s", code, c.label())
+		if err := c.runAction(a); err != nil {
+			logger.Warningf("Control code %d for %s: %v", code, c.label(), err)
+		}
+	}
+	if !handled {
+		logger.Warningf("No action for control code %d", code)
+	}
+}
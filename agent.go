@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+)
+
+// runAgent runs the service in the foreground as a plain process, for
+// machines where services cannot be installed. Nothing is registered with
+// the service manager: the agent logs to stderr and is managed over the
+// control pipe, which status, stop and restart fall back to.
+func runAgent(prg *program) {
+	if err := sendControl(prg.Name, ioutil.Discard, "ping"); err == nil {
+		fatalf("%s is already running", prg.Name)
+	} else if err != errNotRunning {
+		fatal(err)
+	}
+	prg.agent = true
+	logger = textLogger{l: log.New(os.Stderr, "", log.LstdFlags)}
+	runForeground(prg)
+}
+
+// agentRunning tells whether the named service runs as an agent.
+func agentRunning(name string) bool {
+	var out bytes.Buffer
+	if err := sendControl(name, &out, "ping"); err != nil {
+		return false
+	}
+	return strings.TrimSpace(out.String()) == "agent"
+}
+
+// agentAction carries out stop and restart for a service running as an
+// agent, returning false if it is not one.
+func agentAction(name, action string) bool {
+	if (action != "stop" && action != "restart") || !agentRunning(name) {
+		return false
+	}
+	if err := sendControl(name, os.Stdout, action); err != nil {
+		fatal(err)
+	}
+	return true
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// extract unpacks the archive, "zip", "tar" or "tar.gz", into dir, dropping
+// the first strip components of every entry name.
+func extract(archive, format, dir string, strip int) error {
+	switch format {
+	case "zip":
+		return unzip(archive, dir, strip)
+	case "tar", "tar.gz", "tgz":
+		return untar(archive, format != "tar", dir, strip)
+	}
+	return fmt.Errorf("Unknown archive format %q, expected zip, tar or tar.gz", format)
+}
+
+// entryPath maps an archive entry name into dir, returning "" for entries
+// stripped away and an error for ones escaping dir.
+func entryPath(dir, name string, strip int) (string, error) {
+	parts := strings.Split(strings.Trim(path.Clean(strings.ReplaceAll(name, `\`, "/")), "/"), "/")
+	if len(parts) <= strip {
+		return "", nil
+	}
+	full := filepath.Join(dir, filepath.FromSlash(strings.Join(parts[strip:], "/")))
+	if full != filepath.Clean(dir) && !strings.HasPrefix(full, filepath.Clean(dir)+string(os.PathSeparator)) {
+		return "", fmt.Errorf("Archive entry %q is outside of %q", name, dir)
+	}
+	return full, nil
+}
+
+func unzip(archive, dir string, strip int) error {
+	r, err := zip.OpenReader(archive)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	for _, f := range r.File {
+		path, err := entryPath(dir, f.Name, strip)
+		if err != nil {
+			return err
+		} else if path == "" {
+			continue
+		}
+		if f.FileInfo().IsDir() {
+			if err := os.MkdirAll(path, 0755); err != nil {
+				return err
+			}
+			continue
+		}
+		src, err := f.Open()
+		if err != nil {
+			return err
+		}
+		err = writeEntry(src, path)
+		src.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func untar(archive string, gzipped bool, dir string, strip int) error {
+	f, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var r io.Reader = f
+	if gzipped {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	}
+	tr := tar.NewReader(r)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		path, err := entryPath(dir, h.Name, strip)
+		if err != nil {
+			return err
+		} else if path == "" {
+			continue
+		}
+		switch h.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(path, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := writeEntry(tr, path); err != nil {
+				return err
+			}
+		}
+		// Links and special files are skipped.
+	}
+}
+
+func writeEntry(src io.Reader, path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	dst, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0755)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	return dst.Close()
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestEntryPath(t *testing.T) {
+	dir := filepath.Join("srv", "data")
+	for _, tt := range []struct {
+		name  string
+		strip int
+		want  string
+		ok    bool
+	}{
+		{"bin/app", 0, filepath.Join(dir, "bin", "app"), true},
+		{"./bin/app", 0, filepath.Join(dir, "bin", "app"), true},
+		{`bin\app`, 0, filepath.Join(dir, "bin", "app"), true},
+		{"app-1.0/bin/app", 1, filepath.Join(dir, "bin", "app"), true},
+		{"app-1.0/", 1, "", true},
+		{"app-1.0", 1, "", true},
+		{"app-1.0/bin/", 1, filepath.Join(dir, "bin"), true},
+		{"/etc/passwd", 0, filepath.Join(dir, "etc", "passwd"), true},
+		{"bin/../app", 0, filepath.Join(dir, "app"), true},
+		{"../app", 0, "", false},
+		// Stripping applies to the cleaned name.
+		{"app-1.0/../../app", 1, filepath.Join(dir, "app"), true},
+		{"app-1.0/../../../app", 1, "", false},
+		{`..\app`, 0, "", false},
+	} {
+		got, err := entryPath(dir, tt.name, tt.strip)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("entryPath(%q, %d) = %q, %v, want %q, ok %v", tt.name, tt.strip, got, err, tt.want, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// attachHub passes the output of a child on to the consoles attached to it
+// with "wsw -a attach", over the wsw-<Name>-attach pipe. Each line goes out
+// as "stdout <text>" or "stderr <text>".
+type attachHub struct {
+	mu   sync.Mutex
+	subs map[chan string]bool
+	// files are the streams the child writes to a file directly, without
+	// wsw in between, which attached consoles follow instead.
+	files map[string]string
+}
+
+// subscribe returns a channel getting the lines of output, and the files to
+// follow for the rest.
+func (h *attachHub) subscribe() (chan string, map[string]string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.subs == nil {
+		h.subs = map[chan string]bool{}
+	}
+	lines := make(chan string, 1000)
+	h.subs[lines] = true
+	files := map[string]string{}
+	for stream, path := range h.files {
+		files[stream] = path
+	}
+	return lines, files
+}
+
+func (h *attachHub) unsubscribe(lines chan string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.subs, lines)
+}
+
+// publish hands line to every attached console, dropping it for those too
+// slow to keep up rather than holding up the child.
+func (h *attachHub) publish(line string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for lines := range h.subs {
+		select {
+		case lines <- line:
+		default:
+		}
+	}
+}
+
+// setFile records the file stream goes straight to, if any, for this run.
+func (h *attachHub) setFile(stream, path string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.files == nil {
+		h.files = map[string]string{}
+	}
+	if path == "" {
+		delete(h.files, stream)
+	} else {
+		h.files[stream] = path
+	}
+}
+
+// tee returns w also passing stream on to attached consoles, or w itself
+// when it is a file, which they follow.
+func (h *attachHub) tee(w io.Writer, stream string) (io.Writer, io.Closer) {
+	if f, ok := w.(*os.File); ok {
+		if f != os.Stdout && f != os.Stderr && f.Name() != os.DevNull {
+			h.setFile(stream, f.Name())
+		}
+		return w, nil
+	}
+	h.setFile(stream, "")
+	if w == nil {
+		return nil, nil
+	}
+	lw := &lineWriter{max: defaultMaxLine, emit: func(line []byte) {
+		h.publish(stream + " " + strings.TrimRight(string(line), "\r\n"))
+	}}
+	return io.MultiWriter(w, lw), lw
+}
+
+// serveAttach streams the output of the child to every client of the
+// wsw-<Name>-attach pipe until it disconnects. Processes other than the main
+// one use wsw-<Name>-<process>-attach.
+func (c *child) serveAttach() {
+	path := pipePath(c.fullName() + "-attach")
+	err := servePipeConcurrently(path, func(f *os.File) {
+		lines, files := c.attach.subscribe()
+		defer c.attach.unsubscribe(lines)
+		done := make(chan struct{})
+		defer close(done)
+		for stream, name := range files {
+			go followFile(name, stream, lines, done)
+		}
+		// A client gone unnoticed is dropped with the next line.
+		for line := range lines {
+			if _, err := io.WriteString(f, line+"\n"); err != nil {
+				return
+			}
+		}
+	})
+	logger.Warningf("Failed to serve attach pipe %q: %v", path, err)
+}
+
+// followFile passes on the lines appended to the file path until done.
+func followFile(path, stream string, lines chan<- string, done <-chan struct{}) {
+	f, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	if _, err := f.Seek(0, io.SeekEnd); err != nil {
+		return
+	}
+	lw := &lineWriter{max: defaultMaxLine, emit: func(line []byte) {
+		select {
+		case lines <- stream + " " + strings.TrimRight(string(line), "\r\n"):
+		default:
+		}
+	}}
+	buf := make([]byte, 32<<10)
+	for {
+		n, _ := f.Read(buf)
+		if n > 0 {
+			lw.Write(buf[:n])
+			continue
+		}
+		select {
+		case <-done:
+			return
+		case <-time.After(200 * time.Millisecond):
+		}
+	}
+}
+
+// attach shows the output of a running process on the console, stdout and
+// stderr as if the process had been started there, until the wrapper stops
+// or Ctrl+C. With withStdin what is typed goes to the process's stdin pipe.
+// name picks the process when there are several.
+func attach(conf *Config, name string, withStdin bool) error {
+	p := &program{Config: conf}
+	children, err := p.newChildren()
+	if err != nil {
+		return err
+	}
+	c, err := pickChild(children, name)
+	if err != nil {
+		return err
+	}
+	if withStdin && c.Stdin != "pipe" {
+		return fmt.Errorf("%s has no stdin pipe, set \"Stdin\": \"pipe\"", c.label())
+	}
+	f, err := dialPipe(pipePath(c.fullName() + "-attach"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("%s is not running", c.label())
+		}
+		return err
+	}
+	defer f.Close()
+	if withStdin {
+		// A pipe of its own: the attach pipe only carries output.
+		in, err := dialPipe(pipePath(c.fullName() + "-stdin"))
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+		go io.Copy(in, os.Stdin)
+	}
+	infof("Attached to %s, Ctrl+C detaches", c.label())
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, defaultMaxLine+16)
+	for scanner.Scan() {
+		line := scanner.Text()
+		out := os.Stdout
+		if strings.HasPrefix(line, "stderr ") {
+			out = os.Stderr
+		}
+		if i := strings.IndexByte(line, ' '); i >= 0 {
+			line = line[i+1:]
+		}
+		fmt.Fprintln(out, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return fmt.Errorf("%s is no longer running", c.label())
+}
@@ -0,0 +1,10 @@
+//go:build !windows
+
+package main
+
+import "errors"
+
+// verifySigner fails: Authenticode signatures only exist on Windows.
+func verifySigner(path, signer string) error {
+	return errors.New("ExecSigner is only supported on Windows")
+}
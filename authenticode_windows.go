@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var (
+	crypt32              = windows.NewLazySystemDLL("crypt32.dll")
+	procCryptMsgGetParam = crypt32.NewProc("CryptMsgGetParam")
+	procCryptMsgClose    = crypt32.NewProc("CryptMsgClose")
+)
+
+const cmsgSignerInfoParam = 6
+
+// cmsgSignerInfo is the start of CMSG_SIGNER_INFO.
+type cmsgSignerInfo struct {
+	version uint32
+	issuer  windows.CertNameBlob
+	serial  windows.CryptIntegerBlob
+}
+
+// verifySigner checks that path carries a valid Authenticode signature and,
+// unless signer is "*", that the signing certificate is issued to signer.
+// Revocation is not checked, so hosts without internet access still start.
+func verifySigner(path, signer string) error {
+	path16, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return err
+	}
+	data := &windows.WinTrustData{
+		Size:             uint32(unsafe.Sizeof(windows.WinTrustData{})),
+		UIChoice:         windows.WTD_UI_NONE,
+		RevocationChecks: windows.WTD_REVOKE_NONE,
+		UnionChoice:      windows.WTD_CHOICE_FILE,
+		StateAction:      windows.WTD_STATEACTION_VERIFY,
+		FileOrCatalogOrBlobOrSgnrOrCert: unsafe.Pointer(&windows.WinTrustFileInfo{
+			Size:     uint32(unsafe.Sizeof(windows.WinTrustFileInfo{})),
+			FilePath: path16,
+		}),
+	}
+	verifyErr := windows.WinVerifyTrustEx(windows.InvalidHWND, &windows.WINTRUST_ACTION_GENERIC_VERIFY_V2, data)
+	data.StateAction = windows.WTD_STATEACTION_CLOSE
+	windows.WinVerifyTrustEx(windows.InvalidHWND, &windows.WINTRUST_ACTION_GENERIC_VERIFY_V2, data)
+	if verifyErr != nil {
+		return fmt.Errorf("Authenticode signature of %q is not valid: %v", path, verifyErr)
+	}
+	if signer == "*" {
+		return nil
+	}
+	name, err := signerName(path16)
+	if err != nil {
+		return fmt.Errorf("Failed to read the signer of %q: %v", path, err)
+	}
+	if !strings.EqualFold(name, signer) {
+		return fmt.Errorf("%q is signed by %q, not %q", path, name, signer)
+	}
+	return nil
+}
+
+// signerName returns the subject of the certificate that signed the file.
+func signerName(path *uint16) (string, error) {
+	var store, msg windows.Handle
+	err := windows.CryptQueryObject(windows.CERT_QUERY_OBJECT_FILE, unsafe.Pointer(path),
+		windows.CERT_QUERY_CONTENT_FLAG_PKCS7_SIGNED_EMBED, windows.CERT_QUERY_FORMAT_FLAG_BINARY,
+		0, nil, nil, nil, &store, &msg, nil)
+	if err != nil {
+		return "", err
+	}
+	defer windows.CertCloseStore(store, 0)
+	defer procCryptMsgClose.Call(uintptr(msg))
+
+	var size uint32
+	if r, _, err := procCryptMsgGetParam.Call(uintptr(msg), cmsgSignerInfoParam, 0, 0, uintptr(unsafe.Pointer(&size))); r == 0 {
+		return "", err
+	}
+	buf := make([]byte, size)
+	if r, _, err := procCryptMsgGetParam.Call(uintptr(msg), cmsgSignerInfoParam, 0, uintptr(unsafe.Pointer(&buf[0])), uintptr(unsafe.Pointer(&size))); r == 0 {
+		return "", err
+	}
+	info := (*cmsgSignerInfo)(unsafe.Pointer(&buf[0]))
+	find := windows.CertInfo{Issuer: info.issuer, SerialNumber: info.serial}
+	cert, err := windows.CertFindCertificateInStore(store, windows.X509_ASN_ENCODING|windows.PKCS_7_ASN_ENCODING,
+		0, windows.CERT_FIND_SUBJECT_CERT, unsafe.Pointer(&find), nil)
+	if err != nil {
+		return "", err
+	}
+	defer windows.CertFreeCertificateContext(cert)
+	n := windows.CertGetNameString(cert, windows.CERT_NAME_SIMPLE_DISPLAY_TYPE, 0, nil, nil, 0)
+	name := make([]uint16, n)
+	windows.CertGetNameString(cert, windows.CERT_NAME_SIMPLE_DISPLAY_TYPE, 0, nil, &name[0], n)
+	return windows.UTF16ToString(name), nil
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AWS configures secret://awssm/<secret id>[#<field>], a Secrets Manager
+// secret or a field of one holding JSON, and secret://ssm/<parameter name>,
+// such as secret://ssm//myapp/db/password, a Parameter Store parameter,
+// decrypted if it is a SecureString. Credentials come from AWS_ACCESS_KEY_ID,
+// AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN, or else from the instance
+// profile of the EC2 instance.
+type AWS struct {
+	// Region defaults to AWS_REGION, AWS_DEFAULT_REGION, the region of a
+	// secret given by ARN, then the region of the instance.
+	Region string
+}
+
+// imdsURL is the EC2 instance metadata service.
+const imdsURL = "http://169.254.169.254/latest/"
+
+// awsCredentials are the keys requests are signed with.
+type awsCredentials struct {
+	AccessKeyID     string `json:"AccessKeyId"`
+	SecretAccessKey string
+	Token           string
+	Expiration      time.Time
+}
+
+var (
+	awsMu sync.Mutex
+	// awsInstance are the instance profile credentials, until they expire,
+	// and the instance region.
+	awsInstance       *awsCredentials
+	awsInstanceRegion string
+)
+
+func awssmSecret(r *secretResolver, key string) (string, error) {
+	id, field := key, ""
+	if i := strings.LastIndex(key, "#"); i >= 0 {
+		id, field = key[:i], key[i+1:]
+	}
+	if id == "" {
+		return "", fmt.Errorf("Expected secret://awssm/<secret id>[#<field>]")
+	}
+	region := ""
+	// arn:aws:secretsmanager:<region>:<account>:secret:<name>
+	if parts := strings.Split(id, ":"); len(parts) > 3 && parts[0] == "arn" {
+		region = parts[3]
+	}
+	var resp struct{ SecretString string }
+	if err := awsCall(r, region, "secretsmanager", "secretsmanager.GetSecretValue", map[string]interface{}{"SecretId": id}, &resp); err != nil {
+		return "", err
+	}
+	if field == "" {
+		return resp.SecretString, nil
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(resp.SecretString), &fields); err != nil {
+		return "", fmt.Errorf("%s does not hold JSON: %v", id, err)
+	}
+	raw, ok := fields[field]
+	if !ok {
+		return "", fmt.Errorf("%s has no field %q", id, field)
+	}
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		return s, nil
+	}
+	return string(raw), nil
+}
+
+func ssmSecret(r *secretResolver, key string) (string, error) {
+	if key == "" {
+		return "", fmt.Errorf("Expected secret://ssm/<parameter name>")
+	}
+	var resp struct {
+		Parameter struct{ Value string }
+	}
+	body := map[string]interface{}{"Name": key, "WithDecryption": true}
+	if err := awsCall(r, "", "ssm", "AmazonSSM.GetParameter", body, &resp); err != nil {
+		return "", err
+	}
+	return resp.Parameter.Value, nil
+}
+
+// awsCall calls target, an action of a JSON API like Secrets Manager's, in
+// region, or the configured one when empty.
+func awsCall(r *secretResolver, region, service, target string, body, out interface{}) error {
+	creds, err := awsGetCredentials()
+	if err != nil {
+		return fmt.Errorf("Failed to get AWS credentials: %v", err)
+	}
+	if region == "" && r.conf.Secrets.AWS != nil {
+		region = r.conf.Secrets.AWS.Region
+	}
+	if region == "" {
+		if region = os.Getenv("AWS_REGION"); region == "" {
+			region = os.Getenv("AWS_DEFAULT_REGION")
+		}
+	}
+	if region == "" {
+		if region, err = awsGetInstanceRegion(); err != nil {
+			return fmt.Errorf("No AWS region, set Secrets.AWS.Region or AWS_REGION: %v", err)
+		}
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	host := service + "." + region + ".amazonaws.com"
+	req, err := http.NewRequest("POST", "https://"+host+"/", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", target)
+	awsSign(req, data, creds, region, service, time.Now().UTC())
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err = ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		// The message is "message" or "Message" depending on the service,
+		// which the case-insensitive decoding takes alike.
+		var e struct {
+			Type    string `json:"__type"`
+			Message string
+		}
+		if json.Unmarshal(data, &e) == nil && e.Type != "" {
+			return fmt.Errorf("%s: %s", e.Type[strings.LastIndex(e.Type, "#")+1:], e.Message)
+		}
+		return fmt.Errorf("Unexpected status %s", resp.Status)
+	}
+	return json.Unmarshal(data, out)
+}
+
+// awsSign adds the Signature Version 4 headers to req.
+func awsSign(req *http.Request, body []byte, creds *awsCredentials, region, service string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	if creds.Token != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.Token)
+	}
+	names := []string{"content-type", "host", "x-amz-date"}
+	if creds.Token != "" {
+		names = append(names, "x-amz-security-token")
+	}
+	names = append(names, "x-amz-target")
+	var headers strings.Builder
+	for _, name := range names {
+		value := req.Header.Get(name)
+		if name == "host" {
+			value = req.URL.Host
+		}
+		headers.WriteString(name + ":" + strings.TrimSpace(value) + "\n")
+	}
+	signed := strings.Join(names, ";")
+	canonical := strings.Join([]string{req.Method, "/", "", headers.String(), signed, sha256Hex(body)}, "\n")
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonical))
+	key := []byte("AWS4" + creds.SecretAccessKey)
+	for _, part := range []string{date, region, service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		creds.AccessKeyID, scope, signed, hex.EncodeToString(hmacSHA256(key, toSign))))
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// awsGetCredentials returns the credentials from the environment, or those
+// of the instance profile while they are good for another five minutes.
+func awsGetCredentials() (*awsCredentials, error) {
+	if id := os.Getenv("AWS_ACCESS_KEY_ID"); id != "" {
+		return &awsCredentials{AccessKeyID: id, SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"), Token: os.Getenv("AWS_SESSION_TOKEN")}, nil
+	}
+	awsMu.Lock()
+	defer awsMu.Unlock()
+	if awsInstance != nil && time.Until(awsInstance.Expiration) > 5*time.Minute {
+		return awsInstance, nil
+	}
+	role, err := imdsGet("meta-data/iam/security-credentials/")
+	if err != nil {
+		return nil, err
+	}
+	role = strings.TrimSpace(strings.SplitN(role, "\n", 2)[0])
+	if role == "" {
+		return nil, fmt.Errorf("The instance has no instance profile")
+	}
+	data, err := imdsGet("meta-data/iam/security-credentials/" + role)
+	if err != nil {
+		return nil, err
+	}
+	creds := &awsCredentials{}
+	if err := json.Unmarshal([]byte(data), creds); err != nil {
+		return nil, err
+	}
+	awsInstance = creds
+	return creds, nil
+}
+
+func awsGetInstanceRegion() (string, error) {
+	awsMu.Lock()
+	defer awsMu.Unlock()
+	if awsInstanceRegion == "" {
+		region, err := imdsGet("meta-data/placement/region")
+		if err != nil {
+			return "", err
+		}
+		awsInstanceRegion = strings.TrimSpace(region)
+	}
+	return awsInstanceRegion, nil
+}
+
+// imdsGet reads path from the instance metadata service, with an IMDSv2
+// session token.
+func imdsGet(path string) (string, error) {
+	req, err := http.NewRequest("PUT", imdsURL+"api/token", nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "60")
+	token, err := imdsDo(req)
+	if err != nil {
+		return "", err
+	}
+	if req, err = http.NewRequest("GET", imdsURL+path, nil); err != nil {
+		return "", err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token", token)
+	return imdsDo(req)
+}
+
+func imdsDo(req *http.Request) (string, error) {
+	// The metadata service answers at once or not at all.
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("Instance metadata %s: unexpected status %s", req.URL.Path, resp.Status)
+	}
+	return string(data), nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestAWSSign(t *testing.T) {
+	for _, tt := range []struct {
+		body            string
+		creds           awsCredentials
+		region, service string
+		target          string
+		now             time.Time
+		auth            string
+	}{
+		{
+			`{"SecretId":"db"}`,
+			awsCredentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"},
+			"us-east-1", "secretsmanager", "secretsmanager.GetSecretValue",
+			time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC),
+			"AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20240310/us-east-1/secretsmanager/aws4_request, " +
+				"SignedHeaders=content-type;host;x-amz-date;x-amz-target, " +
+				"Signature=cd80aee2d921c02592f336588e9c9637a3ae30488a93359de1da3f15fc36e665",
+		},
+		{
+			`{"Name":"/app/key","WithDecryption":true}`,
+			awsCredentials{AccessKeyID: "ASIAEXAMPLE", SecretAccessKey: "secret", Token: "session-token"},
+			"eu-west-1", "ssm", "AmazonSSM.GetParameter",
+			time.Date(2024, 12, 31, 23, 59, 59, 0, time.UTC),
+			"AWS4-HMAC-SHA256 Credential=ASIAEXAMPLE/20241231/eu-west-1/ssm/aws4_request, " +
+				"SignedHeaders=content-type;host;x-amz-date;x-amz-security-token;x-amz-target, " +
+				"Signature=4e4180e497213998b0a9a1a4256c867886431959e402cf6877d7d3fa5f581555",
+		},
+	} {
+		body := []byte(tt.body)
+		req, err := http.NewRequest("POST", "https://"+tt.service+"."+tt.region+".amazonaws.com/", bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+		req.Header.Set("X-Amz-Target", tt.target)
+		awsSign(req, body, &tt.creds, tt.region, tt.service, tt.now)
+		if got := req.Header.Get("Authorization"); got != tt.auth {
+			t.Errorf("%s: Authorization = %q, want %q", tt.target, got, tt.auth)
+		}
+		if got, want := req.Header.Get("X-Amz-Date"), tt.now.Format("20060102T150405Z"); got != want {
+			t.Errorf("%s: X-Amz-Date = %q, want %q", tt.target, got, want)
+		}
+		if got := req.Header.Get("X-Amz-Security-Token"); got != tt.creds.Token {
+			t.Errorf("%s: X-Amz-Security-Token = %q, want %q", tt.target, got, tt.creds.Token)
+		}
+	}
+}
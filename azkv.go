@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AzureKeyVault configures secret://azkv/<vault>/<name>[/<version>], which
+// reads a secret of an Azure Key Vault. <vault> is the vault name, or its
+// host name outside the public cloud.
+type AzureKeyVault struct {
+	// TenantID, ClientID and ClientSecret sign in as an app registration.
+	// Without a ClientSecret wsw uses the managed identity of the VM, a
+	// ClientID then picking a user-assigned one. Unset fields are taken from
+	// AZURE_TENANT_ID, AZURE_CLIENT_ID and AZURE_CLIENT_SECRET.
+	TenantID, ClientID, ClientSecret string
+	// AuthorityHost is where app registrations sign in, by default
+	// https://login.microsoftonline.com.
+	AuthorityHost string
+}
+
+// imdsTokenURL is where a VM gets tokens for its managed identity.
+const imdsTokenURL = "http://169.254.169.254/metadata/identity/oauth2/token"
+
+// azureToken is an access token and when it expires.
+type azureToken struct {
+	value   string
+	expires time.Time
+}
+
+var (
+	azureTokensMu sync.Mutex
+	// azureTokens are kept for their lifetime, by identity and resource.
+	azureTokens = map[string]azureToken{}
+)
+
+func azkvSecret(r *secretResolver, key string) (string, error) {
+	parts := strings.SplitN(key, "/", 3)
+	if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+		return "", fmt.Errorf("Expected secret://azkv/<vault>/<name>[/<version>]")
+	}
+	host := parts[0]
+	if !strings.Contains(host, ".") {
+		host += ".vault.azure.net"
+	}
+	// Tokens are for the vault's cloud, e.g. https://vault.azure.cn.
+	resource := "https://" + host[strings.Index(host, ".")+1:]
+	kv := AzureKeyVault{}
+	if r.conf.Secrets.AzureKeyVault != nil {
+		kv = *r.conf.Secrets.AzureKeyVault
+	}
+	token, err := kv.token(r, resource)
+	if err != nil {
+		return "", fmt.Errorf("Failed to get a token for %s: %v", resource, err)
+	}
+	u := "https://" + host + "/secrets/" + url.PathEscape(parts[1])
+	if len(parts) == 3 {
+		u += "/" + url.PathEscape(parts[2])
+	}
+	req, err := http.NewRequest("GET", u+"?api-version=7.4", nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	var secret struct{ Value string }
+	if err := azureDo(req, &secret); err != nil {
+		return "", err
+	}
+	return secret.Value, nil
+}
+
+// token returns an access token for resource, from the cache while it is
+// good for another minute.
+func (kv AzureKeyVault) token(r *secretResolver, resource string) (string, error) {
+	tenant := kv.TenantID
+	if tenant == "" {
+		tenant = os.Getenv("AZURE_TENANT_ID")
+	}
+	client := kv.ClientID
+	if client == "" {
+		client = os.Getenv("AZURE_CLIENT_ID")
+	}
+	secret := kv.ClientSecret
+	if secret == "" {
+		secret = os.Getenv("AZURE_CLIENT_SECRET")
+	}
+	cacheKey := tenant + "/" + client + "/" + resource
+	azureTokensMu.Lock()
+	t, ok := azureTokens[cacheKey]
+	azureTokensMu.Unlock()
+	if ok && time.Until(t.expires) > time.Minute {
+		return t.value, nil
+	}
+
+	var req *http.Request
+	var err error
+	if secret != "" {
+		if tenant == "" || client == "" {
+			return "", fmt.Errorf("A ClientSecret needs a TenantID and a ClientID")
+		}
+		// The client secret may itself come from another provider.
+		if secret, err = r.resolve(secret); err != nil {
+			return "", err
+		}
+		authority := kv.AuthorityHost
+		if authority == "" {
+			authority = "https://login.microsoftonline.com"
+		}
+		form := url.Values{
+			"grant_type":    {"client_credentials"},
+			"client_id":     {client},
+			"client_secret": {secret},
+			"scope":         {resource + "/.default"},
+		}
+		req, err = http.NewRequest("POST", strings.TrimSuffix(authority, "/")+"/"+url.PathEscape(tenant)+"/oauth2/v2.0/token", strings.NewReader(form.Encode()))
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	} else {
+		q := url.Values{"api-version": {"2018-02-01"}, "resource": {resource}}
+		if client != "" {
+			q.Set("client_id", client)
+		}
+		req, err = http.NewRequest("GET", imdsTokenURL+"?"+q.Encode(), nil)
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set("Metadata", "true")
+	}
+	var resp struct {
+		AccessToken string      `json:"access_token"`
+		ExpiresIn   json.Number `json:"expires_in"`
+	}
+	if err := azureDo(req, &resp); err != nil {
+		return "", err
+	}
+	// The managed identity endpoint gives the lifetime as a string.
+	seconds, _ := resp.ExpiresIn.Int64()
+	t = azureToken{value: resp.AccessToken, expires: time.Now().Add(time.Duration(seconds) * time.Second)}
+	azureTokensMu.Lock()
+	azureTokens[cacheKey] = t
+	azureTokensMu.Unlock()
+	return t.value, nil
+}
+
+// azureDo sends req and decodes the JSON response into v, turning error
+// responses into their message.
+func azureDo(req *http.Request, v interface{}) error {
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		// Key Vault nests the error, the token endpoints do not.
+		var e struct {
+			Error json.RawMessage
+			Desc  string `json:"error_description"`
+		}
+		var nested struct{ Message string }
+		if json.Unmarshal(data, &e) == nil {
+			if json.Unmarshal(e.Error, &nested) == nil && nested.Message != "" {
+				return fmt.Errorf("%s: %s", resp.Status, nested.Message)
+			} else if e.Desc != "" {
+				return fmt.Errorf("%s: %s", resp.Status, e.Desc)
+			}
+		}
+		return fmt.Errorf("Unexpected status %s", resp.Status)
+	}
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// wswService is an installed service run by a wsw executable.
+type wswService struct {
+	Name         string
+	Exe          string
+	Dependencies []string
+}
+
+// matchServices returns the installed wsw services whose name matches the
+// filter glob, failing if none does.
+func matchServices(filter string) ([]wswService, error) {
+	services, err := listWswServices()
+	if err != nil {
+		return nil, err
+	}
+	var matched []wswService
+	for _, s := range services {
+		if filter != "" {
+			ok, err := path.Match(strings.ToLower(filter), strings.ToLower(s.Name))
+			if err != nil {
+				return nil, fmt.Errorf("Invalid filter %q: %v", filter, err)
+			}
+			if !ok {
+				continue
+			}
+		}
+		matched = append(matched, s)
+	}
+	if len(matched) == 0 {
+		return nil, fmt.Errorf("No wsw services match %q", filter)
+	}
+	return matched, nil
+}
+
+// bulkControl runs action, "start", "stop" or "restart", on every installed
+// wsw service whose name matches the filter glob. Services start after the
+// ones they depend on and stop before them.
+func bulkControl(action, filter string) error {
+	matched, err := matchServices(filter)
+	if err != nil {
+		return err
+	}
+	order := orderServices(matched)
+	failed := 0
+	each := func(doing, do string, f func(name string) error, names []string) {
+		for _, name := range names {
+			infof("%s %s", doing, name)
+			if err := f(name); err != nil {
+				warnf("Failed to %s %s: %v", do, name, err)
+				failed++
+			}
+		}
+	}
+	var reversed []string
+	for i := len(order) - 1; i >= 0; i-- {
+		reversed = append(reversed, order[i])
+	}
+	switch action {
+	case "start":
+		each("Starting", "start", startService, order)
+	case "stop":
+		each("Stopping", "stop", stopService, reversed)
+	case "restart":
+		each("Stopping", "stop", stopService, reversed)
+		each("Starting", "start", startService, order)
+	default:
+		return fmt.Errorf("Unknown action %q", action)
+	}
+	if failed != 0 {
+		return fmt.Errorf("%d of %d services failed", failed, len(order))
+	}
+	return nil
+}
+
+// orderServices sorts services so that each comes after the services in
+// the list it depends on.
+func orderServices(services []wswService) []string {
+	byName := map[string]wswService{}
+	for _, s := range services {
+		byName[strings.ToLower(s.Name)] = s
+	}
+	done := map[string]bool{}
+	var order []string
+	var visit func(s wswService)
+	visit = func(s wswService) {
+		key := strings.ToLower(s.Name)
+		if done[key] {
+			return
+		}
+		// Marked before the dependencies so a cycle cannot loop forever.
+		done[key] = true
+		for _, dep := range s.Dependencies {
+			if d, ok := byName[strings.ToLower(dep)]; ok {
+				visit(d)
+			}
+		}
+		order = append(order, s.Name)
+	}
+	for _, s := range services {
+		visit(s)
+	}
+	return order
+}
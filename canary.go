@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// checkTimeout bounds a CheckArgs run.
+const checkTimeout = time.Minute
+
+// check runs a new build of the executable once with CheckArgs in the
+// child's directory, before switching to it, and fails unless it exits 0.
+func (c *child) check(exe string) error {
+	if len(c.CheckArgs) == 0 {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), checkTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, exe, c.CheckArgs...)
+	cmd.Dir = c.dir
+	if err := c.readEnvFiles(); err != nil {
+		return err
+	}
+	cmd.Env = c.childEnv(c.baseEnv())
+	infof("Checking %s %s", exe, strings.Join(c.CheckArgs, " "))
+	if _, err := resolveCmdSecrets(newSecretResolver(c.prg.Config), cmd); err != nil {
+		return fmt.Errorf("Check of %q failed: %v", exe, err)
+	}
+	out, err := cmd.CombinedOutput()
+	if len(out) != 0 {
+		infof("%s", out)
+	}
+	if ctx.Err() != nil {
+		return fmt.Errorf("Check of %q timed out after %v", exe, checkTimeout)
+	}
+	if err != nil {
+		return fmt.Errorf("Check of %q failed: %v", exe, err)
+	}
+	return nil
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"net"
+	"strconv"
+)
+
+// CertBinding binds a certificate of the machine's store to an HTTPS port
+// in HTTP.SYS, as "netsh http add sslcert" does, so that an app serving
+// HTTPS through HTTP.SYS needs no access to the private key.
+type CertBinding struct {
+	// Port is bound on all addresses, or on IP only.
+	Port int
+	IP   string
+	// Thumbprint, the hex SHA-1 of the certificate, or FriendlyName picks
+	// the certificate in the LocalMachine Store (default "My"); of several
+	// with the same friendly name, the one valid the longest wins.
+	Thumbprint, FriendlyName string
+	Store                    string
+}
+
+// ipPort returns the address the binding is for.
+func (b *CertBinding) ipPort() string {
+	ip := b.IP
+	if ip == "" {
+		ip = "0.0.0.0"
+	}
+	return net.JoinHostPort(ip, strconv.Itoa(b.Port))
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strings"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var procCertGetCertificateContextProperty = crypt32.NewProc("CertGetCertificateContextProperty")
+
+const certFriendlyNamePropID = 11
+
+// wswAppID is the application ID bindings are made under.
+var wswAppID = windows.GUID{Data1: 0x5f1c3a27, Data2: 0x8d4e, Data3: 0x4b61, Data4: [8]byte{0x9a, 0x0e, 0x2c, 0x73, 0xd5, 0x18, 0x46, 0xb9}}
+
+// httpServiceConfigSSLSet is HTTP_SERVICE_CONFIG_SSL_SET.
+type httpServiceConfigSSLSet struct {
+	IPPort                        unsafe.Pointer
+	HashLength                    uint32
+	Hash                          *byte
+	AppID                         windows.GUID
+	StoreName                     *uint16
+	CertCheckMode                 uint32
+	RevocationFreshnessTime       uint32
+	RevocationURLRetrievalTimeout uint32
+	CtlIdentifier                 *uint16
+	CtlStoreName                  *uint16
+	Flags                         uint32
+}
+
+// sockaddr returns the SOCKADDR of the binding's address.
+func (b *CertBinding) sockaddr() (unsafe.Pointer, error) {
+	if b.Port <= 0 || b.Port > 65535 {
+		return nil, fmt.Errorf("Invalid Port %d", b.Port)
+	}
+	ip := net.IPv4zero
+	if b.IP != "" {
+		if ip = net.ParseIP(b.IP); ip == nil {
+			return nil, fmt.Errorf("Invalid IP %q", b.IP)
+		}
+	}
+	// The port is in network byte order.
+	port := [2]byte{byte(b.Port >> 8), byte(b.Port)}
+	if ip4 := ip.To4(); ip4 != nil {
+		sa := &windows.RawSockaddrInet4{Family: windows.AF_INET}
+		copy(sa.Addr[:], ip4)
+		*(*[2]byte)(unsafe.Pointer(&sa.Port)) = port
+		return unsafe.Pointer(sa), nil
+	}
+	sa := &windows.RawSockaddrInet6{Family: windows.AF_INET6}
+	copy(sa.Addr[:], ip)
+	*(*[2]byte)(unsafe.Pointer(&sa.Port)) = port
+	return unsafe.Pointer(sa), nil
+}
+
+func certFriendlyName(ctx *windows.CertContext) string {
+	var size uint32
+	if r, _, _ := procCertGetCertificateContextProperty.Call(uintptr(unsafe.Pointer(ctx)), certFriendlyNamePropID, 0, uintptr(unsafe.Pointer(&size))); r == 0 || size < 2 {
+		return ""
+	}
+	buf := make([]uint16, size/2)
+	if r, _, _ := procCertGetCertificateContextProperty.Call(uintptr(unsafe.Pointer(ctx)), certFriendlyNamePropID, uintptr(unsafe.Pointer(&buf[0])), uintptr(unsafe.Pointer(&size))); r == 0 {
+		return ""
+	}
+	return windows.UTF16ToString(buf)
+}
+
+// certHash finds the certificate of the binding and returns its SHA-1 hash
+// and the store it is in.
+func (b *CertBinding) certHash() ([]byte, string, error) {
+	store := b.Store
+	if store == "" {
+		store = "My"
+	}
+	thumbprint := strings.ToLower(strings.Replace(b.Thumbprint, " ", "", -1))
+	if thumbprint == "" && b.FriendlyName == "" {
+		return nil, "", fmt.Errorf("Set Thumbprint or FriendlyName")
+	}
+	name, err := windows.UTF16PtrFromString(store)
+	if err != nil {
+		return nil, "", err
+	}
+	h, err := windows.CertOpenStore(windows.CERT_STORE_PROV_SYSTEM, 0, 0,
+		windows.CERT_SYSTEM_STORE_LOCAL_MACHINE|windows.CERT_STORE_OPEN_EXISTING_FLAG|windows.CERT_STORE_READONLY_FLAG, uintptr(unsafe.Pointer(name)))
+	if err != nil {
+		return nil, "", fmt.Errorf("Failed to open the LocalMachine\\%s store: %v", store, err)
+	}
+	defer windows.CertCloseStore(h, 0)
+	var best []byte
+	var bestCert *x509.Certificate
+	var ctx *windows.CertContext
+	for {
+		if ctx, _ = windows.CertEnumCertificatesInStore(h, ctx); ctx == nil {
+			break
+		}
+		der := append([]byte{}, unsafe.Slice(ctx.EncodedCert, ctx.Length)...)
+		sum := sha1.Sum(der)
+		if thumbprint != "" {
+			if hex.EncodeToString(sum[:]) == thumbprint {
+				windows.CertFreeCertificateContext(ctx)
+				return sum[:], store, nil
+			}
+			continue
+		}
+		if certFriendlyName(ctx) != b.FriendlyName {
+			continue
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err == nil && (bestCert == nil || cert.NotAfter.After(bestCert.NotAfter)) {
+			best, bestCert = sum[:], cert
+		}
+	}
+	if best == nil {
+		what := "thumbprint " + b.Thumbprint
+		if thumbprint == "" {
+			what = "friendly name " + b.FriendlyName
+		}
+		return nil, "", fmt.Errorf("No certificate with %s in LocalMachine\\%s", what, store)
+	}
+	return best, store, nil
+}
+
+// addCertBindings binds the CertBindings, replacing existing bindings of
+// the same addresses.
+func addCertBindings(conf *Config) error {
+	for i := range conf.CertBindings {
+		b := &conf.CertBindings[i]
+		sa, err := b.sockaddr()
+		if err != nil {
+			return fmt.Errorf("Certificate binding %s: %v", b.ipPort(), err)
+		}
+		hash, store, err := b.certHash()
+		if err != nil {
+			return fmt.Errorf("Certificate binding %s: %v", b.ipPort(), err)
+		}
+		storeName, err := windows.UTF16PtrFromString(store)
+		if err != nil {
+			return err
+		}
+		set := httpServiceConfigSSLSet{IPPort: sa, HashLength: uint32(len(hash)), Hash: &hash[0], AppID: wswAppID, StoreName: storeName}
+		// The address may not be bound yet.
+		httpServiceConfig(procHttpDeleteServiceConfiguration, httpServiceConfigSSLCert, unsafe.Pointer(&set), unsafe.Sizeof(set))
+		if err := httpServiceConfig(procHttpSetServiceConfiguration, httpServiceConfigSSLCert, unsafe.Pointer(&set), unsafe.Sizeof(set)); err != nil {
+			return fmt.Errorf("Failed to bind a certificate to %s: %v", b.ipPort(), err)
+		}
+		infof("Bound certificate %s to %s", hex.EncodeToString(hash), b.ipPort())
+	}
+	return nil
+}
+
+// removeCertBindings deletes the bindings, only warning about those that
+// cannot be, so that uninstalling goes on.
+func removeCertBindings(conf *Config) {
+	for i := range conf.CertBindings {
+		b := &conf.CertBindings[i]
+		sa, err := b.sockaddr()
+		if err != nil {
+			continue
+		}
+		set := httpServiceConfigSSLSet{IPPort: sa}
+		err = httpServiceConfig(procHttpDeleteServiceConfiguration, httpServiceConfigSSLCert, unsafe.Pointer(&set), unsafe.Sizeof(set))
+		if err != nil && err != windows.ERROR_FILE_NOT_FOUND {
+			warnf("Failed to remove the certificate binding of %s: %v", b.ipPort(), err)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Nagios plugin exit codes, which "wsw -a check" exits with.
+const (
+	nagiosOK = iota
+	nagiosWarning
+	nagiosCritical
+	nagiosUnknown
+)
+
+var nagiosStates = []string{"OK", "WARNING", "CRITICAL", "UNKNOWN"}
+
+// checkService prints the health of the service the way a Nagios plugin
+// does and returns the matching exit code. It is critical when the service
+// or one of its processes is down, and warning (critical) with warn (crit)
+// or more restarts in the last 24 hours, 0 disabling either.
+func checkService(config *Config, warn, crit int) int {
+	code, summary := nagiosOK, ""
+	var perf []string
+	state, err := queryServiceState(config.Name)
+	if agentRunning(config.Name) {
+		state, err = "running", nil
+	}
+	// A probe reads the state directory, it never creates it.
+	dir, rerr := peekStateDir(config)
+	var st *runState
+	if rerr == nil {
+		st, rerr = readRunStateFile(filepath.Join(dir, "run.json"))
+	}
+	switch {
+	case err != nil:
+		code, summary = nagiosUnknown, err.Error()
+	case state != "running":
+		code, summary = nagiosCritical, fmt.Sprintf("%s is %s", config.Name, state)
+	case rerr != nil:
+		code, summary = nagiosUnknown, fmt.Sprintf("%s is running but its run state is unreadable: %v", config.Name, rerr)
+	default:
+		now := time.Now()
+		stats, _ := readStatsFile(filepath.Join(dir, "stats.json"))
+		var down []string
+		up, restarts := 0, 0
+		var uptime time.Duration
+		for _, cs := range st.Children {
+			if created, _, err := processInfo(cs.PID); err == nil && created.Equal(cs.Created) {
+				if up == 0 || now.Sub(cs.Started) < uptime {
+					uptime = now.Sub(cs.Started)
+				}
+				up++
+			} else {
+				down = append(down, cs.Name)
+			}
+			if s := stats[cs.Name]; s != nil {
+				restarts += len(recentRestarts(s.Restarts, now))
+			}
+		}
+		summary = fmt.Sprintf("%s is running, %d of %d processes up", config.Name, up, len(st.Children))
+		if up != 0 {
+			summary += fmt.Sprintf(" for %s", uptime.Round(time.Second))
+		}
+		if restarts != 0 {
+			summary += fmt.Sprintf(", %d restarts in the last 24h", restarts)
+		}
+		// Between the runs of a scheduled or oneshot service nothing is up.
+		if len(down) != 0 && config.Mode != "scheduled" && config.Mode != "oneshot" {
+			code = nagiosCritical
+			summary += ", not running: " + strings.Join(down, ", ")
+		} else if crit > 0 && restarts >= crit {
+			code = nagiosCritical
+		} else if warn > 0 && restarts >= warn {
+			code = nagiosWarning
+		}
+		threshold := func(n int) string {
+			if n <= 0 {
+				return ""
+			}
+			return strconv.Itoa(n)
+		}
+		perf = append(perf,
+			fmt.Sprintf("restarts=%d;%s;%s;0", restarts, threshold(warn), threshold(crit)),
+			fmt.Sprintf("processes=%d;;;0;%d", up, len(st.Children)),
+			fmt.Sprintf("uptime=%ds", int(uptime/time.Second)))
+	}
+	if len(perf) != 0 {
+		summary += "|" + strings.Join(perf, " ")
+	}
+	fmt.Println(nagiosStates[code], summary)
+	return code
+}
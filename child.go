@@ -0,0 +1,941 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Process describes one child process run by the service.
+type Process struct {
+	// Name identifies the process in Processes; the top level process is
+	// named after the service.
+	Name string
+
+	Dir  string
+	Exec string
+	Args []string
+	Env  []string
+	// PathPrepend and PathAppend add directories, relative to Dir, to the
+	// front and the end of the child's PATH, after Env.
+	PathPrepend, PathAppend []string
+	// RefreshEnv builds the environment on the machine and user variables
+	// the registry holds at each launch rather than those the service
+	// started with, picking up newly installed runtimes. Windows only.
+	RefreshEnv bool
+	// EnvFiles are files of NAME=value lines, relative to Dir, read on
+	// every launch. Env overrides them. A file named with a leading "-" may
+	// be missing.
+	EnvFiles []string
+	// ExecSHA256 and ExecSigner, when set, must match the executable before
+	// every launch: its hex SHA-256, and the subject of its Authenticode
+	// signing certificate or "*" for any valid signature.
+	ExecSHA256, ExecSigner string
+	// InheritEnv, when false, gives the child only Env plus the few
+	// variables programs need, see minimalEnv, instead of the wrapper's
+	// environment.
+	InheritEnv *bool
+
+	// RawArgs is appended verbatim to the executable path instead of Args,
+	// for programs such as cmd.exe and msiexec that parse the command line
+	// themselves.
+	RawArgs string
+
+	// Shell runs Exec as a script through cmd, powershell or pwsh.
+	Shell string
+	// PidFile, relative to Dir, holds the process's PID on its first line
+	// and the wrapper's on the second while the process runs.
+	PidFile string
+	// StopSignal, "SIGTERM" (default), "SIGINT", "SIGQUIT", "SIGHUP",
+	// "SIGUSR1" or "SIGUSR2", asks the process group to exit on Unix; what
+	// is left after StopTimeout seconds (default 10) is killed.
+	StopSignal  string
+	StopTimeout int
+
+	Stderr, Stdout string
+	// LogMaxSize rotates Stdout and Stderr once they grow past a size such as
+	// "10MB", keeping LogMaxFiles (default 5) older files as <file>.1 to .N.
+	LogMaxSize  string
+	LogMaxFiles int
+	// LogPrefix starts every output line; {name} is replaced by the process
+	// name and {time} by the current time.
+	LogPrefix string
+	// LogEncoding is "utf-8" (default), "utf-8-bom" or "utf-16" (little
+	// endian with a byte order mark) for the log files, and LogNewline,
+	// "crlf" or "lf", normalizes their line endings.
+	LogEncoding string
+	LogNewline  string
+	// LogRotateOnStart moves the previous Stdout and Stderr aside, with a
+	// timestamp suffix, every time the service starts.
+	LogRotateOnStart bool
+	// LogMaxLineLength cuts longer output lines (default 1MB) and LogBinary,
+	// "hex" or "drop", renders or drops lines that look like binary data.
+	LogMaxLineLength int
+	LogBinary        string
+	// LogDedupe collapses repeated lines into "last message repeated N
+	// times" and LogRateLimit caps the lines per second of each stream.
+	LogDedupe    bool
+	LogRateLimit int
+	// LogFilters drop or reroute matching output lines before they are
+	// written or forwarded.
+	LogFilters []LogFilter
+	// LogForward ships output lines to Fluentd, Graylog, Elasticsearch or
+	// Logstash.
+	LogForward []LogForward
+	// Stdin is a file path, "text:<literal input>", or "pipe" to feed the
+	// child from the \\.\pipe\wsw-<Name>-stdin named pipe.
+	Stdin string
+	// ConPTY runs the child under a pseudo console, for apps that need a real
+	// console. Its output, including VT sequences, goes to Stdout.
+	ConPTY bool
+	// UserSession starts the child on the desktop of the user logged on to
+	// the console, waiting for a logon if needed. wsw must run as
+	// LocalSystem.
+	UserSession bool
+
+	// Download lists files fetched before every launch.
+	Download []Download
+
+	// Replicas runs this many instances of the process. In Args, RawArgs, Env,
+	// Stdout, Stderr, PidFile and Ready, {instance} is replaced by the 0-based
+	// instance index and {port} by Port plus that index; a single instance
+	// with a Port gets {port} too.
+	Replicas int
+	Port     int
+
+	// OnPause and OnContinue run when the service is paused and continued;
+	// without them the child's processes are suspended and resumed.
+	OnPause, OnContinue *Action
+	// Controls maps user defined SCM control codes, "128" to "255", to
+	// actions, for `sc control <service> <code>`.
+	Controls map[string]*Action
+	// OnSessionChange runs when a user logs on or off, locks or unlocks the
+	// workstation, or connects to or disconnects from a session.
+	OnSessionChange *Action
+	// OnSecretChange is "restart", "hook" or "ignore" (default): what to do
+	// once a secret the process was launched with reads differently, which
+	// is checked every SecretPollInterval (default 300) seconds. "hook" runs
+	// SecretHook. Leased Vault secrets are kept up by the lease instead.
+	OnSecretChange     string
+	SecretPollInterval int
+	SecretHook         *Action
+
+	// StopOnSuspend stops the process before the machine goes to sleep and
+	// starts it again on resume, for apps whose connections do not survive
+	// standby.
+	StopOnSuspend bool
+	// Watch lists file globs, relative to Dir, whose changes restart the
+	// process once they have been quiet for WatchDelay (default 2) seconds.
+	Watch      []string
+	WatchDelay int
+
+	// Essential, when false, marks an auxiliary process whose exit does not
+	// stop the service.
+	Essential *bool
+	// Restart is "never", "on-failure" or "always"; it defaults to never for
+	// essential processes and always for the others. Restarts back off from
+	// RestartDelay (default 1) doubling up to RestartMaxDelay (default 60)
+	// seconds. More than RestartLimit restarts within RestartWindow (default
+	// 60) seconds is a crash loop and the process is given up on.
+	Restart                       string
+	RestartDelay, RestartMaxDelay int
+	RestartLimit, RestartWindow   int
+	// CheckArgs, such as ["--validate-config"], runs a new build once
+	// before upgrade or deploy switches to it; it must exit 0.
+	CheckArgs []string
+	// WER sets up Windows Error Reporting for the process.
+	WER *WER
+	// CrashDumps keeps this many mini dumps, written by Windows Error
+	// Reporting into a dumps folder next to the logs, of crashes.
+	CrashDumps int
+	// Rollback keeps a copy of the executable once it ran for RestartWindow
+	// and, when a new one hits RestartLimit, puts that copy back.
+	Rollback bool
+
+	// After names processes that must be ready before this one starts.
+	After []string
+	// SidecarOf binds the process to a main process: it starts once the main
+	// process is ready, stops before it and restarts along with it. Sidecars
+	// are not Essential unless set.
+	SidecarOf string
+	// Ready tells when the process is ready; without it a process is ready
+	// as soon as it started.
+	Ready *Probe
+}
+
+// child supervises one configured process.
+type child struct {
+	*Process
+	prg  *program
+	name string
+	// base is the configured process name, shared by all replicas.
+	base string
+	// instance is the replica index, or -1 when not replicated.
+	instance int
+
+	cmd *exec.Cmd
+	// fileEnv are the entries of the EnvFiles.
+	fileEnv []string
+	// exe is the resolved Exec.
+	exe   string
+	proc  *os.Process
+	group *processGroup
+	dir   string
+
+	// restarts counts how often the child was started again by this wrapper.
+	restarts int
+	// exitCode is the exit code of the last run, failure the wsw exit code
+	// when wsw gave up on it.
+	exitCode int
+	failure  int
+	// backoff is the current restart delay and recent the times of the
+	// restarts in the crash loop window.
+	backoff time.Duration
+	recent  []time.Time
+
+	mu sync.Mutex
+	// ready is closed once the Ready probe of the current run passed, exited
+	// once the run is over.
+	ready, exited chan struct{}
+	// next, when set, asks supervise to start the child again after it
+	// exits; it is closed once the new run is prepared.
+	next chan struct{}
+	// pending is the Process a reload brought, taken up before the next run
+	// is prepared.
+	pending *Process
+	// running and queued track scheduled runs.
+	running, queued bool
+	// hold, when set, keeps supervise from starting the child again until it
+	// is closed.
+	hold chan struct{}
+	// registered is set while the child is registered with Consul.
+	registered bool
+
+	// sidecars are the children bound to this one through SidecarOf.
+	sidecars []*child
+
+	// output counts what the child wrote in the current run, nil for an
+	// adopted child.
+	output *outputCounter
+
+	// stdin is where the stdin pipe writes for the current run.
+	stdin     io.Writer
+	stdinOnce sync.Once
+
+	// attach passes the output on to attached consoles.
+	attach     attachHub
+	attachOnce sync.Once
+}
+
+// newChildren returns the children for the top level process, if it has an
+// Exec, followed by Processes.
+func (p *program) newChildren() ([]*child, error) {
+	var procs []*Process
+	if p.Exec != "" {
+		procs = append(procs, &p.Process)
+	}
+	for i := range p.Processes {
+		if p.Processes[i].Name == "" {
+			return nil, fmt.Errorf("Processes[%d] has no Name", i)
+		}
+		procs = append(procs, &p.Processes[i])
+	}
+	if len(procs) == 0 {
+		return nil, fmt.Errorf("No Exec or Processes configured")
+	}
+	var children []*child
+	bases := map[string]bool{}
+	for _, proc := range procs {
+		base := proc.Name
+		if proc == &p.Process {
+			base = p.Name
+		}
+		if err := checkRestartPolicy(proc); err != nil {
+			return nil, fmt.Errorf("Process %q: %v", base, err)
+		}
+		if err := checkControls(proc); err != nil {
+			return nil, fmt.Errorf("Process %q: %v", base, err)
+		}
+		if err := checkSecretChange(proc); err != nil {
+			return nil, fmt.Errorf("Process %q: %v", base, err)
+		}
+		if err := checkWatch(proc); err != nil {
+			return nil, fmt.Errorf("Process %q: %v", base, err)
+		}
+		if err := checkLogForward(proc); err != nil {
+			return nil, fmt.Errorf("Process %q: %v", base, err)
+		}
+		if err := checkLogFilters(proc); err != nil {
+			return nil, fmt.Errorf("Process %q: %v", base, err)
+		}
+		if err := checkLogEncoding(proc); err != nil {
+			return nil, fmt.Errorf("Process %q: %v", base, err)
+		}
+		if err := checkWER(proc); err != nil {
+			return nil, fmt.Errorf("Process %q: %v", base, err)
+		}
+		if proc.LogBinary != "" && proc.LogBinary != "hex" && proc.LogBinary != "drop" {
+			return nil, fmt.Errorf("Process %q: Unknown LogBinary %q, expected hex or drop", base, proc.LogBinary)
+		}
+		bases[base] = true
+		if proc.Replicas <= 1 {
+			// A single instance with a Port gets {port} filled in all the same.
+			if proc.Port != 0 {
+				proc = proc.replica(0)
+			}
+			children = append(children, &child{Process: proc, prg: p, name: base, base: base, instance: -1})
+			continue
+		}
+		for i := 0; i < proc.Replicas; i++ {
+			children = append(children, &child{
+				Process:  proc.replica(i),
+				prg:      p,
+				name:     fmt.Sprintf("%s-%d", base, i),
+				base:     base,
+				instance: i,
+			})
+		}
+	}
+	names := map[string]*child{}
+	for _, c := range children {
+		if names[c.name] != nil {
+			return nil, fmt.Errorf("Duplicate process name %q", c.name)
+		}
+		names[c.name] = c
+	}
+	for _, c := range children {
+		if c.SidecarOf == c.base {
+			return nil, fmt.Errorf("Process %q is a sidecar of itself", c.base)
+		}
+		for _, after := range c.after() {
+			if !bases[after] {
+				return nil, fmt.Errorf("Process %q starts after unknown process %q", c.base, after)
+			}
+		}
+		for _, main := range children {
+			if c.SidecarOf != "" && main.base == c.SidecarOf {
+				main.sidecars = append(main.sidecars, c)
+			}
+		}
+	}
+	// Refuse cycles, which would leave their members waiting forever.
+	state := map[string]int{}
+	var visit func(c *child) error
+	visit = func(c *child) error {
+		switch state[c.base] {
+		case 1:
+			return fmt.Errorf("Process %q depends on itself through After", c.base)
+		case 2:
+			return nil
+		}
+		state[c.base] = 1
+		for _, after := range c.after() {
+			for _, dep := range children {
+				if dep.base != after {
+					continue
+				}
+				if err := visit(dep); err != nil {
+					return err
+				}
+			}
+		}
+		state[c.base] = 2
+		return nil
+	}
+	for _, c := range children {
+		if err := visit(c); err != nil {
+			return nil, err
+		}
+	}
+	return children, nil
+}
+
+// replica returns instance i of a replicated process, with {instance} and
+// {port} filled in.
+func (proc *Process) replica(i int) *Process {
+	r := strings.NewReplacer("{instance}", strconv.Itoa(i), "{port}", strconv.Itoa(proc.Port+i))
+	list := func(in []string) []string {
+		if in == nil {
+			return nil
+		}
+		out := make([]string, len(in))
+		for i, s := range in {
+			out[i] = r.Replace(s)
+		}
+		return out
+	}
+	inst := *proc
+	inst.Args = list(proc.Args)
+	inst.Env = list(proc.Env)
+	inst.EnvFiles = list(proc.EnvFiles)
+	inst.PathPrepend = list(proc.PathPrepend)
+	inst.PathAppend = list(proc.PathAppend)
+	inst.RawArgs = r.Replace(proc.RawArgs)
+	inst.Stdout = r.Replace(proc.Stdout)
+	inst.Stderr = r.Replace(proc.Stderr)
+	inst.PidFile = r.Replace(proc.PidFile)
+	if proc.Ready != nil {
+		ready := *proc.Ready
+		ready.TCP = r.Replace(ready.TCP)
+		ready.HTTP = r.Replace(ready.HTTP)
+		inst.Ready = &ready
+	}
+	return &inst
+}
+
+// after lists the processes that must be ready before the child starts,
+// including the one it is a sidecar of.
+func (c *child) after() []string {
+	if c.SidecarOf == "" {
+		return c.After
+	}
+	return append([]string{c.SidecarOf}, c.After...)
+}
+
+// label names the child in log messages.
+func (c *child) label() string {
+	if c.name == c.prg.Name {
+		return c.prg.DisplayName
+	}
+	return c.prg.DisplayName + "/" + c.name
+}
+
+// fullName is unique per machine: the service name, then the process name.
+func (c *child) fullName() string {
+	if c.name == c.prg.Name {
+		return c.prg.Name
+	}
+	return c.prg.Name + "-" + c.name
+}
+
+// prepare resolves the directory and executable of the child and builds its
+// command.
+func (c *child) prepare() error {
+	// Verify home directory.
+	dir, err := c.workDir()
+	if err != nil {
+		return err
+	}
+	tracef("%s: Dir %q resolved to %s", c.label(), c.Dir, dir)
+	if c.RawArgs != "" && len(c.Args) != 0 {
+		return fmt.Errorf("Args and RawArgs are mutually exclusive")
+	}
+	if err := checkStopSignal(c.StopSignal); err != nil {
+		return err
+	}
+	c.dir = dir
+	if err := c.readEnvFiles(); err != nil {
+		return err
+	}
+	if err := checkSecrets(append(append(append([]string{c.RawArgs}, c.Args...), c.fileEnv...), c.Env...)...); err != nil {
+		return err
+	}
+	if err := c.defaultLogs(); err != nil {
+		return err
+	}
+	// Downloads come first, they may bring the executable.
+	if err := c.download(); err != nil {
+		return err
+	}
+	// Look for exec.
+	fullExec, err := c.lookExec(c.Exec)
+	if err != nil {
+		return fmt.Errorf("Failed to find executable %q: %v", c.Exec, err)
+	}
+	tracef("%s: Exec %q resolved to %s", c.label(), c.Exec, fullExec)
+	if err := c.verifyExec(fullExec); err != nil {
+		logger.Errorf("Refusing to start %s: %v", c.label(), err)
+		return err
+	}
+	c.exe = fullExec
+	c.prepareDumps()
+	if len(c.Download) != 0 {
+		if err := saveVersion(c.prg.Config, "download", fullExec, fullExec, false); err != nil {
+			logger.Warningf("%s: failed to cache version: %v", c.label(), err)
+		}
+	}
+	c.cmd = exec.Command(fullExec, c.Args...)
+	c.cmd.SysProcAttr = newSysProcAttr()
+	c.cmd.Dir = dir
+	if c.Shell != "" {
+		if err := setShell(c.cmd, c.Shell, c.RawArgs); err != nil {
+			return err
+		}
+	} else if c.RawArgs != "" {
+		setRawArgs(c.cmd, c.RawArgs)
+	}
+	c.cmd.Env = c.childEnv(c.baseEnv())
+	c.mu.Lock()
+	c.ready = make(chan struct{})
+	c.exited = make(chan struct{})
+	c.mu.Unlock()
+	return nil
+}
+
+// current returns the channels of the current run.
+func (c *child) current() (ready, exited chan struct{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ready, c.exited
+}
+
+// workDir returns the absolute directory the child runs in: Dir, or the
+// directory of wsw when unset. The wrapper's own working directory is left
+// untouched.
+func (c *child) workDir() (string, error) {
+	dir := c.Dir
+	if dir == "" {
+		execDir, _, err := getExecPath()
+		if err != nil {
+			return "", err
+		}
+		dir = execDir
+	}
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	fi, err := os.Stat(dir)
+	if err != nil {
+		return "", err
+	} else if !fi.IsDir() {
+		return "", fmt.Errorf("Dir %q is not a directory", dir)
+	}
+	return dir, nil
+}
+
+// lookExec resolves name the way it was found when wsw changed into dir:
+// relative to dir first, then on wsw's PATH.
+func lookExec(dir, name string) (string, error) {
+	return lookExecIn(dir, name, os.Getenv("PATH"))
+}
+
+// lookExec resolves name relative to the child's directory first, then on
+// the PATH the child gets.
+func (c *child) lookExec(name string) (string, error) {
+	return lookExecIn(c.dir, name, envValue(c.childEnv(c.baseEnv()), "PATH"))
+}
+
+func lookExecIn(dir, name, path string) (string, error) {
+	if filepath.IsAbs(name) {
+		return tracedLookPath(name)
+	}
+	if full, err := tracedLookPath(filepath.Join(dir, name)); err == nil {
+		return full, nil
+	} else if strings.ContainsAny(name, `\/`) {
+		return "", err
+	}
+	tracef("Looking up %q on PATH %s", name, path)
+	for _, d := range filepath.SplitList(path) {
+		if d == "" {
+			continue
+		}
+		if full, err := exec.LookPath(filepath.Join(d, name)); err == nil {
+			tracef("LookPath %q: found %s", name, full)
+			return full, nil
+		}
+	}
+	err := &exec.Error{Name: name, Err: exec.ErrNotFound}
+	tracef("LookPath %q: %v", name, err)
+	return "", err
+}
+
+// tracedLookPath is exec.LookPath, tracing the outcome.
+func tracedLookPath(name string) (string, error) {
+	full, err := exec.LookPath(name)
+	if err != nil {
+		tracef("LookPath %q: %v", name, err)
+	} else {
+		tracef("LookPath %q: found %s", name, full)
+	}
+	return full, err
+}
+
+// childPath resolves a path from the config relative to the child's
+// working directory.
+func (c *child) childPath(name string) string {
+	if name == "" || filepath.IsAbs(name) {
+		return name
+	}
+	return filepath.Join(c.dir, name)
+}
+
+// waitAfter waits until every process in After, including all of its
+// replicas, is ready. It returns false if one of them exited first or the
+// service is stopping.
+func (c *child) waitAfter(children []*child) bool {
+	for _, after := range c.after() {
+		for _, dep := range children {
+			if dep.base != after {
+				continue
+			}
+			ready, exited := dep.current()
+			select {
+			case <-ready:
+			case <-exited:
+				logger.Warningf("Not starting %s: %s exited before it was ready", c.label(), dep.label())
+				return false
+			case <-c.prg.exit:
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// essential tells whether the service stops when the child exits.
+func (c *child) essential() bool {
+	proc := c.process()
+	if proc.Essential == nil {
+		return proc.SidecarOf == ""
+	}
+	return *proc.Essential
+}
+
+// process returns the child's Process, for goroutines other than supervise,
+// which swaps it for a pending one between runs.
+func (c *child) process() *Process {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.Process
+}
+
+// takePending switches to the Process a reload left, if any, while the child
+// is not running.
+func (c *child) takePending() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.pending != nil {
+		c.Process, c.pending = c.pending, nil
+	}
+}
+
+// sidecarList returns the sidecars, which a reload may rewire.
+func (c *child) sidecarList() []*child {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.sidecars
+}
+
+// sidecarSet returns the children that are the sidecar of another, stopped
+// and restarted along with it.
+func (p *program) sidecarSet() map[*child]bool {
+	set := map[*child]bool{}
+	for _, c := range p.children {
+		for _, s := range c.sidecarList() {
+			set[s] = true
+		}
+	}
+	return set
+}
+
+// supervise runs the child until it exits and its restart policy does not
+// start it again, or the service stops. Requested restarts always happen.
+func (c *child) supervise() {
+	for {
+		started := time.Now()
+		c.run()
+		select {
+		case <-c.prg.exit:
+			return
+		default:
+		}
+		next := c.takeNext()
+		if next == nil && c.held() == nil {
+			delay, ok := c.nextRestart(time.Since(started))
+			if !ok {
+				return
+			}
+			select {
+			case <-c.prg.exit:
+				return
+			case <-time.After(delay):
+			}
+			logger.Info("Starting ", c.label(), " again after it exited")
+		}
+		if hold := c.held(); hold != nil {
+			select {
+			case <-hold:
+			case <-c.prg.exit:
+				return
+			}
+		}
+		// A restart may have been requested in the meantime.
+		if next == nil {
+			next = c.takeNext()
+		}
+		c.takePending()
+		sidecars := c.sidecarList()
+		for _, s := range sidecars {
+			s.holdStopped()
+		}
+		c.restarts++
+		c.recordRestart()
+		err := c.prepare()
+		if next != nil {
+			close(next)
+		}
+		if err != nil {
+			logger.Warningf("Failed to restart %s: %v", c.label(), err)
+			return
+		}
+		if len(sidecars) != 0 {
+			go c.releaseSidecars()
+		}
+	}
+}
+
+// held returns the channel keeping the child from starting again, if any.
+func (c *child) held() chan struct{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.hold
+}
+
+// holdStopped stops the child and keeps it stopped until release.
+func (c *child) holdStopped() {
+	c.mu.Lock()
+	if c.hold == nil {
+		c.hold = make(chan struct{})
+	}
+	c.mu.Unlock()
+	c.stop()
+}
+
+func (c *child) release() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.hold != nil {
+		close(c.hold)
+		c.hold = nil
+	}
+}
+
+// releaseSidecars starts the sidecars again once the current run is ready.
+func (c *child) releaseSidecars() {
+	ready, exited := c.current()
+	select {
+	case <-ready:
+		for _, s := range c.sidecarList() {
+			s.release()
+		}
+	case <-exited:
+	case <-c.prg.exit:
+	}
+}
+
+// takeNext returns and clears the pending restart request, if any.
+func (c *child) takeNext() chan struct{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	next := c.next
+	c.next = nil
+	return next
+}
+
+// restart stops the child and waits until it runs and is ready again.
+func (c *child) restart() error {
+	next := make(chan struct{})
+	c.mu.Lock()
+	c.next = next
+	c.mu.Unlock()
+	logger.Info("Restarting ", c.label())
+	// Sidecars stop first and come back once the child is ready again.
+	for _, s := range c.sidecarList() {
+		s.holdStopped()
+	}
+	c.stop()
+	select {
+	case <-next:
+	case <-c.prg.exit:
+		return fmt.Errorf("Service is stopping")
+	}
+	ready, exited := c.current()
+	select {
+	case <-ready:
+		return nil
+	case <-exited:
+		return fmt.Errorf("%s exited after restart", c.label())
+	case <-c.prg.exit:
+		return fmt.Errorf("Service is stopping")
+	}
+}
+
+// run starts the child, or adopts the one a previous wrapper left running,
+// and waits for it to exit.
+func (c *child) run() {
+	ready, exited := c.current()
+	defer close(exited)
+	logger.Info("Starting ", c.label())
+	c.attachOnce.Do(func() { go c.serveAttach() })
+	// Cleared by watch once the child runs.
+	c.setFailure(exitLaunchFailed)
+
+	c.output = nil
+	if proc := c.findOrphan(); proc != nil {
+		logger.Infof("Adopting %s already running with PID %d", c.label(), proc.Pid)
+		c.proc = proc
+		go c.awaitReady(ready, exited)
+		c.watch(waitProcess(proc))
+		return
+	}
+
+	closeOutput, err := c.openOutput()
+	if err != nil {
+		logger.Warningf("%s: %v", c.label(), err)
+		return
+	}
+	defer closeOutput()
+	c.logSnapshot()
+	var stdin io.WriteCloser
+	switch {
+	case c.Stdin == "pipe":
+		w, err := c.cmd.StdinPipe()
+		if err != nil {
+			logger.Warningf("Failed to open std in pipe: %v", err)
+			return
+		}
+		stdin = w
+	case strings.HasPrefix(c.Stdin, "text:"):
+		c.cmd.Stdin = strings.NewReader(strings.TrimPrefix(c.Stdin, "text:"))
+	case c.Stdin != "":
+		f, err := os.Open(c.childPath(c.Stdin))
+		if err != nil {
+			logger.Warningf("Failed to open std in %q: %v", c.Stdin, err)
+			return
+		}
+		defer f.Close()
+		c.cmd.Stdin = f
+	}
+	c.traceCommand(c.cmd)
+	wait := c.cmd.Wait
+	// Containers have no interactive sessions to start the child in.
+	userSession := c.UserSession && !inContainer()
+	if c.UserSession && !userSession {
+		logger.Warningf("%s: ignoring UserSession in a container", c.label())
+	}
+	if c.ConPTY || userSession {
+		var opts spawnOptions
+		if userSession {
+			o, release, err := c.userSessionOptions()
+			if err != nil {
+				logger.Warningf("Failed to get user session: %v", err)
+				return
+			}
+			defer release()
+			opts = o
+		}
+		if c.ConPTY {
+			var proc *os.Process
+			var waitPTY func() error
+			err := c.launch(func() (err error) {
+				proc, waitPTY, err = startConPTY(c.cmd, c.cmd.Stdout, opts)
+				return err
+			})
+			if err != nil {
+				logger.Warningf("Error running: %v", err)
+				return
+			}
+			c.proc, wait = proc, waitPTY
+		} else {
+			started, copied, err := pipeOutput(c.cmd)
+			if err != nil {
+				logger.Warningf("Failed to pipe output: %v", err)
+				return
+			}
+			var proc *os.Process
+			err = c.launch(func() (err error) {
+				proc, err = spawn(c.cmd, opts)
+				return err
+			})
+			started()
+			if err != nil {
+				logger.Warningf("Error running: %v", err)
+				return
+			}
+			c.proc = proc
+			wait = func() error {
+				err := waitProcess(proc)()
+				copied()
+				return err
+			}
+		}
+	} else {
+		if err := c.launch(c.cmd.Start); err != nil {
+			logger.Warningf("Error running: %v", err)
+			return
+		}
+		c.proc = c.cmd.Process
+	}
+	if stdin != nil {
+		c.setStdin(stdin)
+	}
+	c.prg.saveRunState()
+	traceEvent(etwInfo, etwStart, "Process %s started, PID %d", c.label(), c.proc.Pid)
+	go c.awaitReady(ready, exited)
+	c.watch(wait)
+}
+
+// watch puts the running child into a process group and waits for it.
+func (c *child) watch(wait func() error) {
+	started := time.Now()
+	c.setFailure(0)
+	defer waitingFor(c.proc.Pid)()
+	group, err := newProcessGroup(c.proc.Pid)
+	if err != nil {
+		logger.Warningf("Failed to create process group: %v", err)
+	} else {
+		c.group = group
+		defer group.close()
+	}
+	if c.PidFile != "" {
+		c.writePidFile()
+		defer c.removePidFile()
+	}
+	err = wait()
+	c.exitCode = 0
+	if err != nil {
+		c.exitCode = 1
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			c.exitCode = exitErr.ExitCode()
+			if ws, ok := exitErr.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+				// Killed by a signal, reported the way shells do.
+				c.exitCode = 128 + int(ws.Signal())
+			}
+		} else {
+			logger.Warningf("Error running %s: %v", c.label(), err)
+		}
+		if isCrash(c.exitCode) {
+			traceEvent(etwError, etwCrash, "Process %s crashed with %s", c.label(), exitCodeString(c.exitCode))
+			if c.dumpsEnabled() {
+				c.collectDump(c.exitCode, started)
+			}
+		}
+	}
+	traceEvent(etwInfo, etwStop, "Process %s exited with code %s after %v", c.label(), exitCodeString(c.exitCode), time.Since(started).Round(time.Second))
+	c.reportExit(started, group)
+	c.recordExit(time.Since(started), c.exitCode)
+}
+
+func (c *child) setFailure(code int) {
+	c.mu.Lock()
+	c.failure = code
+	c.mu.Unlock()
+}
+
+// exitStatus is the service specific exit code telling why the child ended.
+func (c *child) exitStatus() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.failure != 0 {
+		return c.failure
+	}
+	return c.exitCode
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/kardianos/osext"
+)
+
+// cloneService copies the local config under the new name, with Port and Dir
+// overridden when given, next to a copy of wsw named after it, then installs
+// the copy. It is the config next to wsw that is cloned, without the remote
+// config laid over it.
+func cloneService(name string, port int, dir string) error {
+	if name == "" {
+		return fmt.Errorf("Name the clone: wsw -a clone <name>")
+	}
+	conf, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	if conf.Portable {
+		return fmt.Errorf("Clone a portable service by copying its folder")
+	}
+	if strings.EqualFold(name, conf.Name) {
+		return fmt.Errorf("The clone needs a name other than %q", conf.Name)
+	}
+	if _, err := queryServiceConfig(name); err == nil {
+		return fmt.Errorf("Service %q already exists", name)
+	}
+	clone := *conf
+	clone.Name = name
+	if strings.Contains(conf.DisplayName, conf.Name) {
+		clone.DisplayName = strings.Replace(conf.DisplayName, conf.Name, name, -1)
+	} else {
+		clone.DisplayName = name
+	}
+	if port != 0 {
+		clone.Port = port
+	}
+	if dir != "" {
+		if dir, err = filepath.Abs(dir); err != nil {
+			return err
+		}
+		// Processes sharing the top level Dir move along with it.
+		clone.Processes = append([]Process(nil), conf.Processes...)
+		for i := range clone.Processes {
+			if clone.Processes[i].Dir == conf.Dir {
+				clone.Processes[i].Dir = dir
+			}
+		}
+		clone.Dir = dir
+	}
+	if conf.ConfigURL != "" {
+		warnf("The clone lays %s over its config too, which may set its Name back", conf.ConfigURL)
+	}
+
+	self, err := osext.Executable()
+	if err != nil {
+		return err
+	}
+	exe := filepath.Join(filepath.Dir(self), name+filepath.Ext(self))
+	if _, err := os.Stat(exe); err == nil {
+		return fmt.Errorf("%s already exists", exe)
+	}
+	embedded, err := getEmbeddedConfig()
+	if err != nil {
+		return err
+	}
+	if embedded != nil {
+		// A packaged wsw carries its config, so does the clone.
+		if err := packageConfig(&clone, exe); err != nil {
+			return err
+		}
+		infof("Created %s", exe)
+	} else {
+		data, err := json.MarshalIndent(&clone, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := copyFile(self, exe); err != nil {
+			return err
+		}
+		path := strings.TrimSuffix(exe, filepath.Ext(exe)) + ".json"
+		if err := ioutil.WriteFile(path, data, 0644); err != nil {
+			os.Remove(exe)
+			return err
+		}
+		infof("Created %s and %s", exe, path)
+	}
+
+	args := []string{"-a", "install"}
+	if asTask {
+		args = append(args, "--as-task", "--at", taskTrigger)
+	}
+	if quiet {
+		args = append(args, "-q")
+	}
+	cmd := exec.Command(exe, args...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("Failed to install %s: %v", name, err)
+	}
+	infof("Installed %s", name)
+	return nil
+}
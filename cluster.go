@@ -0,0 +1,19 @@
+package main
+
+// Cluster sets up the resource "wsw -a cluster-register" makes the service
+// in a Windows Server Failover Cluster.
+type Cluster struct {
+	// Group is the clustered role to put the resource in, created when
+	// missing (default Name), and Resource the name of the resource
+	// (default DisplayName).
+	Group, Resource string
+	// Dependencies are cluster resources that must be online first, such as
+	// the role's network name, IP address or disk.
+	Dependencies []string
+	// UseNetworkName makes the service see the network name of the role as
+	// its computer name.
+	UseNetworkName bool
+	// Checkpoints are registry keys under HKLM the cluster copies to the
+	// node the service moves to, on top of wsw's own config key.
+	Checkpoints []string
+}
@@ -0,0 +1,9 @@
+//go:build !windows
+
+package main
+
+import "fmt"
+
+func clusterRegister(conf *Config) error {
+	return fmt.Errorf("Failover Clustering is only supported on Windows")
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// psQuote quotes s as a PowerShell string literal.
+func psQuote(s string) string {
+	return "'" + strings.Replace(s, "'", "''", -1) + "'"
+}
+
+// clusterRegister makes the installed service a Generic Service resource of
+// the cluster, with its dependencies and registry checkpoints, and leaves
+// starting it to the cluster. It is safe to run again, and is run on every
+// node the service is installed on.
+func clusterRegister(conf *Config) error {
+	cl := Cluster{}
+	if conf.Cluster != nil {
+		cl = *conf.Cluster
+	}
+	if cl.Group == "" {
+		cl.Group = conf.Name
+	}
+	if cl.Resource == "" {
+		cl.Resource = conf.DisplayName
+	}
+	_, execname, err := getExecPath()
+	if err != nil {
+		return err
+	}
+	checkpoints := append([]string{`SOFTWARE\` + execname}, cl.Checkpoints...)
+
+	group, res := psQuote(cl.Group), psQuote(cl.Resource)
+	useNetworkName := 0
+	if cl.UseNetworkName {
+		useNetworkName = 1
+	}
+	var deps []string
+	for _, d := range cl.Dependencies {
+		deps = append(deps, "["+d+"]")
+	}
+	script := []string{
+		"$ErrorActionPreference = 'Stop'",
+		"Import-Module FailoverClusters",
+		fmt.Sprintf("if (-not (Get-ClusterGroup -Name %s -ErrorAction SilentlyContinue)) { Add-ClusterGroup -Name %s | Out-Null }", group, group),
+		fmt.Sprintf("if (-not (Get-ClusterResource -Name %s -ErrorAction SilentlyContinue)) { Add-ClusterResource -Name %s -ResourceType 'Generic Service' -Group %s | Out-Null }", res, res, group),
+		fmt.Sprintf("Get-ClusterResource -Name %s | Set-ClusterParameter -Multiple @{ServiceName = %s; UseNetworkName = %d}", res, psQuote(conf.Name), useNetworkName),
+		fmt.Sprintf("$checkpoints = @(Get-ClusterCheckpoint -ResourceName %s -RegistryCheckpoint | ForEach-Object { $_.Name })", res),
+	}
+	if len(deps) != 0 {
+		script = append(script, fmt.Sprintf("Set-ClusterResourceDependency -Resource %s -Dependency %s | Out-Null", res, psQuote(strings.Join(deps, " and "))))
+	}
+	for _, key := range checkpoints {
+		script = append(script, fmt.Sprintf("if ($checkpoints -notcontains %s) { Add-ClusterCheckpoint -ResourceName %s -RegistryCheckpoint %s | Out-Null }", psQuote(key), res, psQuote(key)))
+	}
+	out, err := exec.Command("powershell", "-NoProfile", "-NonInteractive", "-Command", strings.Join(script, "\n")).CombinedOutput()
+	if err != nil {
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			return fmt.Errorf("Failed to register with the cluster: %s", msg)
+		}
+		return fmt.Errorf("Failed to register with the cluster: %v", err)
+	}
+
+	// The cluster starts and stops the service on the node that owns it.
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+	s, err := m.OpenService(conf.Name)
+	if err != nil {
+		return fmt.Errorf("Service %s is not installed on this node: %v", conf.Name, err)
+	}
+	defer s.Close()
+	c, err := s.Config()
+	if err != nil {
+		return err
+	}
+	if c.StartType != mgr.StartManual || c.DelayedAutoStart {
+		c.StartType, c.DelayedAutoStart = mgr.StartManual, false
+		if err := s.UpdateConfig(c); err != nil {
+			return fmt.Errorf("Failed to set the service to manual start: %v", err)
+		}
+	}
+	infof("Registered %s as resource %q of cluster role %q", conf.Name, cl.Resource, cl.Group)
+	return nil
+}
@@ -0,0 +1,11 @@
+package main
+
+const (
+	// configDir holds the configs of installed services, <name>.json named
+	// after the wsw executable.
+	configDir = "/usr/local/etc/wsw"
+	// stateRoot holds the state of non-portable services.
+	stateRoot = "/var/db/wsw"
+	// pipeDir holds the control sockets of running wrappers.
+	pipeDir = "/var/run/wsw"
+)
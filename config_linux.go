@@ -0,0 +1,11 @@
+package main
+
+const (
+	// configDir holds the configs of installed services, <name>.json named
+	// after the wsw executable.
+	configDir = "/etc/wsw"
+	// stateRoot holds the state of non-portable services.
+	stateRoot = "/var/lib/wsw"
+	// pipeDir holds the control sockets of running wrappers.
+	pipeDir = "/run/wsw"
+)
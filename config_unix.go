@@ -0,0 +1,75 @@
+//go:build !windows
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// savedConfigName describes where saveConfig keeps the config copy.
+const savedConfigName = "the copy in " + configDir
+
+// savedConfigUsed is set when loadConfig read the config from configDir,
+// which saveConfig then leaves as it is.
+var savedConfigUsed bool
+
+func savedConfigPath() (string, error) {
+	_, execname, err := getExecPath()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(configDir, strings.TrimSuffix(execname, filepath.Ext(execname))+".json"), nil
+}
+
+// readRootConfig returns the config in configDir, the machine-wide config
+// directory, and its path, or nil if there is none. It is also where
+// saveConfig keeps its copy.
+func readRootConfig() ([]byte, string, error) {
+	path, err := savedConfigPath()
+	if err != nil {
+		return nil, "", err
+	}
+	data, err := readSavedConfig()
+	if os.IsNotExist(err) {
+		return nil, "", nil
+	}
+	return data, path, err
+}
+
+// readSavedConfig returns the config in configDir.
+func readSavedConfig() ([]byte, error) {
+	path, err := savedConfigPath()
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(path)
+	if err == nil {
+		savedConfigUsed = true
+	}
+	return data, err
+}
+
+// saveConfig copies the config to configDir, for when the config next to
+// wsw is gone.
+func saveConfig(data []byte) error {
+	if savedConfigUsed {
+		return nil
+	}
+	path, err := savedConfigPath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(configDir, 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+// systemStateDir is where non-portable services keep their state, one folder
+// per service.
+func systemStateDir() string {
+	return stateRoot
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/sys/windows/registry"
+)
+
+// savedConfigName describes where saveConfig keeps the config snapshot.
+const savedConfigName = "the registry snapshot"
+
+// rootConfigPath is <exe name>.json in %ProgramData%\wsw, the machine-wide
+// config directory.
+func rootConfigPath(exe string) string {
+	root := systemStateDir()
+	if root == "" {
+		return ""
+	}
+	name := filepath.Base(exe)
+	return filepath.Join(root, strings.TrimSuffix(name, filepath.Ext(name))+".json")
+}
+
+// readRootConfig returns the config in the machine-wide config directory and
+// its path, or nil if there is none.
+func readRootConfig() ([]byte, string, error) {
+	_, execname, err := getExecPath()
+	if err != nil {
+		return nil, "", err
+	}
+	path := rootConfigPath(execname)
+	if path == "" {
+		return nil, "", nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, "", nil
+	}
+	return data, path, err
+}
+
+// readSavedConfig returns the config snapshot saved by createConfig.
+func readSavedConfig() ([]byte, error) {
+	_, execname, err := getExecPath()
+	if err != nil {
+		return nil, err
+	}
+	key, err := registry.OpenKey(registry.LOCAL_MACHINE, fmt.Sprintf("SOFTWARE\\%s", execname), registry.READ)
+	if err != nil {
+		return nil, err
+	}
+	defer key.Close()
+	data, _, err := key.GetBinaryValue("config")
+	return data, err
+}
+
+// saveConfig keeps a snapshot of the config in the registry, for when the
+// config file is gone.
+func saveConfig(data []byte) error {
+	_, execname, err := getExecPath()
+	if err != nil {
+		return err
+	}
+	key, _, err := registry.CreateKey(registry.LOCAL_MACHINE, fmt.Sprintf("SOFTWARE\\%s", execname), registry.ALL_ACCESS)
+	if err != nil {
+		return err
+	}
+	defer key.Close()
+	return key.SetBinaryValue("config", data)
+}
+
+// systemStateDir is where non-portable services keep their state, one folder
+// per service.
+func systemStateDir() string {
+	if programData := os.Getenv("ProgramData"); programData != "" {
+		return programData + `\wsw`
+	}
+	return ""
+}
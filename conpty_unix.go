@@ -0,0 +1,15 @@
+//go:build !windows
+
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+)
+
+// startConPTY fails: pseudo consoles are a Windows feature.
+func startConPTY(cmd *exec.Cmd, out io.Writer, opts spawnOptions) (*os.Process, func() error, error) {
+	return nil, nil, errors.New("ConPTY is only supported on Windows")
+}
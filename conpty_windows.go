@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"sync"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var (
+	kernel32                = windows.NewLazySystemDLL("kernel32.dll")
+	procCreatePseudoConsole = kernel32.NewProc("CreatePseudoConsole")
+	procClosePseudoConsole  = kernel32.NewProc("ClosePseudoConsole")
+)
+
+const (
+	procThreadAttributePseudoConsole = 0x00020016
+
+	consoleWidth, consoleHeight = 200, 50
+)
+
+// startConPTY launches cmd attached to a new pseudo console instead of
+// redirected handles. Everything the child writes to its console is copied
+// to out and cmd.Stdin, if set, is fed to it as console input. The returned
+// wait function must be called to release the console.
+func startConPTY(cmd *exec.Cmd, out io.Writer, opts spawnOptions) (*os.Process, func() error, error) {
+	if err := procCreatePseudoConsole.Find(); err != nil {
+		return nil, nil, fmt.Errorf("ConPTY is not supported on this system: %v", err)
+	}
+	if out == nil {
+		out = ioutil.Discard
+	}
+	var inR, inW, outR, outW windows.Handle
+	if err := windows.CreatePipe(&inR, &inW, nil, 0); err != nil {
+		return nil, nil, err
+	}
+	if err := windows.CreatePipe(&outR, &outW, nil, 0); err != nil {
+		windows.CloseHandle(inR)
+		windows.CloseHandle(inW)
+		return nil, nil, err
+	}
+	input := os.NewFile(uintptr(inW), "conpty-in")
+	output := os.NewFile(uintptr(outR), "conpty-out")
+
+	var hpc windows.Handle
+	r, _, _ := procCreatePseudoConsole.Call(uintptr(consoleHeight)<<16|uintptr(consoleWidth),
+		uintptr(inR), uintptr(outW), 0, uintptr(unsafe.Pointer(&hpc)))
+	// The pseudo console holds its own references to these ends.
+	windows.CloseHandle(inR)
+	windows.CloseHandle(outW)
+	if r != 0 {
+		input.Close()
+		output.Close()
+		return nil, nil, fmt.Errorf("CreatePseudoConsole failed: %v", windows.Errno(r))
+	}
+	closePTY := func() {
+		procClosePseudoConsole.Call(uintptr(hpc))
+	}
+
+	opts.pseudoConsole = hpc
+	proc, err := spawn(cmd, opts)
+	if err != nil {
+		closePTY()
+		input.Close()
+		output.Close()
+		return nil, nil, err
+	}
+
+	var copying sync.WaitGroup
+	copying.Add(1)
+	go func() {
+		defer copying.Done()
+		io.Copy(out, output)
+	}()
+	if cmd.Stdin != nil {
+		go io.Copy(input, cmd.Stdin)
+	}
+	waitProc := waitProcess(proc)
+	wait := func() error {
+		err := waitProc()
+		// Closing the console flushes what is left and ends the output pipe.
+		closePTY()
+		copying.Wait()
+		input.Close()
+		output.Close()
+		return err
+	}
+	return proc, wait, nil
+}
@@ -0,0 +1,17 @@
+//go:build !windows
+
+package main
+
+import "os"
+
+// enableColor tells whether the terminal wsw writes to shows colors.
+func enableColor() bool {
+	term := os.Getenv("TERM")
+	return term != "" && term != "dumb"
+}
+
+// isTerminal tells whether f is a terminal rather than a file or pipe.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// Consul registers the processes of the service with the local Consul
+// agent while they run.
+type Consul struct {
+	// Address of the agent defaults to CONSUL_HTTP_ADDR, then
+	// http://127.0.0.1:8500, and Token to CONSUL_HTTP_TOKEN. Token may be a
+	// secret:// reference.
+	Address, Token string
+	// Tags and Meta are set on every registered instance.
+	Tags []string
+	Meta map[string]string
+	// DeregisterAfter, such as "30m", lets Consul remove an instance whose
+	// check stayed critical that long, in case wsw could not deregister it.
+	DeregisterAfter string
+}
+
+// consulService is a service registration of the agent API.
+type consulService struct {
+	ID    string
+	Name  string
+	Port  int               `json:",omitempty"`
+	Tags  []string          `json:",omitempty"`
+	Meta  map[string]string `json:",omitempty"`
+	Check *consulCheck      `json:",omitempty"`
+}
+
+type consulCheck struct {
+	TCP                            string `json:",omitempty"`
+	HTTP                           string `json:",omitempty"`
+	Interval                       string
+	Timeout                        string `json:",omitempty"`
+	DeregisterCriticalServiceAfter string `json:",omitempty"`
+}
+
+func (c *Consul) address() string {
+	addr := c.Address
+	if addr == "" {
+		addr = os.Getenv("CONSUL_HTTP_ADDR")
+	}
+	if addr == "" {
+		return "http://127.0.0.1:8500"
+	}
+	if !strings.Contains(addr, "://") {
+		addr = "http://" + addr
+	}
+	return strings.TrimSuffix(addr, "/")
+}
+
+// do sends a request to the agent API.
+func (c *Consul) do(conf *Config, path string, body interface{}) error {
+	var data []byte
+	if body != nil {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequest("PUT", c.address()+"/v1/"+path, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	token := c.Token
+	if token == "" {
+		token = os.Getenv("CONSUL_HTTP_TOKEN")
+	}
+	if token, err = newSecretResolver(conf).resolve(token); err != nil {
+		return err
+	}
+	if token != "" {
+		req.Header.Set("X-Consul-Token", token)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// consulService returns the registration of the child: the service name,
+// with the process name for Processes, and the port of its instance. The
+// Ready probe becomes the health check.
+func (c *child) consulService() *consulService {
+	conf := c.prg.Consul
+	s := &consulService{ID: c.fullName(), Name: c.prg.Name, Tags: conf.Tags, Meta: conf.Meta}
+	if c.base != c.prg.Name {
+		s.Name += "-" + c.base
+	}
+	proc := c.process()
+	if proc.Port != 0 {
+		s.Port = proc.Port
+		if c.instance > 0 {
+			s.Port += c.instance
+		}
+	}
+	if pr := proc.Ready; pr != nil && (pr.TCP != "" || pr.HTTP != "") {
+		interval := 10 * time.Second
+		if pr.Interval > 0 {
+			interval = pr.interval()
+		}
+		s.Check = &consulCheck{
+			TCP:                            pr.TCP,
+			HTTP:                           pr.HTTP,
+			Interval:                       interval.String(),
+			Timeout:                        pr.interval().String(),
+			DeregisterCriticalServiceAfter: conf.DeregisterAfter,
+		}
+	}
+	return s
+}
+
+// registerConsul registers the child once it is ready. Sidecars, which
+// serve their main process, are left out.
+func (c *child) registerConsul() {
+	if c.prg.Consul == nil || c.process().SidecarOf != "" {
+		return
+	}
+	s := c.consulService()
+	if err := c.prg.Consul.do(c.prg.Config, "agent/service/register", s); err != nil {
+		logger.Warningf("Failed to register %s with Consul: %v", c.label(), err)
+		return
+	}
+	c.mu.Lock()
+	first := !c.registered
+	c.registered = true
+	c.mu.Unlock()
+	if first {
+		logger.Infof("Registered %s with Consul as %s", c.label(), s.ID)
+	}
+}
+
+// deregisterConsul removes the registration of a stopped child.
+func (c *child) deregisterConsul() {
+	c.mu.Lock()
+	registered := c.registered
+	c.registered = false
+	c.mu.Unlock()
+	if !registered {
+		return
+	}
+	if err := c.prg.Consul.do(c.prg.Config, "agent/service/deregister/"+url.PathEscape(c.fullName()), nil); err != nil {
+		logger.Warningf("Failed to deregister %s from Consul: %v", c.label(), err)
+	}
+}
@@ -0,0 +1,15 @@
+//go:build !windows
+
+package main
+
+import "time"
+
+// inContainer is false: only Windows containers need wsw to adjust.
+func inContainer() bool {
+	return false
+}
+
+// containerStopWindow is 0: StopTimeout applies as configured.
+func containerStopWindow() time.Duration {
+	return 0
+}
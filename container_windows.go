@@ -0,0 +1,57 @@
+package main
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/sys/windows/registry"
+)
+
+var (
+	containerOnce sync.Once
+	container     bool
+)
+
+// inContainer tells whether wsw runs in a Windows container, whose images
+// set ContainerType in the Control key and run the container execution
+// agent, cexecsvc.
+func inContainer() bool {
+	containerOnce.Do(func() {
+		if key, err := registry.OpenKey(registry.LOCAL_MACHINE, `SYSTEM\CurrentControlSet\Control`, registry.QUERY_VALUE); err == nil {
+			_, _, err = key.GetIntegerValue("ContainerType")
+			key.Close()
+			if err == nil {
+				container = true
+				return
+			}
+		}
+		if key, err := registry.OpenKey(registry.LOCAL_MACHINE, `SYSTEM\CurrentControlSet\Services\cexecsvc`, registry.QUERY_VALUE); err == nil {
+			key.Close()
+			container = true
+		}
+	})
+	return container
+}
+
+// containerStopWindow is, in a container, how long children get to exit
+// once it stops: WaitToKillServiceTimeout (5 seconds by default), less a
+// second for wsw to finish. It is 0 outside a container.
+func containerStopWindow() time.Duration {
+	if !inContainer() {
+		return 0
+	}
+	wait := 5 * time.Second
+	if key, err := registry.OpenKey(registry.LOCAL_MACHINE, `SYSTEM\CurrentControlSet\Control`, registry.QUERY_VALUE); err == nil {
+		if s, _, err := key.GetStringValue("WaitToKillServiceTimeout"); err == nil {
+			if ms, err := strconv.Atoi(s); err == nil && ms > 0 {
+				wait = time.Duration(ms) * time.Millisecond
+			}
+		}
+		key.Close()
+	}
+	if wait <= 2*time.Second {
+		return wait / 2
+	}
+	return wait - time.Second
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// The running wrapper takes requests from other wsw invocations on the
+// wsw-<Name> named pipe. A request is a single line holding a command and
+// its arguments. The reply is any number of text lines ended by "ok" or
+// "error: <message>".
+
+// errNotRunning is returned by sendControl when no wrapper answers.
+var errNotRunning = errors.New("Service is not running")
+
+// serveControl answers control requests until the pipe fails.
+func (p *program) serveControl() {
+	path := pipePath(p.Name)
+	err := servePipe(path, func(f *os.File) {
+		line, err := bufio.NewReader(f).ReadString('\n')
+		if err != nil && line == "" {
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			return
+		}
+		err = p.control(fields[0], fields[1:], f)
+		if err != nil {
+			fmt.Fprintf(f, "error: %v\n", err)
+		} else {
+			fmt.Fprintln(f, "ok")
+		}
+	})
+	logger.Warningf("Failed to serve control pipe %q: %v", path, err)
+}
+
+func (p *program) control(cmd string, args []string, out io.Writer) error {
+	switch cmd {
+	case "restart":
+		return p.rollingRestart(out)
+	case "reload":
+		return p.reload(out)
+	case "wait-ready":
+		return p.waitReady()
+	case "ping":
+		if p.agent {
+			fmt.Fprintln(out, "agent")
+		}
+		return nil
+	case "stop":
+		// Services are stopped through the service manager, which would
+		// take a wrapper exiting on its own for a failure.
+		if !p.agent && !interactive() {
+			return fmt.Errorf("Stop the service through the service manager")
+		}
+		select {
+		case p.stopRequests <- struct{}{}:
+		default:
+		}
+		return nil
+	}
+	return fmt.Errorf("Unknown command %q", cmd)
+}
+
+// sendControl sends a request to the wrapper running the named service and
+// copies its reply to out.
+func sendControl(name string, out io.Writer, cmd string, args ...string) error {
+	f, err := dialPipe(pipePath(name))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return errNotRunning
+		}
+		return err
+	}
+	defer f.Close()
+	if _, err := fmt.Fprintln(f, strings.Join(append([]string{cmd}, args...), " ")); err != nil {
+		return err
+	}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "ok":
+			return nil
+		case strings.HasPrefix(line, "error: "):
+			return errors.New(strings.TrimPrefix(line, "error: "))
+		}
+		fmt.Fprintln(out, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return io.ErrUnexpectedEOF
+}
+
+// rollingRestart restarts the children one at a time in start order,
+// waiting for each to be ready before moving on.
+func (p *program) rollingRestart(out io.Writer) error {
+	if p.Mode == "scheduled" {
+		fmt.Fprintln(out, "Scheduled processes pick up changes on their next run")
+		return nil
+	}
+	sidecars := p.sidecarSet()
+	for _, c := range p.children {
+		if sidecars[c] {
+			// Restarted along with the process they belong to.
+			continue
+		}
+		fmt.Fprintf(out, "Restarting %s\n", c.label())
+		if err := c.restart(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// waitReady waits until every essential child is ready.
+func (p *program) waitReady() error {
+	for _, c := range p.children {
+		if !c.essential() {
+			continue
+		}
+		ready, exited := c.current()
+		select {
+		case <-ready:
+		case <-exited:
+			return fmt.Errorf("%s exited before it was ready", c.label())
+		case <-p.exit:
+			return fmt.Errorf("Service is stopping")
+		}
+	}
+	return nil
+}
+
+// reload reads the config again and rolls out the settings of its processes
+// with a rolling restart. The set of processes must stay the same; other
+// changes need a service restart, so p.Config stays as the wrapper started
+// with it. Each child takes up its new Process when it starts again, while
+// the sidecar wiring changes at once.
+func (p *program) reload(out io.Writer) error {
+	conf, err := getConfig(true)
+	if err != nil {
+		return err
+	}
+	if conf.Portable {
+		if err := conf.resolvePortable(); err != nil {
+			return err
+		}
+	}
+	next := &program{exit: p.exit, Config: conf}
+	children, err := next.newChildren()
+	if err != nil {
+		return err
+	}
+	if conf.Name != p.Name || len(children) != len(p.children) {
+		return fmt.Errorf("Processes changed, restart the service to apply")
+	}
+	for i, c := range children {
+		if c.name != p.children[i].name {
+			return fmt.Errorf("Processes changed, restart the service to apply")
+		}
+	}
+	createConfig(conf)
+	index := map[*child]int{}
+	for i, c := range children {
+		index[c] = i
+	}
+	for i, c := range p.children {
+		var sidecars []*child
+		for _, s := range children[i].sidecars {
+			sidecars = append(sidecars, p.children[index[s]])
+		}
+		c.mu.Lock()
+		c.pending = children[i].Process
+		c.sidecars = sidecars
+		c.mu.Unlock()
+	}
+	return p.rollingRestart(out)
+}
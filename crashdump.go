@@ -0,0 +1,65 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// dumpWait is how long a crash waits for Windows Error Reporting to write
+// the dump.
+const dumpWait = 10 * time.Second
+
+// dumpDir is WER DumpFolder or the dumps folder next to the child's logs.
+func (c *child) dumpDir() string {
+	if c.WER != nil && c.WER.DumpFolder != "" {
+		return c.childPath(c.WER.DumpFolder)
+	}
+	if path := c.childPath(c.Stderr); path != "" && !strings.EqualFold(c.Stderr, os.DevNull) {
+		return filepath.Join(filepath.Dir(path), "dumps")
+	}
+	return filepath.Join(c.dir, "dumps")
+}
+
+// prepareDumps has Windows Error Reporting write dumps of the executable into
+// dumpDir, keeping dumpCount of them.
+func (c *child) prepareDumps() {
+	if !c.dumpsEnabled() {
+		return
+	}
+	if c.prg.Portable {
+		logger.Warningf("%s: crash dumps need the registry, which portable mode leaves alone", c.label())
+		return
+	}
+	dir := c.dumpDir()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		logger.Warningf("%s: failed to create %q: %v", c.label(), dir, err)
+		return
+	}
+	if err := setLocalDumps(filepath.Base(c.exe), dir, c.dumpCount(), c.dumpType()); err != nil {
+		logger.Warningf("%s: failed to enable crash dumps: %v", c.label(), err)
+	}
+	c.pruneDumps()
+}
+
+// collectDump waits for the dump of a crash that ended a run started at
+// since and logs where it is.
+func (c *child) collectDump(code int, since time.Time) {
+	dir := c.dumpDir()
+	prefix := strings.ToLower(filepath.Base(c.exe))
+	deadline := time.Now().Add(dumpWait)
+	for time.Now().Before(deadline) {
+		matches, _ := filepath.Glob(filepath.Join(dir, "*.dmp"))
+		for _, m := range matches {
+			fi, err := os.Stat(m)
+			if err == nil && fi.ModTime().After(since) && strings.HasPrefix(strings.ToLower(filepath.Base(m)), prefix) {
+				logger.Errorf("%s crashed with 0x%08X, dump written to %s", c.label(), uint32(code), m)
+				c.pruneDumps()
+				return
+			}
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+	logger.Errorf("%s crashed with 0x%08X, no dump found in %s", c.label(), uint32(code), dir)
+}
@@ -0,0 +1,15 @@
+//go:build !windows
+
+package main
+
+import "errors"
+
+// setLocalDumps fails: dumps are written by Windows Error Reporting.
+func setLocalDumps(exe, dir string, count int, dumpType uint32) error {
+	return errors.New("CrashDumps and WER are only supported on Windows")
+}
+
+// withErrorMode runs start; there are no error dialogs to keep away.
+func (c *child) withErrorMode(start func() error) error {
+	return start()
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"sync"
+
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/registry"
+)
+
+// localDumpsKey holds the per-executable Windows Error Reporting dump
+// settings.
+const localDumpsKey = `SOFTWARE\Microsoft\Windows\Windows Error Reporting\LocalDumps\`
+
+// setLocalDumps has WER write dumps, 1 mini or 2 full, of crashes of the
+// named executable into dir, keeping count of them.
+func setLocalDumps(exe, dir string, count int, dumpType uint32) error {
+	key, _, err := registry.CreateKey(registry.LOCAL_MACHINE, localDumpsKey+exe, registry.SET_VALUE)
+	if err != nil {
+		return err
+	}
+	defer key.Close()
+	if err := key.SetExpandStringValue("DumpFolder", dir); err != nil {
+		return err
+	}
+	if err := key.SetDWordValue("DumpCount", uint32(count)); err != nil {
+		return err
+	}
+	return key.SetDWordValue("DumpType", dumpType)
+}
+
+// errorModeMu keeps concurrent launches from seeing each other's error mode.
+var errorModeMu sync.Mutex
+
+// withErrorMode runs start, which creates the child, with the error mode the
+// child inherits set to suppress crash and critical error dialogs when WER
+// NoDialog is set.
+func (c *child) withErrorMode(start func() error) error {
+	if c.WER == nil || !c.WER.NoDialog {
+		return start()
+	}
+	errorModeMu.Lock()
+	defer errorModeMu.Unlock()
+	old := windows.SetErrorMode(windows.SEM_FAILCRITICALERRORS | windows.SEM_NOGPFAULTERRORBOX | windows.SEM_NOOPENFILEERRORBOX)
+	defer windows.SetErrorMode(old)
+	return start()
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// deploy points the Dir link at newDir and restarts the running service
+// onto it. If the processes do not come back ready, the link is flipped back
+// and the service restarted on the previous directory.
+func deploy(conf *Config, newDir string) error {
+	if conf.Dir == "" {
+		return errors.New("Deploy needs Dir to be a link to the current version")
+	}
+	if newDir == "" {
+		return errors.New("Usage: wsw -a deploy <new dir>")
+	}
+	if conf.Portable {
+		if err := conf.resolvePortable(); err != nil {
+			return err
+		}
+	}
+	link, err := filepath.Abs(conf.Dir)
+	if err != nil {
+		return err
+	}
+	old, err := os.Readlink(link)
+	if err != nil {
+		return fmt.Errorf("Dir %q is not a link: %v", link, err)
+	}
+	target, err := filepath.Abs(newDir)
+	if err != nil {
+		return err
+	}
+	if fi, err := os.Stat(target); err != nil {
+		return err
+	} else if !fi.IsDir() {
+		return fmt.Errorf("%q is not a directory", target)
+	}
+	if conf.Exec != "" {
+		c := &child{Process: &conf.Process, prg: &program{Config: conf}, name: conf.Name, instance: -1, dir: target}
+		exe, err := lookExec(target, conf.Exec)
+		if err != nil {
+			return fmt.Errorf("Failed to find executable %q in %q: %v", conf.Exec, target, err)
+		}
+		if err := c.check(exe); err != nil {
+			return err
+		}
+	}
+	state, _ := queryServiceState(conf.Name)
+
+	if err := setLink(link, target); err != nil {
+		return fmt.Errorf("Failed to switch %q: %v", link, err)
+	}
+	infof("Switched %s from %s to %s", link, old, target)
+	if state != "running" {
+		recordHistory(conf, "deploy", fmt.Sprintf("%s -> %s", old, target))
+		return nil
+	}
+	err = sendControl(conf.Name, os.Stdout, "restart")
+	if err == nil {
+		recordHistory(conf, "deploy", fmt.Sprintf("%s -> %s", old, target))
+		return nil
+	}
+
+	warnf("Deploy failed, switching back to %s: %v", old, err)
+	recordHistory(conf, "deploy-failed", fmt.Sprintf("%s -> %s: %v", old, target, err))
+	if lerr := setLink(link, old); lerr != nil {
+		return fmt.Errorf("%v; switching back failed too: %v", err, lerr)
+	}
+	// A failed essential process may have stopped the service already.
+	if serr := stopService(conf.Name); serr != nil {
+		return fmt.Errorf("%v; stopping the service failed: %v", err, serr)
+	}
+	if serr := startService(conf.Name); serr != nil {
+		return fmt.Errorf("%v; starting the previous version failed: %v", err, serr)
+	}
+	return fmt.Errorf("Deploy failed, switched back to %s: %v", old, err)
+}
+
+// setLink makes link a symbolic link to target, replacing the old link.
+func setLink(link, target string) error {
+	tmp := link + ".wsw-new"
+	os.Remove(tmp)
+	if err := os.Symlink(target, tmp); err != nil {
+		return err
+	}
+	// Removes the link only, not what it points to.
+	if err := os.Remove(link); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, link)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/kardianos/osext"
+)
+
+// readSnapshot returns the config copy saved by the last wsw run.
+func readSnapshot() ([]byte, error) {
+	data, err := readPortableConfig()
+	if err != nil || data != nil {
+		return data, err
+	}
+	return readSavedConfig()
+}
+
+func readFileConfig() ([]byte, error) {
+	if data, err := getEmbeddedConfig(); err != nil || data != nil {
+		return data, err
+	}
+	configPath, err := getConfigPath()
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(configPath)
+	if os.IsNotExist(err) {
+		if root, _, rerr := readRootConfig(); rerr != nil || root != nil {
+			return root, rerr
+		}
+	}
+	return data, err
+}
+
+// diffConfig prints the config file, the saved snapshot and the SCM service
+// configuration side by side, marking rows that disagree with "*".
+func diffConfig() error {
+	load := func(read func() ([]byte, error)) (*Config, error) {
+		data, err := read()
+		if err != nil {
+			return nil, err
+		}
+		conf := &Config{}
+		return conf, unmarshalConfig(data, conf)
+	}
+	file, fileErr := load(readFileConfig)
+	snap, snapErr := load(readSnapshot)
+	if fileErr != nil && snapErr != nil {
+		return fmt.Errorf("No config found: %v", fileErr)
+	}
+	name := ""
+	if fileErr == nil {
+		name = file.Name
+	} else {
+		name = snap.Name
+	}
+	scm, scmErr := queryServiceConfig(name)
+
+	exe, _ := osext.Executable()
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "\tField\tFile\tRegistry\tSCM")
+	row := func(field string, get func(c *Config) string, scmValue func(s *scmConfig) string) {
+		cols := []string{}
+		for _, c := range []struct {
+			conf *Config
+			err  error
+		}{{file, fileErr}, {snap, snapErr}} {
+			if c.err != nil {
+				cols = append(cols, "(missing)")
+			} else {
+				cols = append(cols, get(c.conf))
+			}
+		}
+		switch {
+		case scmValue == nil:
+			cols = append(cols, "-")
+		case scmErr != nil:
+			cols = append(cols, "(not installed)")
+		default:
+			cols = append(cols, scmValue(scm))
+		}
+		mark := ""
+		for _, c := range cols[1:] {
+			if c != "-" && c != cols[0] {
+				mark = "*"
+			}
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", mark, field, strings.Join(cols, "\t"))
+	}
+
+	row("DisplayName", func(c *Config) string { return c.DisplayName }, func(s *scmConfig) string { return s.DisplayName })
+	row("Description", func(c *Config) string { return c.Description }, func(s *scmConfig) string { return s.Description })
+	// wsw installs services as automatic, or manual with triggers, and
+	// pointing at itself.
+	row("StartType", func(c *Config) string {
+		if len(c.Triggers) != 0 {
+			return "manual"
+		}
+		return "automatic"
+	}, func(s *scmConfig) string { return s.StartType })
+	row("Dependencies", func(c *Config) string { return strings.Join(c.Dependencies, ", ") }, func(s *scmConfig) string {
+		return strings.Join(s.Dependencies, ", ")
+	})
+	row("LoadOrderGroup", func(c *Config) string { return c.LoadOrderGroup }, func(s *scmConfig) string { return s.LoadOrderGroup })
+	row("Tag", func(c *Config) string { return fmt.Sprint(c.Tag) }, func(s *scmConfig) string { return fmt.Sprint(s.Tag) })
+	row("BinaryPath", func(c *Config) string { return exe }, func(s *scmConfig) string { return binaryExe(s.BinaryPath) })
+	row("Dir", func(c *Config) string { return c.Dir }, nil)
+	row("Exec", func(c *Config) string { return c.Exec }, nil)
+	row("Args", func(c *Config) string { return strings.Join(c.Args, " ") }, nil)
+	row("Env", func(c *Config) string { return strings.Join(c.Env, " ") }, nil)
+	row("Stdout", func(c *Config) string { return c.Stdout }, nil)
+	row("Stderr", func(c *Config) string { return c.Stderr }, nil)
+	return w.Flush()
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/kardianos/osext"
+)
+
+// doctor collects the results of the diagnostics run by "wsw -a doctor".
+type doctor struct {
+	failed int
+}
+
+// report prints a result, its verdict in color; --quiet leaves out passes.
+func (d *doctor) report(verdict, color, check, detail, hint string) {
+	if quiet && verdict == "PASS" {
+		return
+	}
+	emit(os.Stdout, color, verdict, fmt.Sprintf("  %s: %s", check, detail))
+	if hint != "" {
+		emit(os.Stdout, "", "", "      hint: "+hint)
+	}
+}
+
+func (d *doctor) pass(check, detail string) {
+	d.report("PASS", colorGreen, check, detail, "")
+}
+
+func (d *doctor) warn(check, detail, hint string) {
+	d.report("WARN", colorYellow, check, detail, hint)
+}
+
+func (d *doctor) fail(check, detail, hint string) {
+	d.failed++
+	d.report("FAIL", colorRed, check, detail, hint)
+}
+
+// runDoctor checks the config, the installed service and the environment
+// the processes run in, printing a report. It fails if any check failed.
+func runDoctor() error {
+	d := &doctor{}
+	conf, err := getConfig(false)
+	if err != nil {
+		d.fail("config", err.Error(), "Create one with wsw -a init or fix the JSON next to wsw")
+		return fmt.Errorf("%d check(s) failed", d.failed)
+	}
+	if conf.Portable {
+		if err := conf.resolvePortable(); err != nil {
+			d.fail("config", err.Error(), "")
+		}
+	}
+	p := &program{Config: conf}
+	children, err := p.newChildren()
+	if err != nil {
+		d.fail("config", err.Error(), "Fix the config; see the Config section of the README")
+	} else {
+		d.pass("config", fmt.Sprintf("service %q with %d process(es)", conf.Name, len(children)))
+	}
+
+	for _, c := range children {
+		d.checkChild(c)
+	}
+	d.checkService(conf)
+	if !conf.Portable {
+		d.checkRegistry()
+	}
+	if d.failed != 0 {
+		return fmt.Errorf("%d check(s) failed", d.failed)
+	}
+	infof("All checks passed")
+	return nil
+}
+
+func (d *doctor) checkChild(c *child) {
+	check := "process " + c.label()
+	dir, err := c.workDir()
+	if err != nil {
+		d.fail(check, err.Error(), "Set Dir to an existing directory")
+		return
+	}
+	c.dir = dir
+	if err := c.readEnvFiles(); err != nil {
+		d.fail(check, err.Error(), "Fix or create the EnvFiles")
+		return
+	}
+	exe, err := c.lookExec(c.Exec)
+	if err != nil {
+		d.fail(check, fmt.Sprintf("cannot find executable %q: %v", c.Exec, err),
+			"A relative Exec is looked up in Dir, then on PATH; use a full path or fix Dir")
+	} else if err := c.verifyExec(exe); err != nil {
+		d.fail(check, err.Error(), "Update ExecSHA256 or ExecSigner after deploying a new build")
+	} else {
+		d.pass(check, exe)
+	}
+
+	if err := c.defaultLogs(); err != nil {
+		d.fail(check, err.Error(), "")
+		return
+	}
+	for _, name := range []string{c.Stdout, c.Stderr} {
+		if strings.EqualFold(name, os.DevNull) {
+			continue
+		}
+		path := c.childPath(name)
+		err := os.MkdirAll(filepath.Dir(path), 0755)
+		if err == nil {
+			var f *os.File
+			if f, err = os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0777); err == nil {
+				f.Close()
+			}
+		}
+		if err != nil {
+			d.fail("log "+c.label(), err.Error(), fmt.Sprintf("Grant the service account write access to %s", filepath.Dir(path)))
+		} else {
+			d.pass("log "+c.label(), path+" is writable")
+		}
+	}
+	if c.Ready != nil {
+		d.checkProbe(c)
+	}
+}
+
+// checkProbe checks the port of a TCP or HTTP readiness probe: it must answer
+// while the service runs and be free, for the child to take, while it does
+// not.
+func (d *doctor) checkProbe(c *child) {
+	check := "probe " + c.label()
+	addr := c.Ready.TCP
+	if c.Ready.HTTP != "" {
+		u, err := url.Parse(c.Ready.HTTP)
+		if err != nil {
+			d.fail(check, err.Error(), "Fix Ready.HTTP")
+			return
+		}
+		addr = u.Host
+		if u.Port() == "" {
+			if u.Scheme == "https" {
+				addr += ":443"
+			} else {
+				addr += ":80"
+			}
+		}
+	}
+	if addr == "" {
+		return
+	}
+	state, _ := queryServiceState(c.prg.Name)
+	if state == "running" {
+		if err := c.Ready.check(); err != nil {
+			d.fail(check, err.Error(), "The service runs but the probe fails; check the app's logs and the address in Ready")
+		} else {
+			d.pass(check, addr+" answers")
+		}
+		return
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		d.warn(check, fmt.Sprintf("%s is not free: %v", addr, err),
+			"Another process may hold the port; find it with netstat -abno")
+		return
+	}
+	l.Close()
+	d.pass(check, addr+" is free")
+}
+
+func (d *doctor) checkService(conf *Config) {
+	scm, err := queryServiceConfig(conf.Name)
+	if err != nil {
+		d.fail("service", fmt.Sprintf("%q is not installed: %v", conf.Name, err), "Install it with wsw -a install, as administrator")
+		return
+	}
+	exe, _ := osext.Executable()
+	if bin := binaryExe(scm.BinaryPath); !strings.EqualFold(bin, exe) {
+		d.fail("service", fmt.Sprintf("installed for %s, not this wsw (%s)", bin, exe), "Reinstall with wsw -a uninstall and wsw -a install")
+	} else {
+		d.pass("service", fmt.Sprintf("installed, start type %s", scm.StartType))
+	}
+	if scm.DisplayName != conf.DisplayName || scm.Description != conf.Description {
+		d.warn("service", "display name or description differ from the config", "Apply them with wsw -a sync")
+	}
+
+	account := scm.Account
+	switch strings.ToLower(account) {
+	case "", "localsystem", `nt authority\localservice`, `nt authority\networkservice`:
+		d.pass("account", "runs as "+strings.TrimSpace(account+" (built-in)"))
+		return
+	}
+	ok, err := hasLogonAsService(account)
+	switch {
+	case err != nil:
+		d.warn("account", fmt.Sprintf("cannot check the rights of %s: %v", account, err), "Run wsw -a doctor as administrator")
+	case !ok:
+		d.fail("account", account+` lacks "Log on as a service"`,
+			"Grant it in secpol.msc under Local Policies > User Rights Assignment")
+	default:
+		d.pass("account", account+` may log on as a service`)
+	}
+}
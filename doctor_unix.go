@@ -0,0 +1,31 @@
+//go:build !windows
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+// hasLogonAsService is true: any account may run a service.
+func hasLogonAsService(account string) (bool, error) {
+	return true, nil
+}
+
+// checkRegistry checks that the config copy in configDir can be written,
+// which wsw does on every start.
+func (d *doctor) checkRegistry() {
+	if err := os.MkdirAll(configDir, 0755); err != nil {
+		d.fail("config dir", fmt.Sprintf("cannot create %s: %v", configDir, err), "Run as root, or set Portable")
+		return
+	}
+	f, err := ioutil.TempFile(configDir, ".doctor")
+	if err != nil {
+		d.fail("config dir", fmt.Sprintf("cannot write %s: %v", configDir, err), "Run as root, or set Portable")
+		return
+	}
+	f.Close()
+	os.Remove(f.Name())
+	d.pass("config dir", configDir+" is writable")
+}
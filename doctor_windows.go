@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/registry"
+)
+
+var (
+	advapi32                      = windows.NewLazySystemDLL("advapi32.dll")
+	procLsaOpenPolicy             = advapi32.NewProc("LsaOpenPolicy")
+	procLsaEnumerateAccountRights = advapi32.NewProc("LsaEnumerateAccountRights")
+	procLsaFreeMemory             = advapi32.NewProc("LsaFreeMemory")
+	procLsaClose                  = advapi32.NewProc("LsaClose")
+	procLsaNtStatusToWinError     = advapi32.NewProc("LsaNtStatusToWinError")
+)
+
+const policyLookupNames = 0x800
+
+// statusObjectNameNotFound is returned for accounts holding no rights.
+const statusObjectNameNotFound = 0xc0000034
+
+type lsaObjectAttributes struct {
+	Length                   uint32
+	RootDirectory            uintptr
+	ObjectName               uintptr
+	Attributes               uint32
+	SecurityDescriptor       uintptr
+	SecurityQualityOfService uintptr
+}
+
+type lsaUnicodeString struct {
+	Length        uint16
+	MaximumLength uint16
+	Buffer        *uint16
+}
+
+func lsaError(status uintptr) error {
+	code, _, _ := procLsaNtStatusToWinError.Call(status)
+	return windows.Errno(code)
+}
+
+// hasLogonAsService tells whether the account holds SeServiceLogonRight.
+func hasLogonAsService(account string) (bool, error) {
+	sid, _, _, err := windows.LookupSID("", strings.TrimPrefix(account, `.\`))
+	if err != nil {
+		return false, err
+	}
+	var policy uintptr
+	attrs := lsaObjectAttributes{Length: uint32(unsafe.Sizeof(lsaObjectAttributes{}))}
+	if st, _, _ := procLsaOpenPolicy.Call(0, uintptr(unsafe.Pointer(&attrs)), policyLookupNames, uintptr(unsafe.Pointer(&policy))); st != 0 {
+		return false, lsaError(st)
+	}
+	defer procLsaClose.Call(policy)
+	var rights *lsaUnicodeString
+	var count uint32
+	st, _, _ := procLsaEnumerateAccountRights.Call(policy, uintptr(unsafe.Pointer(sid)), uintptr(unsafe.Pointer(&rights)), uintptr(unsafe.Pointer(&count)))
+	if st == statusObjectNameNotFound {
+		return false, nil
+	} else if st != 0 {
+		return false, lsaError(st)
+	}
+	defer procLsaFreeMemory.Call(uintptr(unsafe.Pointer(rights)))
+	list := unsafe.Slice(rights, count)
+	for _, r := range list {
+		name := windows.UTF16ToString(unsafe.Slice(r.Buffer, r.Length/2))
+		if name == "SeServiceLogonRight" {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// checkRegistry checks that the config copy key can be written, which wsw
+// does on every start.
+func (d *doctor) checkRegistry() {
+	_, execname, err := getExecPath()
+	if err != nil {
+		d.fail("registry", err.Error(), "")
+		return
+	}
+	path := fmt.Sprintf(`HKLM\SOFTWARE\%s`, execname)
+	key, err := registry.OpenKey(registry.LOCAL_MACHINE, `SOFTWARE\`+execname, registry.QUERY_VALUE|registry.SET_VALUE)
+	switch {
+	case err == registry.ErrNotExist:
+		d.warn("registry", path+" does not exist yet", "It is created on the first start or install")
+	case err != nil:
+		d.fail("registry", fmt.Sprintf("cannot write %s: %v", path, err), "Run as administrator, or set Portable to keep the registry out of it")
+	default:
+		key.Close()
+		d.pass("registry", path+" is writable")
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Download is a file fetched before the child is launched.
+type Download struct {
+	URL string
+	// To is the destination file, or with Extract the directory the
+	// archive is extracted into; relative to the child's Dir.
+	To string
+	// SHA256 is the expected hex digest of the download.
+	SHA256 string
+	// Auth is "basic", using Username and Password, or "bearer", using
+	// Token.
+	Auth               string
+	Username, Password string
+	Token              string
+	// Extract is the archive format, "zip", "tar" or "tar.gz", to unpack
+	// the download into To; Unzip is short for "zip". StripComponents drops
+	// leading directories from the entry names and Clean empties To first,
+	// so files removed from the bundle do not linger.
+	Extract         string
+	Unzip           bool
+	StripComponents int
+	Clean           bool
+	// FailOnError keeps the child from starting when the download fails;
+	// otherwise the previous copy is used.
+	FailOnError bool
+}
+
+// download fetches every Download of the child in order.
+func (c *child) download() error {
+	for _, d := range c.Download {
+		if err := c.fetch(&d); err != nil {
+			err = fmt.Errorf("Failed to download %q: %v", d.URL, err)
+			if d.FailOnError {
+				return err
+			}
+			logger.Warningf("%s: %v", c.label(), err)
+		}
+	}
+	return nil
+}
+
+func (c *child) fetch(d *Download) error {
+	if d.To == "" {
+		return fmt.Errorf("No To path")
+	}
+	req, err := http.NewRequest("GET", d.URL, nil)
+	if err != nil {
+		return err
+	}
+	switch d.Auth {
+	case "":
+	case "basic":
+		req.SetBasicAuth(d.Username, d.Password)
+	case "bearer":
+		req.Header.Set("Authorization", "Bearer "+d.Token)
+	default:
+		return fmt.Errorf("Unknown Auth %q, expected basic or bearer", d.Auth)
+	}
+	resp, err := doDownload(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Unexpected status %s", resp.Status)
+	}
+
+	format := d.Extract
+	if d.Unzip && format == "" {
+		format = "zip"
+	}
+	to := c.childPath(d.To)
+	dir := filepath.Dir(to)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	// Downloaded next to the destination and renamed into place, so a
+	// failed download leaves the previous copy intact.
+	tmp, err := ioutil.TempFile(dir, ".wsw-download-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	h := sha256.New()
+	_, err = io.Copy(io.MultiWriter(tmp, h), resp.Body)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if d.SHA256 != "" {
+		if sum := hex.EncodeToString(h.Sum(nil)); !strings.EqualFold(sum, d.SHA256) {
+			return fmt.Errorf("Checksum mismatch: got %s, want %s", sum, d.SHA256)
+		}
+	}
+	if format != "" {
+		return unpack(tmp.Name(), format, to, d.StripComponents, d.Clean)
+	}
+	// TempFile creates the file 0600, and the download is often the
+	// child's executable.
+	if err := os.Chmod(tmp.Name(), 0755); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), to)
+}
+
+// unpack extracts the archive into dir. With clean it is extracted next to
+// dir and swapped in, leaving dir untouched if extraction fails.
+func unpack(archive, format, dir string, strip int, clean bool) error {
+	if !clean {
+		return extract(archive, format, dir, strip)
+	}
+	tmp, err := ioutil.TempDir(filepath.Dir(dir), ".wsw-extract-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	if err := extract(archive, format, tmp, strip); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	return os.Rename(tmp, dir)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/kardianos/osext"
+)
+
+// embedMagic marks a config appended to the executable by "wsw -a package".
+// The layout is <exe><config json><uint64 little-endian length><magic>.
+const embedMagic = "WSWCONF1"
+
+// readEmbedded returns the config embedded in the executable at path and the
+// size of the executable without it. data is nil when nothing is embedded.
+func readEmbedded(path string) (data []byte, exeSize int64, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, 0, err
+	}
+	size := fi.Size()
+	trailer := make([]byte, 8+len(embedMagic))
+	if size < int64(len(trailer)) {
+		return nil, size, nil
+	}
+	if _, err := f.ReadAt(trailer, size-int64(len(trailer))); err != nil {
+		return nil, 0, err
+	}
+	if string(trailer[8:]) != embedMagic {
+		return nil, size, nil
+	}
+	n := int64(binary.LittleEndian.Uint64(trailer[:8]))
+	exeSize = size - int64(len(trailer)) - n
+	if n <= 0 || exeSize <= 0 {
+		return nil, 0, fmt.Errorf("Corrupt embedded config in %q", path)
+	}
+	data = make([]byte, n)
+	if _, err := f.ReadAt(data, exeSize); err != nil {
+		return nil, 0, err
+	}
+	return data, exeSize, nil
+}
+
+func getEmbeddedConfig() ([]byte, error) {
+	fullexecpath, err := osext.Executable()
+	if err != nil {
+		return nil, err
+	}
+	data, _, err := readEmbedded(fullexecpath)
+	return data, err
+}
+
+// packageConfig writes a copy of the running executable with config appended
+// to out, defaulting to <Name>.exe next to wsw.
+func packageConfig(config *Config, out string) error {
+	fullexecpath, err := osext.Executable()
+	if err != nil {
+		return err
+	}
+	if out == "" {
+		out = filepath.Join(filepath.Dir(fullexecpath), config.Name+filepath.Ext(fullexecpath))
+	}
+	if abs, err := filepath.Abs(out); err == nil && abs == fullexecpath {
+		return fmt.Errorf("Refusing to package over the running executable %q", out)
+	}
+	_, exeSize, err := readEmbedded(fullexecpath)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+
+	src, err := os.Open(fullexecpath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(out, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0755)
+	if err != nil {
+		return err
+	}
+	if _, err := io.CopyN(dst, src, exeSize); err != nil {
+		dst.Close()
+		return err
+	}
+	trailer := make([]byte, 8, 8+len(embedMagic))
+	binary.LittleEndian.PutUint64(trailer, uint64(len(data)))
+	trailer = append(trailer, embedMagic...)
+	if _, err := dst.Write(append(data, trailer...)); err != nil {
+		dst.Close()
+		return err
+	}
+	return dst.Close()
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"unicode/utf16"
+)
+
+// logHeader returns the byte order mark starting a new log file in the
+// configured LogEncoding.
+func logHeader(encoding string) []byte {
+	switch encoding {
+	case "utf-8-bom":
+		return []byte{0xef, 0xbb, 0xbf}
+	case "utf-16":
+		return []byte{0xff, 0xfe}
+	}
+	return nil
+}
+
+func checkLogEncoding(proc *Process) error {
+	switch proc.LogEncoding {
+	case "", "utf-8", "utf-8-bom", "utf-16":
+	default:
+		return fmt.Errorf("Unknown LogEncoding %q, expected utf-8, utf-8-bom or utf-16", proc.LogEncoding)
+	}
+	switch proc.LogNewline {
+	case "", "crlf", "lf":
+	default:
+		return fmt.Errorf("Unknown LogNewline %q, expected crlf or lf", proc.LogNewline)
+	}
+	return nil
+}
+
+// encodeWriter converts the child's UTF-8 output to UTF-16LE and normalizes
+// line endings to newline on the way to a log file.
+type encodeWriter struct {
+	w       io.Writer
+	utf16   bool
+	newline string
+	// pending holds a trailing CR or partial UTF-8 sequence until the next
+	// write tells how it goes on.
+	pending []byte
+}
+
+func (e *encodeWriter) Write(b []byte) (int, error) {
+	data := append(e.pending, b...)
+	keep := 0
+	if e.newline != "" && len(data) != 0 && data[len(data)-1] == '\r' {
+		keep = 1
+	} else if e.utf16 {
+		keep = partialUTF8(data)
+	}
+	e.pending = append([]byte(nil), data[len(data)-keep:]...)
+	if _, err := e.w.Write(e.convert(data[:len(data)-keep])); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (e *encodeWriter) convert(data []byte) []byte {
+	switch e.newline {
+	case "lf":
+		data = bytes.Replace(data, []byte("\r\n"), []byte("\n"), -1)
+	case "crlf":
+		data = bytes.Replace(data, []byte("\r\n"), []byte("\n"), -1)
+		data = bytes.Replace(data, []byte("\n"), []byte("\r\n"), -1)
+	}
+	if !e.utf16 {
+		return data
+	}
+	units := utf16.Encode([]rune(string(data)))
+	out := make([]byte, 0, 2*len(units))
+	for _, u := range units {
+		out = append(out, byte(u), byte(u>>8))
+	}
+	return out
+}
+
+func (e *encodeWriter) Close() error {
+	if len(e.pending) != 0 {
+		e.w.Write(e.convert(e.pending))
+		e.pending = nil
+	}
+	return nil
+}
+
+// partialUTF8 returns the length of an incomplete UTF-8 sequence ending b.
+func partialUTF8(b []byte) int {
+	for i := 1; i <= 3 && i <= len(b); i++ {
+		c := b[len(b)-i]
+		if c&0xc0 == 0x80 {
+			continue
+		}
+		need := 1
+		switch {
+		case c >= 0xf0:
+			need = 4
+		case c >= 0xe0:
+			need = 3
+		case c >= 0xc0:
+			need = 2
+		}
+		if need > i {
+			return i
+		}
+		return 0
+	}
+	return 0
+}
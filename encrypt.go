@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+)
+
+// A config value may be given encrypted as {"enc":"<base64>"}, made by
+// "wsw -a encrypt", wherever a string goes. Such a value is read as the
+// reference secret://dpapi/<base64>, so it is only decrypted when a process
+// is launched and is saved still encrypted. Likewise {"prompt":true,
+// "name":"<name>"} is read as secret://prompt/<name>, a value entered on
+// install.
+
+// unmarshalConfig decodes a JSON config into conf, turning encrypted and
+// prompted values into references.
+func unmarshalConfig(data []byte, conf *Config) error {
+	if bytes.Contains(data, []byte(`"enc"`)) || bytes.Contains(data, []byte(`"prompt"`)) {
+		d := json.NewDecoder(bytes.NewReader(data))
+		d.UseNumber()
+		var v interface{}
+		if err := d.Decode(&v); err != nil {
+			return err
+		}
+		v, err := configRefs(v)
+		if err != nil {
+			return err
+		}
+		out, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		data = out
+	}
+	return json.Unmarshal(data, conf)
+}
+
+// configRefs replaces the encrypted and prompted values in v.
+func configRefs(v interface{}) (interface{}, error) {
+	var err error
+	switch v := v.(type) {
+	case map[string]interface{}:
+		if enc, ok := v["enc"].(string); ok && len(v) == 1 {
+			return "secret://dpapi/" + enc, nil
+		}
+		if prompt, ok := v["prompt"].(bool); ok && prompt {
+			name, _ := v["name"].(string)
+			if !promptName.MatchString(name) || len(v) != 2 {
+				return nil, fmt.Errorf("A prompted value needs a name of letters, digits, '_', '.' and '-' only, got %v", v["name"])
+			}
+			return "secret://prompt/" + name, nil
+		}
+		for k, e := range v {
+			if v[k], err = configRefs(e); err != nil {
+				return nil, err
+			}
+		}
+	case []interface{}:
+		for i, e := range v {
+			if v[i], err = configRefs(e); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return v, nil
+}
+
+// dpapiSecret decrypts an encrypted config value.
+func dpapiSecret(r *secretResolver, key string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return "", fmt.Errorf("Invalid encrypted value: %v", err)
+	}
+	plain, err := unprotect(data)
+	if err != nil {
+		return "", fmt.Errorf("Failed to decrypt: %v", err)
+	}
+	return string(plain), nil
+}
+
+// encryptValue prints value, or the line read from stdin when it is empty,
+// encrypted for the config: as a value, and as the reference to embed in a
+// string such as an Env entry.
+func encryptValue(value string) error {
+	if value == "" {
+		line, err := readSecret("Value to encrypt: ")
+		if err != nil {
+			return err
+		}
+		value = line
+	}
+	data, err := protect([]byte(value))
+	if err != nil {
+		return fmt.Errorf("Failed to encrypt: %v", err)
+	}
+	enc := base64.StdEncoding.EncodeToString(data)
+	out, err := json.Marshal(map[string]string{"enc": enc})
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(out))
+	fmt.Println("secret://dpapi/" + enc)
+	return nil
+}
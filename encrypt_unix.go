@@ -0,0 +1,16 @@
+//go:build !windows
+
+package main
+
+import "fmt"
+
+// errNoDPAPI is returned for encrypted values, which rely on Windows DPAPI.
+var errNoDPAPI = fmt.Errorf("Encrypted values are only supported on Windows, use another secret provider")
+
+func protect(data []byte) ([]byte, error) {
+	return nil, errNoDPAPI
+}
+
+func unprotect(data []byte) ([]byte, error) {
+	return nil, errNoDPAPI
+}
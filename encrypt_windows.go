@@ -0,0 +1,33 @@
+package main
+
+import (
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// protect encrypts data with DPAPI for the machine, so that any account on
+// it, such as the one the service runs as, can decrypt it, but no other
+// machine.
+func protect(data []byte) ([]byte, error) {
+	return dpapi(data, windows.CryptProtectData)
+}
+
+func unprotect(data []byte) ([]byte, error) {
+	return dpapi(data, func(in *windows.DataBlob, _ *uint16, entropy *windows.DataBlob, reserved uintptr, prompt *windows.CryptProtectPromptStruct, flags uint32, out *windows.DataBlob) error {
+		return windows.CryptUnprotectData(in, nil, entropy, reserved, prompt, flags, out)
+	})
+}
+
+func dpapi(data []byte, f func(*windows.DataBlob, *uint16, *windows.DataBlob, uintptr, *windows.CryptProtectPromptStruct, uint32, *windows.DataBlob) error) ([]byte, error) {
+	in := windows.DataBlob{Size: uint32(len(data))}
+	if len(data) != 0 {
+		in.Data = &data[0]
+	}
+	var out windows.DataBlob
+	if err := f(&in, nil, nil, 0, nil, windows.CRYPTPROTECT_UI_FORBIDDEN|windows.CRYPTPROTECT_LOCAL_MACHINE, &out); err != nil {
+		return nil, err
+	}
+	defer windows.LocalFree(windows.Handle(unsafe.Pointer(out.Data)))
+	return append([]byte{}, unsafe.Slice(out.Data, out.Size)...), nil
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// envKeyEqual tells whether two variable names are the same variable, which
+// ignores case only on Windows.
+func envKeyEqual(a, b string) bool {
+	if envKeysFold {
+		return strings.EqualFold(a, b)
+	}
+	return a == b
+}
+
+// An Env entry is NAME=value to set a variable, NAME+=value or NAME^=value
+// to append or prepend value to the list it holds, separated like PATH, or
+// -NAME to remove it.
+type envEntry struct {
+	name, op, value string
+}
+
+func parseEnvEntry(entry string) (envEntry, bool) {
+	if strings.HasPrefix(entry, "-") && !strings.Contains(entry, "=") {
+		return envEntry{name: entry[1:], op: "-"}, len(entry) > 1
+	}
+	i := strings.Index(entry, "=")
+	if i <= 0 {
+		return envEntry{}, false
+	}
+	e := envEntry{name: entry[:i], op: "=", value: entry[i+1:]}
+	if strings.HasSuffix(e.name, "+") || strings.HasSuffix(e.name, "^") {
+		e.op = e.name[len(e.name)-1:] + "="
+		e.name = e.name[:len(e.name)-1]
+	}
+	return e, e.name != ""
+}
+
+// apply returns the value of the variable after the entry, given its
+// current value, and false if it is removed.
+func (e envEntry) apply(current string) (string, bool) {
+	switch e.op {
+	case "-":
+		return "", false
+	case "+=":
+		return joinList(current, e.value), true
+	case "^=":
+		return joinList(e.value, current), true
+	}
+	if envKeyEqual(e.name, "PATH") {
+		return pathValue(e.value, current), true
+	}
+	return e.value, true
+}
+
+// mergeEnv returns env with entries laid over it in order, see envEntry,
+// each replacing the variable of the same name.
+func mergeEnv(env, entries []string) []string {
+	out := append([]string{}, env...)
+	for _, entry := range entries {
+		e, ok := parseEnvEntry(entry)
+		if !ok {
+			continue
+		}
+		// A variable keeps the case of its name, such as Path on Windows.
+		name, current := e.name, ""
+		kept := out[:0]
+		for _, kv := range out {
+			if n := strings.SplitN(kv, "=", 2)[0]; envKeyEqual(n, e.name) {
+				name, current = n, strings.TrimPrefix(kv, n+"=")
+				continue
+			}
+			kept = append(kept, kv)
+		}
+		out = kept
+		if value, set := e.apply(current); set {
+			out = append(out, name+"="+value)
+		}
+	}
+	return out
+}
+
+// pathValue returns the PATH an Env entry asks for: value with every
+// reference to the current PATH ($PATH or ${PATH}, %PATH% on Windows)
+// replaced by it, so "$PATH:/opt/bin" appends, or value prepended to it when
+// there is no reference. Empty and repeated directories are dropped, so
+// applying an entry twice changes nothing.
+func pathValue(value, current string) string {
+	if pathRef.MatchString(value) {
+		return joinList(pathRef.ReplaceAllLiteralString(value, current))
+	}
+	return joinList(value, current)
+}
+
+// joinList joins lists separated like PATH, dropping empty and repeated
+// entries.
+func joinList(lists ...string) string {
+	var dirs []string
+	for _, dir := range filepath.SplitList(strings.Join(lists, string(os.PathListSeparator))) {
+		seen := dir == ""
+		for _, d := range dirs {
+			seen = seen || envKeyEqual(d, dir)
+		}
+		if !seen {
+			dirs = append(dirs, dir)
+		}
+	}
+	return strings.Join(dirs, string(os.PathListSeparator))
+}
+
+// childEnv returns the environment for the child: base, or only its
+// minimalEnv entries when InheritEnv is false, then the WSW_* context
+// variables, the EnvFiles and Env, each overriding what comes before, and
+// finally PathPrepend and PathAppend.
+func (c *child) childEnv(base []string) []string {
+	entries := append(append(c.contextEnv(), c.fileEnv...), c.Env...)
+	if dirs := c.pathDirs(c.PathPrepend); dirs != "" {
+		entries = append(entries, "PATH^="+dirs)
+	}
+	if dirs := c.pathDirs(c.PathAppend); dirs != "" {
+		entries = append(entries, "PATH+="+dirs)
+	}
+	if c.InheritEnv == nil || *c.InheritEnv {
+		return mergeEnv(base, entries)
+	}
+	env := []string{}
+	for _, kv := range base {
+		name := strings.SplitN(kv, "=", 2)[0]
+		for _, keep := range minimalEnv {
+			if envKeyEqual(name, keep) {
+				env = append(env, kv)
+				break
+			}
+		}
+	}
+	return mergeEnv(env, entries)
+}
+
+// readEnvFiles reads the EnvFiles: NAME=value lines, or the other entries
+// Env takes, with blank lines, # comments and a leading "export " ignored.
+// A value in double quotes may use \n, \" and \\, one in single quotes is
+// taken as it is.
+func (c *child) readEnvFiles() error {
+	c.fileEnv = nil
+	for _, name := range c.EnvFiles {
+		optional := strings.HasPrefix(name, "-")
+		path := c.childPath(strings.TrimPrefix(name, "-"))
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			if optional && os.IsNotExist(err) {
+				continue
+			}
+			return fmt.Errorf("Failed to read EnvFiles: %v", err)
+		}
+		for i, line := range strings.Split(string(data), "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			line = strings.TrimPrefix(line, "export ")
+			e, ok := parseEnvEntry(line)
+			if !ok {
+				return fmt.Errorf("%s:%d: expected NAME=value", path, i+1)
+			}
+			if e.op != "-" {
+				e.name = strings.TrimSpace(e.name)
+				e.value = unquoteEnvValue(strings.TrimSpace(e.value))
+				line = e.name + strings.TrimSuffix(e.op, "=") + "=" + e.value
+			}
+			c.fileEnv = append(c.fileEnv, line)
+		}
+	}
+	return nil
+}
+
+// unquoteEnvValue removes the quotes around an EnvFiles value.
+func unquoteEnvValue(v string) string {
+	if len(v) < 2 {
+		return v
+	}
+	switch {
+	case v[0] == '\'' && v[len(v)-1] == '\'':
+		return v[1 : len(v)-1]
+	case v[0] == '"' && v[len(v)-1] == '"':
+		return strings.NewReplacer(`\n`, "\n", `\"`, `"`, `\\`, `\`).Replace(v[1 : len(v)-1])
+	}
+	return v
+}
+
+// baseEnv is the environment the child builds on: wsw's own, refreshed from
+// the registry with RefreshEnv.
+func (c *child) baseEnv() []string {
+	env := os.Environ()
+	if c.RefreshEnv {
+		fresh, err := refreshEnv(env)
+		if err != nil {
+			logger.Warningf("%s: failed to refresh the environment: %v", c.label(), err)
+		}
+		env = fresh
+	}
+	return env
+}
+
+// pathDirs joins dirs, relative to the child's directory, into a PATH list.
+func (c *child) pathDirs(dirs []string) string {
+	full := make([]string, len(dirs))
+	for i, d := range dirs {
+		full[i] = c.childPath(d)
+	}
+	return joinList(full...)
+}
+
+// envValue returns the value of the named variable in env.
+func envValue(env []string, name string) string {
+	for _, kv := range env {
+		if kv := strings.SplitN(kv, "=", 2); len(kv) == 2 && envKeyEqual(kv[0], name) {
+			return kv[1]
+		}
+	}
+	return ""
+}
+
+// contextEnv tells the child it runs under wsw and as which service.
+func (c *child) contextEnv() []string {
+	logDir := ""
+	if log := c.childPath(c.Stdout); log != "" {
+		logDir = filepath.Dir(log)
+	} else if log := c.childPath(c.Stderr); log != "" {
+		logDir = filepath.Dir(log)
+	}
+	env := []string{
+		"WSW_SERVICE_NAME=" + c.prg.Name,
+		"WSW_PROCESS_NAME=" + c.name,
+		"WSW_VERSION=" + version,
+		"WSW_LOG_DIR=" + logDir,
+		fmt.Sprintf("WSW_RESTART_COUNT=%d", c.restarts),
+		fmt.Sprintf("WSW_PID=%d", os.Getpid()),
+	}
+	if c.instance >= 0 {
+		env = append(env, fmt.Sprintf("WSW_INSTANCE=%d", c.instance))
+		if c.Port != 0 {
+			env = append(env, fmt.Sprintf("WSW_PORT=%d", c.Port+c.instance))
+		}
+	} else if c.Port != 0 {
+		env = append(env, fmt.Sprintf("WSW_PORT=%d", c.Port))
+	}
+	return env
+}
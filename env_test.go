@@ -0,0 +1,60 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseEnvEntry(t *testing.T) {
+	for _, tt := range []struct {
+		entry string
+		want  envEntry
+		ok    bool
+	}{
+		{"FOO=bar", envEntry{"FOO", "=", "bar"}, true},
+		{"FOO=", envEntry{"FOO", "=", ""}, true},
+		{"FOO=a=b", envEntry{"FOO", "=", "a=b"}, true},
+		{"FOO+=bar", envEntry{"FOO", "+=", "bar"}, true},
+		{"FOO^=bar", envEntry{"FOO", "^=", "bar"}, true},
+		{"-FOO", envEntry{"FOO", "-", ""}, true},
+		{"-FOO=bar", envEntry{"-FOO", "=", "bar"}, true},
+		{"-", envEntry{"", "-", ""}, false},
+		{"FOO", envEntry{}, false},
+		{"=bar", envEntry{}, false},
+		{"+=bar", envEntry{"", "+=", "bar"}, false},
+	} {
+		got, ok := parseEnvEntry(tt.entry)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("parseEnvEntry(%q) = %+v, %v, want %+v, %v", tt.entry, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestMergeEnv(t *testing.T) {
+	list := func(dirs ...string) string {
+		return strings.Join(dirs, string(os.PathListSeparator))
+	}
+	for _, tt := range []struct {
+		env, entries, want []string
+	}{
+		{[]string{"A=1", "B=2"}, nil, []string{"A=1", "B=2"}},
+		{[]string{"A=1", "B=2"}, []string{"A=3"}, []string{"B=2", "A=3"}},
+		{[]string{"A=1", "B=2"}, []string{"-A"}, []string{"B=2"}},
+		{[]string{"A=1"}, []string{"-C"}, []string{"A=1"}},
+		{[]string{"A=1"}, []string{"bogus", "=x"}, []string{"A=1"}},
+		{[]string{"L=" + list("x", "y")}, []string{"L+=z"}, []string{"L=" + list("x", "y", "z")}},
+		{[]string{"L=" + list("x", "y")}, []string{"L^=z"}, []string{"L=" + list("z", "x", "y")}},
+		{[]string{"L=" + list("x", "y")}, []string{"L+=" + list("y", "z"), "L+=z"}, []string{"L=" + list("x", "y", "z")}},
+		{nil, []string{"L+=x"}, []string{"L=x"}},
+		{nil, []string{"A=1", "A+=2", "-A", "A=3"}, []string{"A=3"}},
+		// PATH without a reference to itself is prepended, once.
+		{[]string{"PATH=" + list("x", "y")}, []string{"PATH=z", "PATH=z"}, []string{"PATH=" + list("z", "x", "y")}},
+	} {
+		got := mergeEnv(tt.env, tt.entries)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("mergeEnv(%q, %q) = %q, want %q", tt.env, tt.entries, got, tt.want)
+		}
+	}
+}
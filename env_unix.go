@@ -0,0 +1,23 @@
+//go:build !windows
+
+package main
+
+import "regexp"
+
+// minimalEnv lists the variables a child still gets from the wrapper when
+// InheritEnv is false: what programs expect a login to have set.
+var minimalEnv = []string{
+	"PATH", "HOME", "USER", "LOGNAME", "SHELL", "LANG", "LC_ALL", "TZ", "TMPDIR",
+}
+
+// envKeysFold is unset: Path and PATH are different variables.
+const envKeysFold = false
+
+// pathRef matches a reference to the current PATH in an Env entry.
+var pathRef = regexp.MustCompile(`\$(PATH\b|\{PATH\})`)
+
+// refreshEnv returns env: Unix services get their environment from the
+// service manager on every start.
+func refreshEnv(env []string) ([]string, error) {
+	return env, nil
+}
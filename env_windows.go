@@ -0,0 +1,46 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+
+	"golang.org/x/sys/windows"
+)
+
+// minimalEnv lists the variables a child still gets from the wrapper when
+// InheritEnv is false: what Windows programs need to start at all.
+var minimalEnv = []string{
+	"SystemRoot", "SystemDrive", "windir", "ComSpec", "PATHEXT", "PATH",
+	"TEMP", "TMP", "OS", "NUMBER_OF_PROCESSORS", "PROCESSOR_ARCHITECTURE",
+	"ProgramData", "ProgramFiles", "ProgramFiles(x86)", "ProgramW6432",
+	"CommonProgramFiles", "CommonProgramFiles(x86)", "CommonProgramW6432",
+}
+
+// envKeysFold is set: Windows variable names ignore case.
+const envKeysFold = true
+
+// pathRef matches a reference to the current PATH in an Env entry.
+var pathRef = regexp.MustCompile(`(?i)%PATH%`)
+
+// refreshEnv lays the machine and user variables as the registry has them
+// now over env, which the SCM captured when it started, keeping the
+// variables that do not come from the registry.
+func refreshEnv(env []string) ([]string, error) {
+	var token windows.Token
+	if err := windows.OpenProcessToken(windows.CurrentProcess(), windows.TOKEN_QUERY|windows.TOKEN_DUPLICATE, &token); err != nil {
+		return env, err
+	}
+	defer token.Close()
+	fresh, err := token.Environ(false)
+	if err != nil {
+		return env, err
+	}
+	out := fresh
+	for _, kv := range env {
+		name := strings.SplitN(kv, "=", 2)[0]
+		if envValue(fresh, name) == "" {
+			out = append(out, kv)
+		}
+	}
+	return out, nil
+}
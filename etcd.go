@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Etcd publishes the status of the service into etcd while it runs, under a
+// lease wsw keeps alive, so the key goes away when wsw does.
+type Etcd struct {
+	// Endpoints are the URLs of the cluster, tried in turn, by default
+	// ETCDCTL_ENDPOINTS or http://127.0.0.1:2379.
+	Endpoints []string
+	// Username and Password log in when etcd has authentication on.
+	// Password may be a secret:// reference.
+	Username, Password string
+	// The status is kept at <Prefix><Name>/<Host>, Prefix defaulting to
+	// /wsw/ and Host, also the host of the published endpoints, to the
+	// machine's host name. TTL is the lifetime of the lease in seconds
+	// (default 30); the status is refreshed every third of it.
+	Prefix string
+	Host   string
+	TTL    int
+}
+
+// etcdStatus is the value published for the service.
+type etcdStatus struct {
+	Name        string
+	DisplayName string
+	Host        string
+	Version     string
+	WrapperPID  int
+	Updated     time.Time
+	Processes   []etcdProcess
+}
+
+type etcdProcess struct {
+	Name string
+	// State is "starting", "ready" or "exited".
+	State    string
+	PID      int       `json:",omitempty"`
+	Started  time.Time `json:",omitempty"`
+	Endpoint string    `json:",omitempty"`
+}
+
+// etcdPublisher keeps the status of a program in etcd.
+type etcdPublisher struct {
+	mu    sync.Mutex
+	p     *program
+	conf  *Etcd
+	host  string
+	lease string
+	token string
+}
+
+func newEtcdPublisher(p *program) *etcdPublisher {
+	e := &etcdPublisher{p: p, conf: p.Etcd, host: p.Etcd.Host}
+	if e.host == "" {
+		e.host, _ = os.Hostname()
+	}
+	return e
+}
+
+func (e *etcdPublisher) ttl() time.Duration {
+	if e.conf.TTL > 0 {
+		return time.Duration(e.conf.TTL) * time.Second
+	}
+	return 30 * time.Second
+}
+
+func (e *etcdPublisher) key() string {
+	prefix := e.conf.Prefix
+	if prefix == "" {
+		prefix = "/wsw/"
+	}
+	return path.Join(prefix, e.p.Name, e.host)
+}
+
+// run publishes the status until the program stops.
+func (e *etcdPublisher) run() {
+	failing := false
+	for {
+		if err := e.publish(); err != nil {
+			if !failing {
+				logger.Warningf("Failed to publish the status to etcd: %v", err)
+			}
+			failing = true
+		} else if failing {
+			logger.Info("Publishing the status to etcd again")
+			failing = false
+		}
+		select {
+		case <-e.p.exit:
+			return
+		case <-time.After(e.ttl() / 3):
+		}
+	}
+}
+
+// publish puts the current status, keeping its lease alive or, once the
+// lease is gone, under a new one.
+func (e *etcdPublisher) publish() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	select {
+	case <-e.p.exit:
+		// Stopping, the status is being revoked.
+		return nil
+	default:
+	}
+	if e.lease != "" {
+		var alive struct {
+			Result struct{ TTL string }
+		}
+		if err := e.do("lease/keepalive", map[string]string{"ID": e.lease}, &alive); err != nil || alive.Result.TTL == "" || alive.Result.TTL == "0" {
+			e.lease = ""
+		}
+	}
+	if e.lease == "" {
+		var grant struct{ ID string }
+		ttl := strconv.Itoa(int(e.ttl() / time.Second))
+		if err := e.do("lease/grant", map[string]string{"TTL": ttl}, &grant); err != nil {
+			return err
+		}
+		e.lease = grant.ID
+	}
+	value, err := json.Marshal(e.status())
+	if err != nil {
+		return err
+	}
+	return e.do("kv/put", map[string]string{
+		"key":   base64.StdEncoding.EncodeToString([]byte(e.key())),
+		"value": base64.StdEncoding.EncodeToString(value),
+		"lease": e.lease,
+	}, nil)
+}
+
+// revoke ends the lease, removing the status.
+func (e *etcdPublisher) revoke() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.lease == "" {
+		return
+	}
+	if err := e.do("lease/revoke", map[string]string{"ID": e.lease}, nil); err != nil {
+		logger.Warningf("Failed to remove the status from etcd: %v", err)
+	}
+	e.lease = ""
+}
+
+func (e *etcdPublisher) status() *etcdStatus {
+	p := e.p
+	st := &etcdStatus{Name: p.Name, DisplayName: p.DisplayName, Host: e.host, Version: version, WrapperPID: os.Getpid(), Updated: time.Now().UTC()}
+	run, _ := readRunState(p.Config)
+	for _, c := range p.children {
+		ps := etcdProcess{Name: c.name, State: c.state()}
+		if run != nil && ps.State != "exited" {
+			for _, cs := range run.Children {
+				if cs.Name == c.name {
+					ps.PID, ps.Started = cs.PID, cs.Started
+				}
+			}
+		}
+		if c.Port != 0 {
+			port := c.Port
+			if c.instance > 0 {
+				port += c.instance
+			}
+			ps.Endpoint = e.host + ":" + strconv.Itoa(port)
+		}
+		st.Processes = append(st.Processes, ps)
+	}
+	return st
+}
+
+func (e *etcdPublisher) endpoints() []string {
+	endpoints := e.conf.Endpoints
+	if len(endpoints) == 0 {
+		if env := os.Getenv("ETCDCTL_ENDPOINTS"); env != "" {
+			endpoints = strings.Split(env, ",")
+		} else {
+			endpoints = []string{"http://127.0.0.1:2379"}
+		}
+	}
+	return endpoints
+}
+
+// do calls the v3 JSON API on the first endpoint that answers, logging in
+// first when a Username is set.
+func (e *etcdPublisher) do(method string, body, out interface{}) error {
+	var err error
+	for _, endpoint := range e.endpoints() {
+		endpoint = strings.TrimSuffix(strings.TrimSpace(endpoint), "/")
+		if !strings.Contains(endpoint, "://") {
+			endpoint = "http://" + endpoint
+		}
+		if e.conf.Username != "" && e.token == "" {
+			if err = e.authenticate(endpoint); err != nil {
+				continue
+			}
+		}
+		if err = e.send(endpoint, method, e.token, body, out); err == nil {
+			return nil
+		}
+		if strings.Contains(err.Error(), "invalid auth token") {
+			e.token = ""
+		}
+	}
+	return err
+}
+
+func (e *etcdPublisher) authenticate(endpoint string) error {
+	password, err := newSecretResolver(e.p.Config).resolve(e.conf.Password)
+	if err != nil {
+		return err
+	}
+	var auth struct{ Token string }
+	body := map[string]string{"name": e.conf.Username, "password": password}
+	if err := e.send(endpoint, "auth/authenticate", "", body, &auth); err != nil {
+		return fmt.Errorf("Failed to log in: %v", err)
+	}
+	e.token = auth.Token
+	return nil
+}
+
+func (e *etcdPublisher) send(endpoint, method, token string, body, out interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", endpoint+"/v3/"+method, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", token)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err = ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var e struct{ Message, Error string }
+		if json.Unmarshal(data, &e) == nil && (e.Message != "" || e.Error != "") {
+			if e.Message == "" {
+				e.Message = e.Error
+			}
+			return fmt.Errorf("%s: %s", resp.Status, e.Message)
+		}
+		return fmt.Errorf("Unexpected status %s", resp.Status)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(data, out)
+}
@@ -0,0 +1,17 @@
+package main
+
+// Keywords of the wrapper's events, for filtering them in a trace session.
+const (
+	etwStart   = 0x1
+	etwStop    = 0x2
+	etwRestart = 0x4
+	etwProbe   = 0x8
+	etwCrash   = 0x10
+)
+
+// Levels of the events.
+const (
+	etwError   = 2
+	etwWarning = 3
+	etwInfo    = 4
+)
@@ -0,0 +1,6 @@
+//go:build !windows
+
+package main
+
+// traceEvent does nothing: ETW is a Windows facility.
+func traceEvent(level uint8, keyword uint64, format string, a ...interface{}) {}
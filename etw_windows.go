@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var (
+	procEventRegister    = advapi32.NewProc("EventRegister")
+	procEventWriteString = advapi32.NewProc("EventWriteString")
+)
+
+// etwProviderID is the ETW provider the wrapper writes its lifecycle events
+// to. It is derived from the name "wsw" the way EventSource and TraceLogging
+// providers are, so tools taking "*wsw" find it as well.
+var etwProviderID = windows.GUID{Data1: 0x42ffed08, Data2: 0xbfdb, Data3: 0x5dff, Data4: [8]byte{0x83, 0x11, 0xa9, 0x3f, 0xc1, 0x0c, 0x72, 0xef}}
+
+var (
+	etwOnce   sync.Once
+	etwHandle uint64
+)
+
+// traceEvent writes a string event to the wrapper's ETW provider. Without a
+// trace session enabling the provider it costs next to nothing.
+func traceEvent(level uint8, keyword uint64, format string, a ...interface{}) {
+	etwOnce.Do(func() {
+		r, _, _ := procEventRegister.Call(uintptr(unsafe.Pointer(&etwProviderID)), 0, 0, uintptr(unsafe.Pointer(&etwHandle)))
+		if r != 0 {
+			logger.Warningf("Failed to register the ETW provider: %v", windows.Errno(r))
+			etwHandle = 0
+		}
+	})
+	if etwHandle == 0 {
+		return
+	}
+	msg, err := windows.UTF16PtrFromString(fmt.Sprintf(format, a...))
+	if err != nil {
+		return
+	}
+	// REGHANDLE and ULONGLONG take two arguments each on 32-bit Windows.
+	if unsafe.Sizeof(uintptr(0)) == 4 {
+		procEventWriteString.Call(uintptr(etwHandle), uintptr(etwHandle>>32), uintptr(level), uintptr(keyword), uintptr(keyword>>32), uintptr(unsafe.Pointer(msg)))
+		return
+	}
+	procEventWriteString.Call(uintptr(etwHandle), uintptr(level), uintptr(keyword), uintptr(unsafe.Pointer(msg)))
+}
@@ -0,0 +1,10 @@
+//go:build !windows
+
+package main
+
+import "errors"
+
+// signalEvent fails: named events are a Windows feature.
+func signalEvent(name string) error {
+	return errors.New("Events are only supported on Windows")
+}
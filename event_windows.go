@@ -0,0 +1,19 @@
+package main
+
+import (
+	"golang.org/x/sys/windows"
+)
+
+// signalEvent sets the named Windows event, which the child created.
+func signalEvent(name string) error {
+	p, err := windows.UTF16PtrFromString(name)
+	if err != nil {
+		return err
+	}
+	h, err := windows.OpenEvent(windows.EVENT_MODIFY_STATE, false, p)
+	if err != nil {
+		return err
+	}
+	defer windows.CloseHandle(h)
+	return windows.SetEvent(h)
+}
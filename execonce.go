@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+)
+
+// execOnce runs a process of the config once in the console, with the
+// directory, environment and arguments wsw builds for it but none of the
+// service around it: no restarts, log files or probes. name picks the
+// process when there are several. It returns the exit code.
+func execOnce(conf *Config, name string) (int, error) {
+	logger = textLogger{l: log.New(os.Stderr, "", log.LstdFlags)}
+	p := &program{Config: conf, exit: make(chan struct{})}
+	children, err := p.newChildren()
+	if err != nil {
+		return 0, err
+	}
+	c, err := pickChild(children, name)
+	if err != nil {
+		return 0, err
+	}
+	if err := c.prepare(); err != nil {
+		return 0, err
+	}
+	cmd := c.cmd
+	inForeground(cmd)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	infof("Running %s in %s", commandLine(cmd), cmd.Dir)
+	if _, err := resolveCmdSecrets(newSecretResolver(conf), cmd); err != nil {
+		return 0, err
+	}
+	// Ctrl+C reaches the process, which decides when to exit.
+	signal.Notify(make(chan os.Signal, 1), os.Interrupt)
+	if err := c.withErrorMode(cmd.Start); err != nil {
+		return 0, err
+	}
+	err = cmd.Wait()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return exitErr.ExitCode(), nil
+	}
+	return 0, err
+}
+
+// pickChild returns the child named name, or the only one when name is
+// empty.
+func pickChild(children []*child, name string) (*child, error) {
+	var names []string
+	for _, c := range children {
+		if c.name == name || name == "" && len(children) == 1 {
+			return c, nil
+		}
+		names = append(names, c.name)
+	}
+	if name == "" {
+		return nil, fmt.Errorf("Several processes, name one of %s", strings.Join(names, ", "))
+	}
+	return nil, fmt.Errorf("No process %q, expected %s", name, strings.Join(names, ", "))
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// exitReport describes how a run of the child ended. It is logged and
+// recorded in the history after every exit.
+type exitReport struct {
+	Process  string
+	PID      int
+	ExitCode string
+	// Status names the NTSTATUS of a crash, such as STATUS_ACCESS_VIOLATION.
+	Status   string `json:",omitempty"`
+	Duration string
+	// PeakMemory is the most memory committed by the child and its
+	// processes at once, in bytes.
+	PeakMemory uint64 `json:",omitempty"`
+	// BytesLogged is what the child wrote to Stdout and Stderr during the
+	// run; -1 when unknown, for a child adopted from a previous wrapper.
+	BytesLogged int64
+}
+
+func (r *exitReport) String() string {
+	s := fmt.Sprintf("%s exited with code %s", r.Process, r.ExitCode)
+	if r.Status != "" {
+		s += " (" + r.Status + ")"
+	}
+	s += " after " + r.Duration
+	if r.PeakMemory != 0 {
+		s += ", peak memory " + formatBytes(r.PeakMemory)
+	}
+	if r.BytesLogged >= 0 {
+		s += ", logged " + formatBytes(uint64(r.BytesLogged))
+	}
+	return s
+}
+
+// reportExit logs the exit report of the run that started at started in
+// group, if any, and records it in the history.
+func (c *child) reportExit(started time.Time, group *processGroup) {
+	r := &exitReport{
+		Process:     c.label(),
+		PID:         c.proc.Pid,
+		ExitCode:    exitCodeString(c.exitCode),
+		Status:      decodeExitCode(c.exitCode),
+		Duration:    time.Since(started).Round(time.Second).String(),
+		BytesLogged: -1,
+	}
+	if group != nil {
+		r.PeakMemory = group.peakMemory()
+	}
+	if c.output != nil {
+		r.BytesLogged = c.output.count()
+	}
+	switch {
+	case c.exitCode == 0 || c.prg.Mode == "oneshot":
+		logger.Info(r)
+	default:
+		logger.Warning(r)
+	}
+	event := "exit"
+	if isCrash(c.exitCode) {
+		event = "crash"
+	}
+	if err := appendHistory(c.prg.Config, historyEntry{Time: time.Now(), Event: event, Detail: r.String(), Exit: r}); err != nil {
+		logger.Warningf("Failed to record exit of %s: %v", c.label(), err)
+	}
+}
+
+// outputCounter measures what the child writes: writers as they pass data
+// on, files the child writes to directly by how much they grew.
+type outputCounter struct {
+	n  int64
+	mu sync.Mutex
+	// files holds the path and starting size of each file, by fileKey.
+	files map[string]countedFile
+}
+
+type countedFile struct {
+	path  string
+	start int64
+}
+
+// wrap returns w counting what goes through it, or w itself when it is a
+// file, whose size is taken now.
+func (o *outputCounter) wrap(w io.Writer) io.Writer {
+	f, ok := w.(*os.File)
+	if !ok {
+		return &countWriter{w: w, n: &o.n}
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.files == nil {
+		o.files = map[string]countedFile{}
+	}
+	if key := fileKey(f.Name()); o.files[key].path == "" {
+		var size int64
+		if fi, err := f.Stat(); err == nil {
+			size = fi.Size()
+		}
+		o.files[key] = countedFile{path: f.Name(), start: size}
+	}
+	return f
+}
+
+// count returns the bytes written so far.
+func (o *outputCounter) count() int64 {
+	n := atomic.LoadInt64(&o.n)
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for _, file := range o.files {
+		fi, err := os.Stat(file.path)
+		if err != nil {
+			continue
+		}
+		start := file.start
+		// A file rotated or removed meanwhile counts from empty.
+		if fi.Size() < start {
+			start = 0
+		}
+		n += fi.Size() - start
+	}
+	return n
+}
+
+type countWriter struct {
+	w io.Writer
+	n *int64
+}
+
+func (w *countWriter) Write(b []byte) (int, error) {
+	n, err := w.w.Write(b)
+	atomic.AddInt64(w.n, int64(n))
+	return n, err
+}
@@ -0,0 +1,37 @@
+//go:build !windows
+
+package main
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// crashSignals are the signals a process gets for faults of its own.
+var crashSignals = map[syscall.Signal]bool{
+	syscall.SIGSEGV: true,
+	syscall.SIGBUS:  true,
+	syscall.SIGILL:  true,
+	syscall.SIGFPE:  true,
+	syscall.SIGABRT: true,
+}
+
+// isCrash tells whether an exit code, 128 plus the signal for a process
+// killed by one, stands for a fault such as a segmentation violation.
+func isCrash(code int) bool {
+	return code > 128 && crashSignals[syscall.Signal(code-128)]
+}
+
+// decodeExitCode names the signal that killed a process.
+func decodeExitCode(code int) string {
+	if code <= 128 || code >= 128+65 {
+		return ""
+	}
+	sig := syscall.Signal(code - 128)
+	return fmt.Sprintf("signal %d: %v", int(sig), sig)
+}
+
+// fileKey tells output files apart; paths are case sensitive.
+func fileKey(path string) string {
+	return path
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"strings"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// isCrash tells whether an exit code is an NTSTATUS error such as
+// 0xC0000005 (access violation) rather than a code the program chose.
+func isCrash(code int) bool {
+	return uint32(code)&0xf0000000 == 0xc0000000
+}
+
+// ntStatusNames are the NTSTATUS codes applications most commonly die of.
+var ntStatusNames = map[uint32]string{
+	0xC0000005: "STATUS_ACCESS_VIOLATION",
+	0xC0000006: "STATUS_IN_PAGE_ERROR",
+	0xC0000017: "STATUS_NO_MEMORY",
+	0xC000001D: "STATUS_ILLEGAL_INSTRUCTION",
+	0xC0000094: "STATUS_INTEGER_DIVIDE_BY_ZERO",
+	0xC0000096: "STATUS_PRIVILEGED_INSTRUCTION",
+	0xC00000FD: "STATUS_STACK_OVERFLOW",
+	0xC0000135: "STATUS_DLL_NOT_FOUND",
+	0xC0000139: "STATUS_ENTRYPOINT_NOT_FOUND",
+	0xC000013A: "STATUS_CONTROL_C_EXIT",
+	0xC0000142: "STATUS_DLL_INIT_FAILED",
+	0xC0000374: "STATUS_HEAP_CORRUPTION",
+	0xC0000409: "STATUS_STACK_BUFFER_OVERRUN",
+	0xC0000602: "STATUS_FAIL_FAST_EXCEPTION",
+	0xE0434352: "unhandled .NET exception",
+	0xE06D7363: "unhandled C++ exception",
+}
+
+// decodeExitCode names the NTSTATUS a crashed process exited with, along
+// with what Windows says about it.
+func decodeExitCode(code int) string {
+	if !isCrash(code) {
+		return ""
+	}
+	name := ntStatusNames[uint32(code)]
+	msg := strings.TrimSpace(windows.NTStatus(uint32(code)).Error())
+	// Messages with inserts, such as the faulting address, print them as
+	// placeholders.
+	if strings.Contains(msg, "%") || strings.HasPrefix(msg, "NTSTATUS ") {
+		msg = ""
+	}
+	switch {
+	case name == "":
+		return msg
+	case msg == "":
+		return name
+	}
+	return name + ": " + msg
+}
+
+// peakMemory returns the most memory the processes in the group committed
+// at once.
+func (g *processGroup) peakMemory() uint64 {
+	var info windows.JOBOBJECT_EXTENDED_LIMIT_INFORMATION
+	err := windows.QueryInformationJobObject(g.job, windows.JobObjectExtendedLimitInformation, uintptr(unsafe.Pointer(&info)), uint32(unsafe.Sizeof(info)), nil)
+	if err != nil {
+		return 0
+	}
+	return uint64(info.PeakJobMemoryUsed)
+}
+
+// fileKey tells output files apart; paths are case insensitive.
+func fileKey(path string) string {
+	return strings.ToLower(path)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+)
+
+// LogFilter drops or reroutes output lines matching a regular expression.
+// Filters are checked in order and the first match decides.
+type LogFilter struct {
+	// Match is the regular expression, e.g. "GET /health".
+	Match string
+	// Action is "drop", "route" to write the line to the file To instead
+	// (relative to Dir), or "keep" to keep it as is.
+	Action string
+	To     string
+	// Stream limits the filter to "stdout" or "stderr".
+	Stream string
+}
+
+// lineFilter is a LogFilter ready for one stream.
+type lineFilter struct {
+	re     *regexp.Regexp
+	action string
+	w      io.Writer
+}
+
+func checkLogFilters(proc *Process) error {
+	for _, f := range proc.LogFilters {
+		if _, err := regexp.Compile(f.Match); err != nil {
+			return fmt.Errorf("Invalid LogFilters Match %q: %v", f.Match, err)
+		}
+		switch f.Action {
+		case "drop", "keep":
+		case "route":
+			if f.To == "" {
+				return fmt.Errorf("LogFilters route of %q needs To", f.Match)
+			}
+		default:
+			return fmt.Errorf("Unknown LogFilters Action %q, expected drop, route or keep", f.Action)
+		}
+		if f.Stream != "" && f.Stream != "stdout" && f.Stream != "stderr" {
+			return fmt.Errorf("Unknown LogFilters Stream %q, expected stdout or stderr", f.Stream)
+		}
+	}
+	return nil
+}
+
+// filterLine returns where a line goes: the writer and whether it is still
+// forwarded. Dropped lines get a nil writer and no forwarding.
+func filterLine(filters []lineFilter, text string, w io.Writer) (io.Writer, bool) {
+	for _, f := range filters {
+		if !f.re.MatchString(text) {
+			continue
+		}
+		switch f.action {
+		case "drop":
+			return nil, false
+		case "route":
+			return f.w, false
+		}
+		// keep
+		break
+	}
+	return w, true
+}
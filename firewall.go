@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// FirewallRule is a Windows Firewall rule that install creates and
+// uninstall removes.
+type FirewallRule struct {
+	// Name defaults to "<Name> <Protocol> <Ports>", or "<Name> program".
+	Name string
+	// Ports, such as "8080" or "8000-8010,9443", are opened for Protocol,
+	// "tcp" (default) or "udp". Program, relative to wsw's directory,
+	// limits the rule to an executable, or is what the rule allows when no
+	// Ports are given.
+	Ports    string
+	Protocol string
+	Program  string
+	// Direction is "in" (default) or "out", Profile "any" (default),
+	// "domain", "private" or "public", and RemoteIP limits who may
+	// connect, e.g. "LocalSubnet" or "10.0.0.0/8".
+	Direction, Profile, RemoteIP string
+}
+
+// ruleName returns the name the rule is created under.
+func (r *FirewallRule) ruleName(conf *Config) string {
+	if r.Name != "" {
+		return r.Name
+	}
+	if r.Ports == "" {
+		return conf.Name + " program"
+	}
+	protocol := r.Protocol
+	if protocol == "" {
+		protocol = "tcp"
+	}
+	return conf.Name + " " + protocol + " " + r.Ports
+}
+
+// firewallSpec is a FirewallRule checked and resolved against the config,
+// ready to be handed to the firewall.
+type firewallSpec struct {
+	name, description string
+	out               bool
+	// protocol is "tcp" or "udp" when ports are given, else "" for any.
+	protocol, ports string
+	program         string
+	// profile is "any", "domain", "private" or "public".
+	profile  string
+	remoteIP string
+}
+
+// spec checks the rule and resolves its defaults and Program.
+func (r *FirewallRule) spec(conf *Config) (*firewallSpec, error) {
+	name := r.ruleName(conf)
+	if r.Ports == "" && r.Program == "" {
+		return nil, fmt.Errorf("Firewall rule %q needs Ports or Program", name)
+	}
+	s := &firewallSpec{name: name, description: "Created by wsw for " + conf.Name, ports: r.Ports, remoteIP: r.RemoteIP}
+	switch strings.ToLower(r.Direction) {
+	case "", "in":
+	case "out":
+		s.out = true
+	default:
+		return nil, fmt.Errorf("Firewall rule %q: Unknown Direction %q, expected in or out", name, r.Direction)
+	}
+	if r.Ports != "" {
+		s.protocol = strings.ToLower(r.Protocol)
+		switch s.protocol {
+		case "":
+			s.protocol = "tcp"
+		case "tcp", "udp":
+		default:
+			return nil, fmt.Errorf("Firewall rule %q: Unknown Protocol %q, expected tcp or udp", name, r.Protocol)
+		}
+	}
+	if r.Program != "" {
+		s.program = r.Program
+		if !filepath.IsAbs(s.program) {
+			dir, _, err := getExecPath()
+			if err != nil {
+				return nil, err
+			}
+			s.program = filepath.Join(dir, s.program)
+		}
+	}
+	s.profile = strings.ToLower(r.Profile)
+	switch s.profile {
+	case "":
+		s.profile = "any"
+	case "any", "domain", "private", "public":
+	default:
+		return nil, fmt.Errorf("Firewall rule %q: Unknown Profile %q, expected any, domain, private or public", name, r.Profile)
+	}
+	return s, nil
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var (
+	ole32                = windows.NewLazySystemDLL("ole32.dll")
+	procCoCreateInstance = ole32.NewProc("CoCreateInstance")
+	oleaut32             = windows.NewLazySystemDLL("oleaut32.dll")
+	procSysAllocString   = oleaut32.NewProc("SysAllocString")
+	procSysFreeString    = oleaut32.NewProc("SysFreeString")
+)
+
+// The HNetCfg.FwPolicy2 and HNetCfg.FWRule classes and the INetFwPolicy2 and
+// INetFwRule interfaces they are used through.
+var (
+	clsidNetFwPolicy2 = windows.GUID{Data1: 0xE2B3C97F, Data2: 0x6AE1, Data3: 0x41AC, Data4: [8]byte{0x81, 0x7A, 0xF6, 0xF9, 0x21, 0x66, 0xD7, 0xDD}}
+	iidINetFwPolicy2  = windows.GUID{Data1: 0x98325047, Data2: 0xC671, Data3: 0x4174, Data4: [8]byte{0x8D, 0x81, 0xDE, 0xFC, 0xD3, 0xF0, 0x31, 0x86}}
+	clsidNetFwRule    = windows.GUID{Data1: 0x2C5BC43E, Data2: 0x3369, Data3: 0x4C33, Data4: [8]byte{0xAB, 0x0C, 0xBE, 0x94, 0x69, 0x67, 0x7A, 0xF4}}
+	iidINetFwRule     = windows.GUID{Data1: 0xAF230D27, Data2: 0xBABA, Data3: 0x4E42, Data4: [8]byte{0xAC, 0xED, 0xF5, 0x24, 0xF2, 0x2C, 0xFC, 0xE2}}
+)
+
+// Method table slots, counting the 3 of IUnknown and 4 of IDispatch.
+const (
+	comRelease = 2
+
+	policyGetRules = 18
+
+	rulesAdd    = 8
+	rulesRemove = 9
+	rulesItem   = 10
+
+	rulePutName            = 8
+	rulePutDescription     = 10
+	rulePutApplicationName = 12
+	rulePutProtocol        = 16
+	rulePutLocalPorts      = 18
+	rulePutRemotePorts     = 20
+	rulePutRemoteAddresses = 24
+	rulePutDirection       = 28
+	rulePutEnabled         = 34
+	rulePutGrouping        = 36
+	rulePutProfiles        = 38
+	rulePutAction          = 42
+)
+
+const (
+	clsctxInprocServer      = 1
+	coinitApartmentThreaded = 2
+	rpcEChangedMode         = 0x80010106
+	variantTrue             = 0xFFFF
+
+	fwIPProtocolTCP = 6
+	fwIPProtocolUDP = 17
+	fwRuleDirIn     = 1
+	fwRuleDirOut    = 2
+	fwActionAllow   = 1
+)
+
+var fwProfiles = map[string]uintptr{
+	"domain":  1,
+	"private": 2,
+	"public":  4,
+	"any":     0x7FFFFFFF,
+}
+
+// comObject is what a COM interface pointer points to, its method table.
+type comObject struct {
+	vtbl *[64]uintptr
+}
+
+// call calls method with up to 8 arguments after the object itself.
+func (o *comObject) call(method int, args ...uintptr) error {
+	a := make([]uintptr, 8)
+	copy(a, args)
+	hr, _, _ := syscall.Syscall9(o.vtbl[method], uintptr(len(args)+1), uintptr(unsafe.Pointer(o)),
+		a[0], a[1], a[2], a[3], a[4], a[5], a[6], a[7])
+	return hresultError(hr)
+}
+
+func (o *comObject) release() {
+	o.call(comRelease)
+}
+
+// putString sets a string property, passed as a BSTR.
+func (o *comObject) putString(method int, s string) error {
+	b, err := sysAllocString(s)
+	if err != nil {
+		return err
+	}
+	defer procSysFreeString.Call(b)
+	return o.call(method, b)
+}
+
+// hresultError returns the error of a failed HRESULT, as a Win32 error when it
+// wraps one.
+func hresultError(hr uintptr) error {
+	if int32(hr) >= 0 {
+		return nil
+	}
+	if hr&0xFFFF0000 == 0x80070000 {
+		return windows.Errno(hr & 0xFFFF)
+	}
+	return fmt.Errorf("HRESULT 0x%08X", uint32(hr))
+}
+
+func sysAllocString(s string) (uintptr, error) {
+	p, err := windows.UTF16PtrFromString(s)
+	if err != nil {
+		return 0, err
+	}
+	b, _, _ := procSysAllocString.Call(uintptr(unsafe.Pointer(p)))
+	if b == 0 {
+		return 0, windows.ERROR_OUTOFMEMORY
+	}
+	return b, nil
+}
+
+func coCreateInstance(clsid, iid *windows.GUID) (*comObject, error) {
+	var o *comObject
+	hr, _, _ := procCoCreateInstance.Call(uintptr(unsafe.Pointer(clsid)), 0, clsctxInprocServer,
+		uintptr(unsafe.Pointer(iid)), uintptr(unsafe.Pointer(&o)))
+	if err := hresultError(hr); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// withFirewallRules calls f with the rule collection of the Windows Firewall
+// policy, on a thread with COM initialized.
+func withFirewallRules(f func(rules *comObject) error) error {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	// S_FALSE means COM was already initialized on this thread, and still
+	// needs uninitializing; RPC_E_CHANGED_MODE that it was in another mode,
+	// which serves as well.
+	switch err := windows.CoInitializeEx(0, coinitApartmentThreaded); err {
+	case nil, syscall.Errno(1):
+		defer windows.CoUninitialize()
+	case syscall.Errno(rpcEChangedMode):
+	default:
+		return fmt.Errorf("Failed to initialize COM: %v", err)
+	}
+	policy, err := coCreateInstance(&clsidNetFwPolicy2, &iidINetFwPolicy2)
+	if err != nil {
+		return fmt.Errorf("Failed to open the firewall policy: %v", err)
+	}
+	defer policy.release()
+	var rules *comObject
+	if err := policy.call(policyGetRules, uintptr(unsafe.Pointer(&rules))); err != nil {
+		return fmt.Errorf("Failed to get the firewall rules: %v", err)
+	}
+	defer rules.release()
+	return f(rules)
+}
+
+// removeRule deletes the rules named name, which need not exist.
+func removeRule(rules *comObject, name string) error {
+	b, err := sysAllocString(name)
+	if err != nil {
+		return err
+	}
+	defer procSysFreeString.Call(b)
+	// Remove deletes one rule of the name, and succeeds for none, so Item
+	// tells whether there is one left.
+	for {
+		var rule *comObject
+		if err := rules.call(rulesItem, b, uintptr(unsafe.Pointer(&rule))); err != nil {
+			if errors.Is(err, windows.ERROR_FILE_NOT_FOUND) {
+				return nil
+			}
+			return err
+		}
+		rule.release()
+		if err := rules.call(rulesRemove, b); err != nil {
+			return err
+		}
+	}
+}
+
+// addRule creates the rule described by s.
+func addRule(rules *comObject, s *firewallSpec) error {
+	rule, err := coCreateInstance(&clsidNetFwRule, &iidINetFwRule)
+	if err != nil {
+		return err
+	}
+	defer rule.release()
+	direction, ports := uintptr(fwRuleDirIn), rulePutLocalPorts
+	if s.out {
+		direction, ports = fwRuleDirOut, rulePutRemotePorts
+	}
+	props := []struct {
+		method int
+		value  string
+	}{
+		{rulePutName, s.name},
+		{rulePutDescription, s.description},
+		{rulePutGrouping, "wsw"},
+		{rulePutApplicationName, s.program},
+		{rulePutRemoteAddresses, s.remoteIP},
+	}
+	for _, p := range props {
+		if p.value == "" {
+			continue
+		}
+		if err := rule.putString(p.method, p.value); err != nil {
+			return err
+		}
+	}
+	// The protocol has to be set before the ports.
+	if s.ports != "" {
+		protocol := uintptr(fwIPProtocolTCP)
+		if s.protocol == "udp" {
+			protocol = fwIPProtocolUDP
+		}
+		if err := rule.call(rulePutProtocol, protocol); err != nil {
+			return err
+		}
+		if err := rule.putString(ports, s.ports); err != nil {
+			return err
+		}
+	}
+	for _, p := range []struct {
+		method int
+		value  uintptr
+	}{
+		{rulePutDirection, direction},
+		{rulePutProfiles, fwProfiles[s.profile]},
+		{rulePutAction, fwActionAllow},
+		{rulePutEnabled, variantTrue},
+	} {
+		if err := rule.call(p.method, p.value); err != nil {
+			return err
+		}
+	}
+	return rules.call(rulesAdd, uintptr(unsafe.Pointer(rule)))
+}
+
+// addFirewallRules creates the Firewall rules, replacing rules of the same
+// name so that installing again or syncing updates them.
+func addFirewallRules(conf *Config) error {
+	specs := make([]*firewallSpec, 0, len(conf.Firewall))
+	for i := range conf.Firewall {
+		s, err := conf.Firewall[i].spec(conf)
+		if err != nil {
+			return err
+		}
+		specs = append(specs, s)
+	}
+	if len(specs) == 0 {
+		return nil
+	}
+	return withFirewallRules(func(rules *comObject) error {
+		for _, s := range specs {
+			if err := removeRule(rules, s.name); err != nil {
+				return fmt.Errorf("Failed to replace firewall rule %q: %v", s.name, err)
+			}
+			if err := addRule(rules, s); err != nil {
+				return fmt.Errorf("Failed to create firewall rule %q: %v", s.name, err)
+			}
+			infof("Created firewall rule %q", s.name)
+		}
+		return nil
+	})
+}
+
+// removeFirewallRules deletes the Firewall rules, only warning about rules
+// that cannot be, so that uninstalling goes on.
+func removeFirewallRules(conf *Config) {
+	if len(conf.Firewall) == 0 {
+		return
+	}
+	err := withFirewallRules(func(rules *comObject) error {
+		for i := range conf.Firewall {
+			name := conf.Firewall[i].ruleName(conf)
+			if err := removeRule(rules, name); err != nil {
+				warnf("Failed to remove firewall rule %q: %v", name, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		warnf("Failed to remove firewall rules: %v", err)
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// fleetRow is a service in the table of "wsw -a status --all".
+type fleetRow struct {
+	name     string
+	state    string
+	uptime   time.Duration
+	restarts int
+	memory   uint64
+}
+
+// fleetSorts orders the rows by each --sort key: names and states
+// alphabetically, the rest largest first.
+var fleetSorts = map[string]func(a, b *fleetRow) bool{
+	"name":     func(a, b *fleetRow) bool { return strings.ToLower(a.name) < strings.ToLower(b.name) },
+	"state":    func(a, b *fleetRow) bool { return a.state < b.state },
+	"uptime":   func(a, b *fleetRow) bool { return a.uptime > b.uptime },
+	"restarts": func(a, b *fleetRow) bool { return a.restarts > b.restarts },
+	"memory":   func(a, b *fleetRow) bool { return a.memory > b.memory },
+}
+
+// printFleetStatus shows a line for every installed wsw service matching the
+// filter glob: its state, how long it has been up, the restarts of its
+// processes in the last 24 hours and the working set of its process trees,
+// sorted by sortBy.
+func printFleetStatus(filter, sortBy string) error {
+	if sortBy == "" {
+		sortBy = "name"
+	}
+	less, ok := fleetSorts[sortBy]
+	if !ok {
+		return fmt.Errorf("Unknown sort %q, expected name, state, uptime, restarts or memory", sortBy)
+	}
+	services, err := matchServices(filter)
+	if err != nil {
+		return err
+	}
+	var rows []*fleetRow
+	for _, s := range services {
+		rows = append(rows, fleetStatus(s))
+	}
+	sort.SliceStable(rows, func(i, j int) bool { return less(rows[i], rows[j]) })
+	if jsonOutput {
+		out := make([]fleetJSON, 0, len(rows))
+		for _, r := range rows {
+			out = append(out, fleetJSON{Name: r.name, State: r.state, UptimeSeconds: seconds(r.uptime), Restarts24h: r.restarts, MemoryBytes: r.memory})
+		}
+		return printJSON(out)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "NAME\tSTATE\tUPTIME\tRESTARTS (24H)\tMEMORY\n")
+	for _, r := range rows {
+		uptime, memory := "-", "-"
+		if r.state == "running" {
+			uptime = r.uptime.Round(time.Second).String()
+			memory = formatBytes(r.memory)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\n", r.name, r.state, uptime, r.restarts, memory)
+	}
+	return w.Flush()
+}
+
+// fleetStatus reads the state of the service from the service manager and
+// what its wrapper recorded in the state directory, which it leaves alone if
+// there is none.
+func fleetStatus(s wswService) *fleetRow {
+	r := &fleetRow{name: s.Name}
+	state, err := queryServiceState(s.Name)
+	if err != nil {
+		state = "unknown"
+	}
+	r.state = state
+	dir := stateDirOf(exeConfig(s.Exe, s.Name), filepath.Dir(s.Exe))
+	now := time.Now()
+	stats, _ := readStatsFile(filepath.Join(dir, "stats.json"))
+	for _, s := range stats {
+		r.restarts += len(recentRestarts(s.Restarts, now))
+	}
+	if state != "running" {
+		return r
+	}
+	st, err := readRunStateFile(filepath.Join(dir, "run.json"))
+	if err != nil {
+		return r
+	}
+	for _, cs := range st.Children {
+		if up := now.Sub(cs.Started); up > r.uptime {
+			r.uptime = up
+		}
+	}
+	procs, _ := runStateTree(st)
+	for _, p := range procs {
+		if s, err := sampleProcess(p.pid); err == nil {
+			r.memory += s.workingSet
+		}
+	}
+	return r
+}
+
+// exeConfig returns the Name and Portable setting of the service name from
+// the config embedded in exe, next to it or in its portable folder.
+func exeConfig(exe, name string) *Config {
+	var configs [][]byte
+	if data, _, err := readEmbedded(exe); err == nil && data != nil {
+		configs = append(configs, data)
+	}
+	if data, err := ioutil.ReadFile(strings.TrimSuffix(exe, filepath.Ext(exe)) + ".json"); err == nil {
+		configs = append(configs, data)
+	}
+	if data, err := ioutil.ReadFile(filepath.Join(filepath.Dir(exe), portableDir, "config.json")); err == nil {
+		configs = append(configs, data)
+	}
+	for _, data := range configs {
+		var conf struct {
+			Name     string
+			Portable bool
+		}
+		if json.Unmarshal(data, &conf) == nil && strings.EqualFold(conf.Name, name) {
+			return &Config{Name: name, Portable: conf.Portable}
+		}
+	}
+	return &Config{Name: name}
+}
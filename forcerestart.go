@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Exit codes of "wsw -a restart" given --timeout or --force.
+const (
+	restartGraceful = 0
+	restartFailed   = 1
+	restartForced   = 2
+)
+
+// defaultRestartTimeout is how long a restart given only --force waits
+// for the service to stop: the longest StopTimeout of its processes, plus
+// this for the wrapper itself.
+const defaultRestartTimeout = 10 * time.Second
+
+// forceRestart stops the service, waits up to timeout for it to stop on its
+// own and starts it again. A service still running at the timeout fails the
+// restart, or with force is killed: the wrapper and the processes recorded
+// in the run state, with their descendants. It returns the exit code.
+func forceRestart(conf *Config, timeout time.Duration, force bool) int {
+	if timeout <= 0 {
+		var err error
+		if timeout, err = restartTimeout(conf); err != nil {
+			errorf("%v", err)
+			return restartFailed
+		}
+	}
+	code := restartGraceful
+	if err := awaitStop(conf.Name, timeout); err != nil {
+		if err != errStopTimeout {
+			errorf("Failed to stop %s: %v", conf.Name, err)
+			return restartFailed
+		}
+		if !force {
+			errorf("%s did not stop within %v, --force kills it", conf.Name, timeout)
+			return restartFailed
+		}
+		warnf("%s did not stop within %v, killing it", conf.Name, timeout)
+		if err := killService(conf); err != nil {
+			errorf("Failed to kill %s: %v", conf.Name, err)
+			return restartFailed
+		}
+		// The service manager notices the wrapper is gone.
+		if err := awaitStop(conf.Name, serviceStopWait); err != nil {
+			errorf("%s is still running after it was killed: %v", conf.Name, err)
+			return restartFailed
+		}
+		code = restartForced
+	}
+	if err := startService(conf.Name); err != nil {
+		errorf("Failed to start %s: %v", conf.Name, err)
+		return restartFailed
+	}
+	if code == restartForced {
+		infof("Restarted %s, killed after %v", conf.Name, timeout)
+	} else {
+		infof("Restarted %s", conf.Name)
+	}
+	return code
+}
+
+// serviceStopWait is how long the service manager gets to notice a killed
+// wrapper.
+const serviceStopWait = 30 * time.Second
+
+var errStopTimeout = fmt.Errorf("Timed out")
+
+// restartTimeout is the default of --timeout: the longest stop timeout of
+// the processes, plus defaultRestartTimeout.
+func restartTimeout(conf *Config) (time.Duration, error) {
+	p := &program{Config: conf}
+	children, err := p.newChildren()
+	if err != nil {
+		return 0, err
+	}
+	var longest time.Duration
+	for _, c := range children {
+		if t := c.stopTimeout(); t > longest {
+			longest = t
+		}
+	}
+	return longest + defaultRestartTimeout, nil
+}
+
+// awaitStop asks the service manager to stop the named service and waits up
+// to timeout for it to be stopped, returning errStopTimeout if it is not.
+func awaitStop(name string, timeout time.Duration) error {
+	done := make(chan error, 1)
+	go func() { done <- stopService(name) }()
+	deadline := time.After(timeout)
+	tick := time.NewTicker(500 * time.Millisecond)
+	defer tick.Stop()
+	for {
+		select {
+		case err := <-done:
+			done = nil
+			state, qerr := queryServiceState(name)
+			switch {
+			case qerr == nil && state == "stopped":
+				return nil
+			case err != nil && state != "stopping":
+				// Not a stop taking its time, but one that failed.
+				return err
+			}
+		case <-tick.C:
+			if state, err := queryServiceState(name); err == nil && state == "stopped" {
+				return nil
+			}
+		case <-deadline:
+			return errStopTimeout
+		}
+	}
+}
+
+// killService kills the wrapper recorded in the run state, as long as its
+// PID still runs the installed executable, then its processes and their
+// descendants.
+func killService(conf *Config) error {
+	st, err := readRunState(conf)
+	if err != nil {
+		return err
+	}
+	rows, _ := processTree(conf)
+	if st.WrapperPID != 0 {
+		scm, err := queryServiceConfig(conf.Name)
+		if err != nil {
+			return err
+		}
+		bin := filepath.Base(binaryExe(scm.BinaryPath))
+		if _, image, err := processInfo(st.WrapperPID); err == nil && strings.EqualFold(filepath.Base(image), bin) {
+			if err := killPID(st.WrapperPID); err != nil {
+				return err
+			}
+		}
+	}
+	for _, r := range rows {
+		killPID(r.pid)
+	}
+	return nil
+}
+
+func killPID(pid int) error {
+	proc, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+	return proc.Kill()
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"os"
+	"os/signal"
+)
+
+// initProcess is set by --init: "wsw -a run" then also does the work of a
+// container's init process, see startInit.
+var initProcess bool
+
+// runForeground runs the service until it is told to stop by a signal, or
+// until it ends on its own, and exits with its exit code.
+func runForeground(p *program) {
+	if initProcess {
+		startInit(p)
+	}
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, foregroundSignals...)
+	if err := p.Start(); err != nil {
+		logger.Error(err)
+		os.Exit(osExitCode(exitStartFailed))
+	}
+	for stop := false; !stop; {
+		select {
+		case sig := <-sigs:
+			stop = p.handleSignal(sig)
+		case <-p.done:
+			stop = true
+		case <-p.stopRequests:
+			stop = true
+		}
+	}
+	p.Stop()
+	os.Exit(osExitCode(p.exitCode))
+}
@@ -0,0 +1,603 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LogForward ships the child's output lines to a central log collector.
+type LogForward struct {
+	// Type is "fluentd" (forward protocol), "gelf" (Graylog),
+	// "elasticsearch" (bulk API) or "logstash" (HTTP input).
+	Type string
+	// Address is the collector's host:port, or its URL for elasticsearch
+	// and logstash.
+	Address string
+	// Protocol is "tcp" (default), "tls" or, for gelf, "udp".
+	Protocol string
+	// Tag is the fluentd tag, by default "wsw.<process name>".
+	Tag string
+	// Index is the elasticsearch index, "wsw-{date}" by default; {date}
+	// becomes the line's date as 2006.01.02.
+	Index string
+	// Username and Password authenticate to elasticsearch and logstash.
+	Username, Password string
+	// BufferSize is how many lines are kept while the collector cannot be
+	// reached (default 10000); the oldest are dropped beyond it.
+	BufferSize int
+}
+
+// logRecord is one line of child output.
+type logRecord struct {
+	Time    time.Time
+	Service string
+	Process string
+	Stream  string
+	Line    string
+}
+
+// logOutput writes batches of records to a collector over a connection it
+// (re)establishes on demand.
+type logOutput interface {
+	connect() error
+	write(records []*logRecord) error
+	close()
+}
+
+func checkLogForward(proc *Process) error {
+	for _, f := range proc.LogForward {
+		if f.Address == "" {
+			return fmt.Errorf("LogForward %q needs an Address", f.Type)
+		}
+		if _, err := newLogOutput(&f, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func newLogOutput(f *LogForward, name string) (logOutput, error) {
+	protocol := f.Protocol
+	if protocol == "" {
+		protocol = "tcp"
+	}
+	switch f.Type {
+	case "fluentd":
+		if protocol != "tcp" && protocol != "tls" {
+			return nil, fmt.Errorf("LogForward fluentd supports tcp and tls, not %q", protocol)
+		}
+		tag := f.Tag
+		if tag == "" {
+			tag = "wsw." + name
+		}
+		return &fluentdOutput{conn: connOutput{address: f.Address, protocol: protocol}, tag: tag}, nil
+	case "gelf":
+		if protocol != "tcp" && protocol != "tls" && protocol != "udp" {
+			return nil, fmt.Errorf("LogForward gelf supports udp, tcp and tls, not %q", protocol)
+		}
+		host, _ := os.Hostname()
+		return &gelfOutput{conn: connOutput{address: f.Address, protocol: protocol}, host: host}, nil
+	case "elasticsearch", "logstash":
+		u, err := url.Parse(f.Address)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return nil, fmt.Errorf("LogForward %s needs an http(s) URL, not %q", f.Type, f.Address)
+		}
+		index := f.Index
+		if index == "" {
+			index = "wsw-{date}"
+		}
+		host, _ := os.Hostname()
+		return &httpOutput{forward: f, index: index, host: host}, nil
+	}
+	return nil, fmt.Errorf("Unknown LogForward type %q, expected fluentd, gelf, elasticsearch or logstash", f.Type)
+}
+
+// spills reports whether records that do not fit the queue go to disk
+// rather than being dropped.
+func (f *LogForward) spills() bool {
+	return f.Type == "elasticsearch" || f.Type == "logstash"
+}
+
+// maxSpillSize caps a forwarder's disk buffer.
+const maxSpillSize = 100 << 20
+
+// forwarder queues records for a logOutput, sending them in batches from its
+// own goroutine so a slow or unreachable collector never blocks the child.
+// With a spill file, records beyond the queue and those left unsent when it
+// closes are kept on disk and sent once the collector is back; the loop does
+// the writing, so send never waits on the disk.
+type forwarder struct {
+	out   logOutput
+	name  string
+	spill string
+	mu    sync.Mutex
+	queue []*logRecord
+	// overflow holds the records pushed out of the queue until the loop
+	// spills them.
+	overflow []*logRecord
+	// spillMu serializes reading and appending to the spill file.
+	spillMu sync.Mutex
+	max     int
+	wake    chan struct{}
+	done    chan struct{}
+	ended   chan struct{}
+	// dropped counts records lost to a full queue since the last warning.
+	dropped int
+}
+
+func newForwarder(out logOutput, name, spill string, max int) *forwarder {
+	if max <= 0 {
+		max = 10000
+	}
+	f := &forwarder{
+		out:   out,
+		name:  name,
+		spill: spill,
+		max:   max,
+		wake:  make(chan struct{}, 1),
+		done:  make(chan struct{}),
+		ended: make(chan struct{}),
+	}
+	go f.loop()
+	return f
+}
+
+func (f *forwarder) send(r *logRecord) {
+	f.mu.Lock()
+	if len(f.queue) >= f.max {
+		if f.spill != "" && len(f.overflow) < f.max {
+			half := (len(f.queue) + 1) / 2
+			f.overflow = append(f.overflow, f.queue[:half]...)
+			f.queue = append(f.queue[:0], f.queue[half:]...)
+		} else {
+			f.queue = f.queue[1:]
+			f.dropped++
+		}
+	}
+	f.queue = append(f.queue, r)
+	f.mu.Unlock()
+	select {
+	case f.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (f *forwarder) loop() {
+	defer close(f.ended)
+	connected := false
+	defer func() {
+		if connected {
+			f.out.close()
+		}
+	}()
+	delay := time.Second
+	for {
+		f.mu.Lock()
+		overflow := f.overflow
+		f.overflow = nil
+		batch := f.queue
+		if len(batch) > 500 {
+			batch = batch[:500]
+		}
+		f.queue = f.queue[len(batch):]
+		dropped := f.dropped
+		f.dropped = 0
+		f.mu.Unlock()
+		f.spillRecords(overflow)
+		if dropped != 0 {
+			logger.Warningf("%s: dropped %d log lines, the collector is not keeping up", f.name, dropped)
+		}
+		if len(batch) == 0 {
+			if connected && f.unspill() {
+				continue
+			}
+			select {
+			case <-f.wake:
+				continue
+			case <-f.done:
+				return
+			}
+		}
+		err := error(nil)
+		if !connected {
+			err = f.out.connect()
+			connected = err == nil
+		}
+		if err == nil {
+			if err = f.out.write(batch); err != nil {
+				f.out.close()
+				connected = false
+			}
+		}
+		if err == nil {
+			delay = time.Second
+			continue
+		}
+		f.requeue(batch)
+		logger.Warningf("%s: failed to forward logs, retrying in %v: %v", f.name, delay, err)
+		select {
+		case <-time.After(delay):
+		case <-f.done:
+			f.spillQueue()
+			return
+		}
+		if delay *= 2; delay > 30*time.Second {
+			delay = 30 * time.Second
+		}
+	}
+}
+
+// requeue puts a batch that failed to send back in front of the queue.
+func (f *forwarder) requeue(batch []*logRecord) {
+	f.mu.Lock()
+	q := append(batch[:len(batch):len(batch)], f.queue...)
+	var over []*logRecord
+	if len(q) > f.max {
+		over = q[:len(q)-f.max]
+		q = q[len(q)-f.max:]
+	}
+	f.queue = q
+	f.mu.Unlock()
+	f.spillRecords(over)
+}
+
+// spillQueue empties the queue and the overflow into the spill file.
+func (f *forwarder) spillQueue() {
+	f.mu.Lock()
+	records := append(f.overflow, f.queue...)
+	f.overflow, f.queue = nil, nil
+	f.mu.Unlock()
+	f.spillRecords(records)
+}
+
+func (f *forwarder) drop(n int) {
+	f.mu.Lock()
+	f.dropped += n
+	f.mu.Unlock()
+}
+
+// spillRecords appends records to the spill file, dropping them if there is
+// none or it is full. It must not be called with f.mu held.
+func (f *forwarder) spillRecords(records []*logRecord) {
+	if len(records) == 0 {
+		return
+	}
+	if f.spill == "" {
+		f.drop(len(records))
+		return
+	}
+	f.spillMu.Lock()
+	defer f.spillMu.Unlock()
+	if fi, err := os.Stat(f.spill); err == nil && fi.Size() > maxSpillSize {
+		f.drop(len(records))
+		return
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, r := range records {
+		enc.Encode(r)
+	}
+	file, err := os.OpenFile(f.spill, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err == nil {
+		_, err = file.Write(buf.Bytes())
+		if cerr := file.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		logger.Warningf("%s: failed to buffer logs in %q: %v", f.name, f.spill, err)
+		f.drop(len(records))
+	}
+}
+
+// unspill moves records from the spill file back into the queue, reporting
+// whether there were any.
+func (f *forwarder) unspill() bool {
+	if f.spill == "" {
+		return false
+	}
+	// Appends wait for the file to be rewritten, rather than land between
+	// the read and the rewrite and be lost.
+	f.spillMu.Lock()
+	defer f.spillMu.Unlock()
+	data, err := ioutil.ReadFile(f.spill)
+	if err != nil || len(data) == 0 {
+		return false
+	}
+	lines := bytes.Split(bytes.TrimSpace(data), []byte("\n"))
+	var records []*logRecord
+	for len(lines) != 0 && len(records) < f.max {
+		r := &logRecord{}
+		if json.Unmarshal(lines[0], r) == nil {
+			records = append(records, r)
+		}
+		lines = lines[1:]
+	}
+	if len(lines) == 0 {
+		err = os.Remove(f.spill)
+	} else {
+		err = ioutil.WriteFile(f.spill, append(bytes.Join(lines, []byte("\n")), '\n'), 0644)
+	}
+	if err != nil {
+		logger.Warningf("%s: failed to update log buffer %q: %v", f.name, f.spill, err)
+		return false
+	}
+	f.mu.Lock()
+	f.queue = append(records, f.queue...)
+	f.mu.Unlock()
+	return len(records) != 0
+}
+
+// Close sends what is still queued, giving up after 5 seconds or at the
+// first error, and disconnects.
+func (f *forwarder) Close() error {
+	close(f.done)
+	select {
+	case <-f.ended:
+	case <-time.After(5 * time.Second):
+	}
+	f.spillQueue()
+	return nil
+}
+
+// connOutput is a TCP, TLS or UDP connection to a collector.
+type connOutput struct {
+	address  string
+	protocol string
+	conn     net.Conn
+}
+
+func (c *connOutput) connect() error {
+	var err error
+	switch c.protocol {
+	case "tls":
+		var conn net.Conn
+		if conn, err = dialTCP(c.address, 10*time.Second); err != nil {
+			break
+		}
+		host, _, _ := net.SplitHostPort(c.address)
+		tconn := tls.Client(conn, &tls.Config{ServerName: host})
+		tconn.SetDeadline(time.Now().Add(10 * time.Second))
+		if err = tconn.Handshake(); err != nil {
+			conn.Close()
+			break
+		}
+		tconn.SetDeadline(time.Time{})
+		c.conn = tconn
+	case "tcp":
+		c.conn, err = dialTCP(c.address, 10*time.Second)
+	default:
+		c.conn, err = net.DialTimeout(c.protocol, c.address, 10*time.Second)
+	}
+	return err
+}
+
+func (c *connOutput) send(b []byte) error {
+	c.conn.SetWriteDeadline(time.Now().Add(30 * time.Second))
+	_, err := c.conn.Write(b)
+	return err
+}
+
+func (c *connOutput) close() {
+	if c.conn != nil {
+		c.conn.Close()
+		c.conn = nil
+	}
+}
+
+// fluentdOutput speaks the fluentd forward protocol in forward mode:
+// [tag, [[time, record], ...]] encoded as MessagePack.
+type fluentdOutput struct {
+	conn connOutput
+	tag  string
+}
+
+func (o *fluentdOutput) connect() error { return o.conn.connect() }
+func (o *fluentdOutput) close()         { o.conn.close() }
+
+func (o *fluentdOutput) write(records []*logRecord) error {
+	var m msgpack
+	m.array(2)
+	m.str(o.tag)
+	m.array(len(records))
+	for _, r := range records {
+		m.array(2)
+		m.uint(uint64(r.Time.Unix()))
+		m.mapHeader(4)
+		m.str("service")
+		m.str(r.Service)
+		m.str("process")
+		m.str(r.Process)
+		m.str("stream")
+		m.str(r.Stream)
+		m.str("message")
+		m.str(r.Line)
+	}
+	return o.conn.send(m)
+}
+
+// msgpack is the bit of a MessagePack encoder fluentd needs.
+type msgpack []byte
+
+func (m *msgpack) array(n int) {
+	switch {
+	case n < 16:
+		*m = append(*m, 0x90|byte(n))
+	case n < 1<<16:
+		*m = append(*m, 0xdc, byte(n>>8), byte(n))
+	default:
+		*m = append(*m, 0xdd, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+	}
+}
+
+func (m *msgpack) mapHeader(n int) {
+	*m = append(*m, 0x80|byte(n))
+}
+
+func (m *msgpack) str(s string) {
+	n := len(s)
+	switch {
+	case n < 32:
+		*m = append(*m, 0xa0|byte(n))
+	case n < 1<<8:
+		*m = append(*m, 0xd9, byte(n))
+	case n < 1<<16:
+		*m = append(*m, 0xda, byte(n>>8), byte(n))
+	default:
+		*m = append(*m, 0xdb, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+	}
+	*m = append(*m, s...)
+}
+
+func (m *msgpack) uint(v uint64) {
+	*m = append(*m, 0xcf, byte(v>>56), byte(v>>48), byte(v>>40), byte(v>>32), byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+// gelfOutput sends GELF 1.1 messages: null terminated over TCP and TLS,
+// one datagram each, chunked when large, over UDP.
+type gelfOutput struct {
+	conn connOutput
+	host string
+}
+
+func (o *gelfOutput) connect() error { return o.conn.connect() }
+func (o *gelfOutput) close()         { o.conn.close() }
+
+func (o *gelfOutput) write(records []*logRecord) error {
+	for _, r := range records {
+		level := 6 // informational
+		if r.Stream == "stderr" {
+			level = 3 // error
+		}
+		msg, err := json.Marshal(map[string]interface{}{
+			"version":       "1.1",
+			"host":          o.host,
+			"short_message": r.Line,
+			"timestamp":     float64(r.Time.UnixNano()/1e6) / 1e3,
+			"level":         level,
+			"_service":      r.Service,
+			"_process":      r.Process,
+			"_stream":       r.Stream,
+		})
+		if err != nil {
+			return err
+		}
+		if o.conn.protocol == "udp" {
+			err = o.sendChunked(msg)
+		} else {
+			err = o.conn.send(append(msg, 0))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// httpOutput posts batches to the elasticsearch bulk API or a logstash
+// HTTP input.
+type httpOutput struct {
+	forward *LogForward
+	index   string
+	host    string
+}
+
+func (o *httpOutput) connect() error { return nil }
+func (o *httpOutput) close()         {}
+
+func (o *httpOutput) write(records []*logRecord) error {
+	var body bytes.Buffer
+	u := o.forward.Address
+	contentType := "application/json"
+	docs := []map[string]string{}
+	for _, r := range records {
+		docs = append(docs, map[string]string{
+			"@timestamp": r.Time.Format(time.RFC3339Nano),
+			"host":       o.host,
+			"service":    r.Service,
+			"process":    r.Process,
+			"stream":     r.Stream,
+			"message":    r.Line,
+		})
+	}
+	if o.forward.Type == "elasticsearch" {
+		if !strings.HasSuffix(u, "/_bulk") {
+			u = strings.TrimSuffix(u, "/") + "/_bulk"
+		}
+		contentType = "application/x-ndjson"
+		enc := json.NewEncoder(&body)
+		for i, doc := range docs {
+			index := strings.Replace(o.index, "{date}", records[i].Time.Format("2006.01.02"), -1)
+			enc.Encode(map[string]map[string]string{"index": {"_index": index}})
+			enc.Encode(doc)
+		}
+	} else if err := json.NewEncoder(&body).Encode(docs); err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", u, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	if o.forward.Username != "" {
+		req.SetBasicAuth(o.forward.Username, o.forward.Password)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("Unexpected status %s", resp.Status)
+	}
+	if o.forward.Type == "elasticsearch" {
+		// Rejected documents would be rejected again, so they are not
+		// retried.
+		var result struct{ Errors bool }
+		if json.NewDecoder(resp.Body).Decode(&result) == nil && result.Errors {
+			logger.Warningf("Elasticsearch rejected some of %d log lines sent to %s", len(records), o.forward.Address)
+		}
+	}
+	return nil
+}
+
+// gelfChunkSize keeps datagrams below common MTU-safe limits.
+const gelfChunkSize = 8000
+
+func (o *gelfOutput) sendChunked(msg []byte) error {
+	if len(msg) <= gelfChunkSize {
+		return o.conn.send(msg)
+	}
+	count := (len(msg) + gelfChunkSize - 1) / gelfChunkSize
+	if count > 128 {
+		count = 128
+		msg = msg[:128*gelfChunkSize]
+	}
+	id := make([]byte, 8)
+	now := time.Now().UnixNano()
+	for i := range id {
+		id[i] = byte(now >> (8 * i))
+	}
+	for i := 0; i < count; i++ {
+		chunk := msg[i*gelfChunkSize:]
+		if len(chunk) > gelfChunkSize {
+			chunk = chunk[:gelfChunkSize]
+		}
+		b := append([]byte{0x1e, 0x0f}, id...)
+		b = append(b, byte(i), byte(count))
+		if err := o.conn.send(append(b, chunk...)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Health serves the readiness of the service over HTTP, for load balancers
+// and keepalived to route on.
+type Health struct {
+	// Listen is the address to serve on, such as ":8099", and Path the URL
+	// path (default /healthz).
+	Listen string
+	Path   string
+	// Drain is how many seconds stopping the service keeps answering 503
+	// before stopping the processes, so that traffic moves away first.
+	Drain int
+}
+
+// serveHealth answers 200 while every process is ready and 503 while one
+// is starting or the service is stopping, listing the state of each.
+func (p *program) serveHealth() error {
+	ln, err := net.Listen("tcp", p.Health.Listen)
+	if err != nil {
+		return fmt.Errorf("Health: %v", err)
+	}
+	path := p.Health.Path
+	if path == "" {
+		path = "/healthz"
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+		state := "ready"
+		var lines []string
+		for _, c := range p.children {
+			s := c.state()
+			if s != "ready" {
+				state = "starting"
+			}
+			lines = append(lines, c.name+": "+s)
+		}
+		select {
+		case <-p.exit:
+			state = "stopping"
+		default:
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		if state != "ready" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		fmt.Fprintf(w, "%s\n%s\n", state, strings.Join(lines, "\n"))
+	})
+	go func() {
+		if err := http.Serve(ln, mux); err != nil {
+			logger.Warningf("Health: %v", err)
+		}
+	}()
+	return nil
+}
+
+// drain waits for load balancers to see the service stopping.
+func (p *program) drain() {
+	if p.Health == nil || p.Health.Drain <= 0 {
+		return
+	}
+	logger.Infof("Draining %s for %ds", p.DisplayName, p.Health.Drain)
+	time.Sleep(time.Duration(p.Health.Drain) * time.Second)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+)
+
+// historyEntry is one line of the service's event history, the audit trail
+// of upgrades, deployments and child exits shown by "wsw -a history".
+type historyEntry struct {
+	Time   time.Time
+	Event  string
+	Detail string
+	// Exit is the report of an "exit" or "crash" of a child.
+	Exit *exitReport `json:",omitempty"`
+}
+
+func historyPath(config *Config) (string, error) {
+	dir, err := getStateDir(config)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "history.jsonl"), nil
+}
+
+// recordHistory appends an event to the history.
+func recordHistory(config *Config, event, detail string) error {
+	return appendHistory(config, historyEntry{Time: time.Now(), Event: event, Detail: detail})
+}
+
+// appendHistory adds an entry to the history.
+func appendHistory(config *Config, e historyEntry) error {
+	path, err := historyPath(config)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (e *historyEntry) json() historyJSON {
+	h := historyJSON{Time: isoTime(e.Time), Event: e.Event, Detail: e.Detail}
+	if r := e.Exit; r != nil {
+		d, _ := time.ParseDuration(r.Duration)
+		h.Exit = &exitJSON{
+			Process:         r.Process,
+			PID:             r.PID,
+			ExitCode:        r.ExitCode,
+			Status:          r.Status,
+			DurationSeconds: seconds(d),
+			PeakMemoryBytes: r.PeakMemory,
+			BytesLogged:     r.BytesLogged,
+		}
+	}
+	return h
+}
+
+// printHistory shows the recorded events, oldest first.
+func printHistory(config *Config) error {
+	path, err := historyPath(config)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		if jsonOutput {
+			return printJSON([]historyJSON{})
+		}
+		infof("No history recorded")
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	out := []historyJSON{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e historyEntry
+		if json.Unmarshal(scanner.Bytes(), &e) != nil {
+			continue
+		}
+		if jsonOutput {
+			out = append(out, e.json())
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", e.Time.Format(time.RFC3339), e.Event, e.Detail)
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if jsonOutput {
+		return printJSON(out)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"os"
+	"strings"
+)
+
+// startInit reaps the orphans reparented to wsw when it runs as PID 1.
+func startInit(p *program) {
+	go reapOrphans()
+}
+
+// zombieChildren lists the exited children of wsw not yet waited for.
+func zombieChildren() ([]int, error) {
+	entries, err := ps()
+	if err != nil {
+		return nil, err
+	}
+	self := os.Getpid()
+	var pids []int
+	for _, e := range entries {
+		if e.ppid == self && strings.HasPrefix(e.state, "Z") {
+			pids = append(pids, e.pid)
+		}
+	}
+	return pids, nil
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"strconv"
+
+	"golang.org/x/sys/unix"
+)
+
+// startInit makes wsw the subreaper of the processes it starts, so orphans
+// are reparented to it even when it is not PID 1, and reaps them.
+func startInit(p *program) {
+	if err := unix.Prctl(unix.PR_SET_CHILD_SUBREAPER, 1, 0, 0, 0); err != nil {
+		logger.Warningf("Failed to become a subreaper: %v", err)
+	}
+	go reapOrphans()
+}
+
+// zombieChildren lists the exited children of wsw not yet waited for.
+func zombieChildren() ([]int, error) {
+	dirs, err := ioutil.ReadDir("/proc")
+	if err != nil {
+		return nil, err
+	}
+	self := os.Getpid()
+	var pids []int
+	for _, d := range dirs {
+		pid, err := strconv.Atoi(d.Name())
+		if err != nil {
+			continue
+		}
+		if st, err := readProcStat(pid); err == nil && st.ppid == self && st.state == "Z" {
+			pids = append(pids, pid)
+		}
+	}
+	return pids, nil
+}
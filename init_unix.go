@@ -0,0 +1,94 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// foregroundSignals are the signals the service run in the foreground
+// handles, see handleSignal.
+var foregroundSignals = []os.Signal{syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT, syscall.SIGHUP, syscall.SIGUSR1, syscall.SIGUSR2}
+
+// handleSignal tells whether sig stops the service. SIGINT and SIGTERM do,
+// and children without a StopSignal then get the same signal; the others are
+// forwarded to the process group of every child.
+func (p *program) handleSignal(sig os.Signal) bool {
+	s := sig.(syscall.Signal)
+	if s == syscall.SIGINT || s == syscall.SIGTERM {
+		defaultStopSignal = s
+		return true
+	}
+	for _, c := range p.children {
+		if c.group != nil {
+			syscall.Kill(-c.group.pgid, s)
+		} else if c.proc != nil {
+			c.proc.Signal(s)
+		}
+	}
+	return false
+}
+
+// waited holds the PIDs of the children wsw supervises, whose exit status
+// is theirs to wait for.
+var waited = struct {
+	sync.Mutex
+	pids map[int]bool
+}{pids: map[int]bool{}}
+
+// waitingFor keeps reapOrphans off pid until the returned func is called.
+func waitingFor(pid int) func() {
+	waited.Lock()
+	waited.pids[pid] = true
+	waited.Unlock()
+	return func() {
+		waited.Lock()
+		delete(waited.pids, pid)
+		waited.Unlock()
+	}
+}
+
+// reapOrphans waits for the exited processes left to wsw, which would
+// otherwise stay zombies. The children wsw supervises are never reaped here,
+// and a zombie is only reaped once it has been one for a second, leaving the
+// commands wsw runs and waits for at once to their own wait.
+func reapOrphans() {
+	sigchld := make(chan os.Signal, 1)
+	signal.Notify(sigchld, syscall.SIGCHLD)
+	tick := time.NewTicker(time.Second)
+	defer tick.Stop()
+	seen := map[int]time.Time{}
+	for {
+		select {
+		case <-sigchld:
+		case <-tick.C:
+		}
+		pids, err := zombieChildren()
+		if err != nil {
+			continue
+		}
+		now := time.Now()
+		next := map[int]time.Time{}
+		for _, pid := range pids {
+			first, ok := seen[pid]
+			if !ok {
+				next[pid] = now
+				continue
+			}
+			waited.Lock()
+			own := waited.pids[pid]
+			waited.Unlock()
+			if own || now.Sub(first) < time.Second {
+				next[pid] = first
+				continue
+			}
+			var ws syscall.WaitStatus
+			syscall.Wait4(pid, &ws, syscall.WNOHANG, nil)
+		}
+		seen = next
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// foregroundSignals stop the service run in the foreground: Ctrl+C, and
+// the console closing or the system shutting down, which a Windows
+// container gets from docker stop.
+var foregroundSignals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+
+// handleSignal stops the service on either signal; SIGTERM means the
+// console is closing, and the children know.
+func (p *program) handleSignal(sig os.Signal) bool {
+	if sig == syscall.SIGTERM {
+		p.shuttingDown = true
+	}
+	return true
+}
+
+// startInit has nothing to do: Windows does not keep exited processes
+// around for their parent to reap, and children get console events
+// themselves.
+func startInit(p *program) {}
+
+// waitingFor has nothing to do either, with no reaping to keep off pid.
+func waitingFor(pid int) func() {
+	return func() {}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// verifyExec checks the resolved executable against ExecSHA256 and
+// ExecSigner before it is launched.
+func (c *child) verifyExec(path string) error {
+	if c.ExecSHA256 != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		h := sha256.New()
+		_, err = io.Copy(h, f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+		if sum := hex.EncodeToString(h.Sum(nil)); !strings.EqualFold(sum, c.ExecSHA256) {
+			return fmt.Errorf("%q has SHA-256 %s, expected %s", path, sum, c.ExecSHA256)
+		}
+	}
+	if c.ExecSigner != "" {
+		return verifySigner(path, c.ExecSigner)
+	}
+	return nil
+}
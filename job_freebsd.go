@@ -0,0 +1,96 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// psEntry is a process as ps(1) lists it.
+type psEntry struct {
+	pid, ppid, pgid int
+	state           string
+	started         time.Time
+	name            string
+}
+
+// psStartLayout is the format of the lstart column in the C locale.
+const psStartLayout = "Mon Jan _2 15:04:05 2006"
+
+// ps lists processes, all of them unless pids are given. FreeBSD does not
+// mount procfs by default, so wsw asks ps rather than reading /proc.
+func ps(pids ...int) ([]psEntry, error) {
+	args := []string{"-ww", "-o", "pid=,ppid=,pgid=,state=,lstart=,comm="}
+	if len(pids) == 0 {
+		args = append(args, "-ax")
+	}
+	for _, pid := range pids {
+		args = append(args, "-p", strconv.Itoa(pid))
+	}
+	cmd := exec.Command("ps", args...)
+	cmd.Env = append(os.Environ(), "LC_ALL=C")
+	// ps exits non-zero when none of the pids exist.
+	out, err := cmd.Output()
+	if err != nil && len(out) == 0 {
+		if len(pids) != 0 {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("ps: %v", err)
+	}
+	var entries []psEntry
+	for _, line := range strings.Split(string(out), "\n") {
+		f := strings.Fields(line)
+		if len(f) < 10 {
+			continue
+		}
+		var e psEntry
+		e.pid, _ = strconv.Atoi(f[0])
+		e.ppid, _ = strconv.Atoi(f[1])
+		e.pgid, _ = strconv.Atoi(f[2])
+		e.state = f[3]
+		e.started, _ = time.ParseInLocation(psStartLayout, strings.Join(f[4:9], " "), time.Local)
+		e.name = strings.Join(f[9:], " ")
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// pids lists the processes in the group.
+func (g *processGroup) pids() ([]int, error) {
+	entries, err := ps()
+	if err != nil {
+		return nil, err
+	}
+	var pids []int
+	for _, e := range entries {
+		if e.pgid == g.pgid {
+			pids = append(pids, e.pid)
+		}
+	}
+	return pids, nil
+}
+
+// processInfo returns the start time and image path of a live process.
+func processInfo(pid int) (time.Time, string, error) {
+	entries, err := ps(pid)
+	if err != nil {
+		return time.Time{}, "", err
+	}
+	if len(entries) == 0 {
+		return time.Time{}, "", errors.New("Process not found")
+	}
+	if strings.HasPrefix(entries[0].state, "Z") {
+		return time.Time{}, "", errors.New("Process exited")
+	}
+	image, err := unix.SysctlRaw("kern.proc.pathname", pid)
+	if err != nil {
+		return time.Time{}, "", err
+	}
+	return entries[0].started, strings.TrimRight(string(image), "\x00"), nil
+}
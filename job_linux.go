@@ -0,0 +1,111 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// clockTicks is USER_HZ, the unit of the times in /proc/<pid>/stat; it is
+// 100 on every Linux architecture Go supports.
+const clockTicks = 100
+
+// pids lists the processes in the group.
+func (g *processGroup) pids() ([]int, error) {
+	dirs, err := ioutil.ReadDir("/proc")
+	if err != nil {
+		return nil, err
+	}
+	var pids []int
+	for _, d := range dirs {
+		pid, err := strconv.Atoi(d.Name())
+		if err != nil {
+			continue
+		}
+		if st, err := readProcStat(pid); err == nil && st.pgrp == g.pgid {
+			pids = append(pids, pid)
+		}
+	}
+	return pids, nil
+}
+
+// procStat holds the fields of /proc/<pid>/stat wsw uses.
+type procStat struct {
+	name       string
+	state      string
+	ppid, pgrp int
+	utime      uint64
+	stime      uint64
+	starttime  uint64
+	rss        uint64
+}
+
+func readProcStat(pid int) (*procStat, error) {
+	data, err := ioutil.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return nil, err
+	}
+	// The name, in parentheses, may itself hold spaces and parentheses.
+	s := string(data)
+	open, end := strings.IndexByte(s, '('), strings.LastIndexByte(s, ')')
+	if open < 0 || end < open {
+		return nil, errors.New("Malformed /proc stat")
+	}
+	f := strings.Fields(s[end+1:])
+	if len(f) < 22 {
+		return nil, errors.New("Malformed /proc stat")
+	}
+	num := func(i int) uint64 {
+		n, _ := strconv.ParseUint(f[i], 10, 64)
+		return n
+	}
+	return &procStat{
+		name:      s[open+1 : end],
+		state:     f[0],
+		ppid:      int(num(1)),
+		pgrp:      int(num(2)),
+		utime:     num(11),
+		stime:     num(12),
+		starttime: num(19),
+		rss:       num(21),
+	}, nil
+}
+
+var (
+	bootOnce sync.Once
+	bootTime time.Time
+)
+
+// processInfo returns the start time and image path of a live process.
+func processInfo(pid int) (time.Time, string, error) {
+	st, err := readProcStat(pid)
+	if err != nil {
+		return time.Time{}, "", err
+	}
+	if st.state == "Z" {
+		return time.Time{}, "", errors.New("Process exited")
+	}
+	image, err := os.Readlink(filepath.Join("/proc", strconv.Itoa(pid), "exe"))
+	if err != nil {
+		return time.Time{}, "", err
+	}
+	bootOnce.Do(func() {
+		data, err := ioutil.ReadFile("/proc/stat")
+		if err != nil {
+			return
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			if strings.HasPrefix(line, "btime ") {
+				if n, err := strconv.ParseInt(strings.TrimSpace(line[len("btime "):]), 10, 64); err == nil {
+					bootTime = time.Unix(n, 0)
+				}
+			}
+		}
+	})
+	return bootTime.Add(time.Duration(st.starttime) * time.Second / clockTicks), image, nil
+}
@@ -0,0 +1,46 @@
+//go:build !windows
+
+package main
+
+import "syscall"
+
+// processGroup is the process group the child leads, see newSysProcAttr,
+// holding the processes it starts unless they move to one of their own. The
+// child outlives a crashed wrapper and can be adopted.
+type processGroup struct {
+	pgid int
+}
+
+func newProcessGroup(pid int) (*processGroup, error) {
+	// An adopted child started by an older wrapper may not lead a group yet.
+	if pgid, err := syscall.Getpgid(pid); err != nil {
+		return nil, err
+	} else if pgid != pid {
+		if err := syscall.Setpgid(pid, pid); err != nil {
+			return nil, err
+		}
+	}
+	return &processGroup{pgid: pid}, nil
+}
+
+// terminate kills every process in the group.
+func (g *processGroup) terminate() error {
+	return syscall.Kill(-g.pgid, syscall.SIGKILL)
+}
+
+func (g *processGroup) close() error {
+	return nil
+}
+
+// peakMemory is unknown: the kernel keeps no peak for a process group.
+func (g *processGroup) peakMemory() uint64 {
+	return 0
+}
+
+// suspendProcess stops or continues a process.
+func suspendProcess(pid int, suspend bool) error {
+	if suspend {
+		return syscall.Kill(pid, syscall.SIGSTOP)
+	}
+	return syscall.Kill(pid, syscall.SIGCONT)
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// stillActive is the exit code GetExitCodeProcess reports for live processes.
+const stillActive = 259
+
+const jobObjectBasicProcessIdList = 3
+
+var (
+	ntdll                = windows.NewLazySystemDLL("ntdll.dll")
+	procNtSuspendProcess = ntdll.NewProc("NtSuspendProcess")
+	procNtResumeProcess  = ntdll.NewProc("NtResumeProcess")
+)
+
+// processGroup is a job object holding the child and, from then on, the
+// processes it starts, so the whole tree can be stopped together. The job is
+// not kill-on-close: the child outlives a crashed wrapper and can be adopted.
+type processGroup struct {
+	job windows.Handle
+}
+
+func newProcessGroup(pid int) (*processGroup, error) {
+	job, err := windows.CreateJobObject(nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	h, err := windows.OpenProcess(windows.PROCESS_SET_QUOTA|windows.PROCESS_TERMINATE, false, uint32(pid))
+	if err != nil {
+		windows.CloseHandle(job)
+		return nil, err
+	}
+	defer windows.CloseHandle(h)
+	if err := windows.AssignProcessToJobObject(job, h); err != nil {
+		windows.CloseHandle(job)
+		return nil, err
+	}
+	return &processGroup{job}, nil
+}
+
+// terminate kills every process in the group.
+func (g *processGroup) terminate() error {
+	return windows.TerminateJobObject(g.job, 1)
+}
+
+// pids lists the processes in the group.
+func (g *processGroup) pids() ([]int, error) {
+	// JOBOBJECT_BASIC_PROCESS_ID_LIST
+	var list struct {
+		assigned, listed uint32
+		ids              [1024]uintptr
+	}
+	err := windows.QueryInformationJobObject(g.job, jobObjectBasicProcessIdList, uintptr(unsafe.Pointer(&list)), uint32(unsafe.Sizeof(list)), nil)
+	if err != nil && err != windows.ERROR_MORE_DATA {
+		return nil, err
+	}
+	pids := make([]int, list.listed)
+	for i := range pids {
+		pids[i] = int(list.ids[i])
+	}
+	return pids, nil
+}
+
+// suspendProcess suspends or resumes every thread of a process.
+func suspendProcess(pid int, suspend bool) error {
+	h, err := windows.OpenProcess(windows.PROCESS_SUSPEND_RESUME, false, uint32(pid))
+	if err != nil {
+		return err
+	}
+	defer windows.CloseHandle(h)
+	proc := procNtResumeProcess
+	if suspend {
+		proc = procNtSuspendProcess
+	}
+	if err := proc.Find(); err != nil {
+		return err
+	}
+	if status, _, _ := proc.Call(uintptr(h)); status != 0 {
+		return windows.NTStatus(status)
+	}
+	return nil
+}
+
+func (g *processGroup) close() error {
+	return windows.CloseHandle(g.job)
+}
+
+// processInfo returns the creation time and image path of a live process.
+func processInfo(pid int) (time.Time, string, error) {
+	h, err := windows.OpenProcess(windows.PROCESS_QUERY_LIMITED_INFORMATION, false, uint32(pid))
+	if err != nil {
+		return time.Time{}, "", err
+	}
+	defer windows.CloseHandle(h)
+	var code uint32
+	if err := windows.GetExitCodeProcess(h, &code); err != nil {
+		return time.Time{}, "", err
+	} else if code != stillActive {
+		return time.Time{}, "", windows.ERROR_INVALID_PARAMETER
+	}
+	var created, exited, kernel, user windows.Filetime
+	if err := windows.GetProcessTimes(h, &created, &exited, &kernel, &user); err != nil {
+		return time.Time{}, "", err
+	}
+	buf := make([]uint16, windows.MAX_LONG_PATH)
+	n := uint32(len(buf))
+	if err := windows.QueryFullProcessImageName(h, 0, &buf[0], &n); err != nil {
+		return time.Time{}, "", err
+	}
+	return time.Unix(0, created.Nanoseconds()), windows.UTF16ToString(buf[:n]), nil
+}
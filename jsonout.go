@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+)
+
+// jsonOutput, set by --json, makes status, history and versions print JSON
+// for scripts and ConvertFrom-Json. The output is a contract kept across
+// versions: a top level array, even of one or no object; the field names
+// below, which are only ever added to; times in ISO 8601 UTC, null when
+// unknown; durations in whole seconds and sizes in bytes.
+var jsonOutput bool
+
+// statusJSON is a service in the output of "wsw -a status --json".
+type statusJSON struct {
+	Name       string        `json:"Name"`
+	State      string        `json:"State"`
+	Agent      bool          `json:"Agent"`
+	WrapperPID int           `json:"WrapperPID"`
+	Processes  []processJSON `json:"Processes"`
+}
+
+type processJSON struct {
+	Name                 string   `json:"Name"`
+	PID                  int      `json:"PID"`
+	Exec                 string   `json:"Exec"`
+	Args                 []string `json:"Args"`
+	Dir                  string   `json:"Dir"`
+	Started              *string  `json:"Started"`
+	TotalRestarts        int      `json:"TotalRestarts"`
+	Restarts24h          int      `json:"Restarts24h"`
+	LongestUptimeSeconds int64    `json:"LongestUptimeSeconds"`
+	LastCrash            *string  `json:"LastCrash"`
+	LastExitCode         *string  `json:"LastExitCode"`
+}
+
+// fleetJSON is a service in the output of "wsw -a status --all --json".
+type fleetJSON struct {
+	Name          string `json:"Name"`
+	State         string `json:"State"`
+	UptimeSeconds int64  `json:"UptimeSeconds"`
+	Restarts24h   int    `json:"Restarts24h"`
+	MemoryBytes   uint64 `json:"MemoryBytes"`
+}
+
+// historyJSON is an event in the output of "wsw -a history --json".
+type historyJSON struct {
+	Time   *string   `json:"Time"`
+	Event  string    `json:"Event"`
+	Detail string    `json:"Detail"`
+	Exit   *exitJSON `json:"Exit"`
+}
+
+type exitJSON struct {
+	Process         string `json:"Process"`
+	PID             int    `json:"PID"`
+	ExitCode        string `json:"ExitCode"`
+	Status          string `json:"Status"`
+	DurationSeconds int64  `json:"DurationSeconds"`
+	PeakMemoryBytes uint64 `json:"PeakMemoryBytes"`
+	// BytesLogged is -1 when unknown.
+	BytesLogged int64 `json:"BytesLogged"`
+}
+
+// versionJSON is a cached version in the output of "wsw -a versions --json".
+type versionJSON struct {
+	ID      int     `json:"ID"`
+	Version string  `json:"Version"`
+	SHA256  string  `json:"SHA256"`
+	Saved   *string `json:"Saved"`
+	Source  string  `json:"Source"`
+	Target  string  `json:"Target"`
+	Current bool    `json:"Current"`
+}
+
+// isoTime formats t in ISO 8601 UTC, nil for the zero time.
+func isoTime(t time.Time) *string {
+	if t.IsZero() {
+		return nil
+	}
+	s := t.UTC().Format(time.RFC3339)
+	return &s
+}
+
+func seconds(d time.Duration) int64 {
+	return int64(d / time.Second)
+}
+
+// printJSON writes v, indented, to stdout.
+func printJSON(v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(append(data, '\n'))
+	return err
+}
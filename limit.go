@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// dedupeReport is how long a run of repeated lines goes before it is
+// reported even though it continues.
+const dedupeReport = 30 * time.Second
+
+// lineLimiter collapses repeated lines and caps the lines per second of a
+// stream, reporting what it held back as notice lines.
+type lineLimiter struct {
+	dedupe bool
+	rate   int
+
+	last        string
+	repeats     int
+	repeatStart time.Time
+
+	second     time.Time
+	count      int
+	suppressed int
+}
+
+// pass tells whether the line goes through and returns the notices to
+// write before it.
+func (l *lineLimiter) pass(text string, now time.Time) ([]string, bool) {
+	var notices []string
+	if l.rate > 0 {
+		if sec := now.Truncate(time.Second); !sec.Equal(l.second) {
+			if l.suppressed != 0 {
+				notices = append(notices, fmt.Sprintf("wsw: %d lines suppressed by rate limit", l.suppressed))
+			}
+			l.second, l.count, l.suppressed = sec, 0, 0
+		}
+	}
+	if l.dedupe {
+		if text == l.last {
+			if l.repeats++; l.repeats == 1 {
+				l.repeatStart = now
+			}
+			if now.Sub(l.repeatStart) >= dedupeReport {
+				notices = append(notices, l.repeatNotice())
+			}
+			return notices, false
+		}
+		if l.repeats != 0 {
+			notices = append(notices, l.repeatNotice())
+		}
+		l.last = text
+	}
+	if l.rate > 0 {
+		if l.count++; l.count > l.rate {
+			l.suppressed++
+			return notices, false
+		}
+	}
+	return notices, true
+}
+
+func (l *lineLimiter) repeatNotice() string {
+	n := l.repeats
+	l.repeats = 0
+	return fmt.Sprintf("wsw: last message repeated %d times", n)
+}
+
+// flush returns the notices still pending when the stream ends.
+func (l *lineLimiter) flush() []string {
+	var notices []string
+	if l.repeats != 0 {
+		notices = append(notices, l.repeatNotice())
+	}
+	if l.suppressed != 0 {
+		notices = append(notices, fmt.Sprintf("wsw: %d lines suppressed by rate limit", l.suppressed))
+		l.suppressed = 0
+	}
+	return notices
+}
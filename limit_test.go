@@ -0,0 +1,50 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLineLimiter(t *testing.T) {
+	type line struct {
+		text string
+		at   time.Duration
+	}
+	for _, tt := range []struct {
+		name   string
+		dedupe bool
+		rate   int
+		lines  []line
+		want   []string
+	}{
+		{"off", false, 0, []line{{"a", 0}, {"a", 0}}, []string{"a", "a"}},
+		{"dedupe", true, 0, []line{{"a", 0}, {"a", 0}, {"a", 0}, {"b", 0}, {"a", 0}},
+			[]string{"a", "wsw: last message repeated 2 times", "b", "a"}},
+		{"dedupe at end", true, 0, []line{{"a", 0}, {"a", 0}},
+			[]string{"a", "wsw: last message repeated 1 times"}},
+		{"dedupe report", true, 0, []line{{"a", 0}, {"a", time.Second}, {"a", 20 * time.Second}, {"a", 31 * time.Second}, {"a", 32 * time.Second}},
+			[]string{"a", "wsw: last message repeated 3 times", "wsw: last message repeated 1 times"}},
+		{"rate", false, 2, []line{{"a", 0}, {"b", 0}, {"c", 0}, {"d", 0}, {"e", time.Second}},
+			[]string{"a", "b", "wsw: 2 lines suppressed by rate limit", "e"}},
+		{"rate at end", false, 1, []line{{"a", 0}, {"b", 0}},
+			[]string{"a", "wsw: 1 lines suppressed by rate limit"}},
+		{"both", true, 1, []line{{"a", 0}, {"a", 0}, {"b", 0}, {"c", time.Second}},
+			[]string{"a", "wsw: last message repeated 1 times", "wsw: 1 lines suppressed by rate limit", "c"}},
+	} {
+		l := &lineLimiter{dedupe: tt.dedupe, rate: tt.rate}
+		start := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+		var got []string
+		for _, line := range tt.lines {
+			notices, ok := l.pass(line.text, start.Add(line.at))
+			got = append(got, notices...)
+			if ok {
+				got = append(got, line.text)
+			}
+		}
+		got = append(got, l.flush()...)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,636 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// parseSize reads sizes such as "512KB", "10MB" or a plain number of bytes.
+func parseSize(s string) (int64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	t := strings.ToUpper(strings.TrimSpace(s))
+	mult := int64(1)
+	for _, unit := range []struct {
+		suffix string
+		mult   int64
+	}{{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"B", 1}} {
+		if strings.HasSuffix(t, unit.suffix) {
+			t, mult = strings.TrimSpace(strings.TrimSuffix(t, unit.suffix)), unit.mult
+			break
+		}
+	}
+	n, err := strconv.ParseInt(t, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("Invalid size %q", s)
+	}
+	return n * mult, nil
+}
+
+// logFile is an append-only log file that rotates once it grows past
+// maxSize, keeping maxFiles old copies as <path>.1 (newest) to <path>.N.
+// A file starting out empty gets header, such as a byte order mark, first.
+type logFile struct {
+	mu       sync.Mutex
+	path     string
+	maxSize  int64
+	maxFiles int
+	header   []byte
+
+	f    *os.File
+	size int64
+}
+
+func openLog(path string, maxSize int64, maxFiles int, header []byte) (*logFile, error) {
+	l := &logFile{path: path, maxSize: maxSize, maxFiles: maxFiles, header: header}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *logFile) open() error {
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0777)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.f, l.size = f, fi.Size()
+	if l.size == 0 && len(l.header) != 0 {
+		n, err := f.Write(l.header)
+		l.size += int64(n)
+		return err
+	}
+	return nil
+}
+
+func (l *logFile) Write(b []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		return 0, os.ErrClosed
+	}
+	if l.maxSize > 0 && l.size > 0 && l.size+int64(len(b)) > l.maxSize {
+		if err := l.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := l.f.Write(b)
+	l.size += int64(n)
+	return n, err
+}
+
+func (l *logFile) rotate() error {
+	l.f.Close()
+	l.f = nil
+	os.Remove(fmt.Sprintf("%s.%d", l.path, l.maxFiles))
+	for i := l.maxFiles - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", l.path, i), fmt.Sprintf("%s.%d", l.path, i+1))
+	}
+	if l.maxFiles > 0 {
+		os.Rename(l.path, l.path+".1")
+	} else {
+		os.Remove(l.path)
+	}
+	return l.open()
+}
+
+func (l *logFile) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		return nil
+	}
+	err := l.f.Close()
+	l.f = nil
+	return err
+}
+
+// prefixWriter writes prefix at the start of every line, with {time}
+// replaced by the current time.
+type prefixWriter struct {
+	w       io.Writer
+	prefix  string
+	midLine bool
+}
+
+func (p *prefixWriter) Write(b []byte) (int, error) {
+	n := len(b)
+	var buf bytes.Buffer
+	for len(b) > 0 {
+		if !p.midLine {
+			buf.WriteString(strings.Replace(p.prefix, "{time}", time.Now().Format(time.RFC3339), -1))
+		}
+		i := bytes.IndexByte(b, '\n')
+		if i < 0 {
+			buf.Write(b)
+			p.midLine = true
+			break
+		}
+		buf.Write(b[:i+1])
+		b = b[i+1:]
+		p.midLine = false
+	}
+	if _, err := p.w.Write(buf.Bytes()); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// defaultMaxLine bounds the lines held in memory when LogMaxLineLength is
+// not set.
+const defaultMaxLine = 1 << 20
+
+// consoleLogs tells whether unset Stdout and Stderr go to wsw's own output:
+// in a container run in the foreground, whose output is the container log.
+func consoleLogs() bool {
+	return inContainer() && interactive()
+}
+
+// defaultLogs points an unset Stdout or Stderr at <name>.out.log or
+// <name>.err.log in the logs folder next to wsw, rather than discarding the
+// output, unless they go to the console.
+func (c *child) defaultLogs() error {
+	if c.Stdout != "" && c.Stderr != "" || consoleLogs() {
+		return nil
+	}
+	dir, _, err := getExecPath()
+	if err != nil {
+		return err
+	}
+	proc := *c.Process
+	if proc.Stdout == "" {
+		proc.Stdout = filepath.Join(dir, "logs", c.name+".out.log")
+	}
+	if proc.Stderr == "" {
+		proc.Stderr = filepath.Join(dir, "logs", c.name+".err.log")
+	}
+	c.mu.Lock()
+	c.Process = &proc
+	c.mu.Unlock()
+	return nil
+}
+
+// rotateOnStart moves the existing Stdout and Stderr files aside as
+// <file>.<yyyymmdd-hhmmss>, so the run starting gets fresh ones.
+func (c *child) rotateOnStart() {
+	suffix := "." + time.Now().Format("20060102-150405")
+	for _, name := range []string{c.Stdout, c.Stderr} {
+		path := c.childPath(name)
+		if path == "" || strings.EqualFold(name, os.DevNull) {
+			continue
+		}
+		if fi, err := os.Stat(path); err != nil || fi.Size() == 0 {
+			continue
+		}
+		if err := os.Rename(path, path+suffix); err != nil {
+			logger.Warningf("%s: failed to rotate %q: %v", c.label(), path, err)
+		}
+	}
+}
+
+// logPruneInterval is how often capLogs checks the total log size.
+const logPruneInterval = time.Minute
+
+// capLogs keeps the log files of all children below max bytes, checking
+// every logPruneInterval, by deleting the oldest rotated files. Files in
+// use are never deleted.
+func (p *program) capLogs(max int64) {
+	tick := time.NewTicker(logPruneInterval)
+	defer tick.Stop()
+	for {
+		p.pruneLogs(max)
+		select {
+		case <-tick.C:
+		case <-p.exit:
+			return
+		}
+	}
+}
+
+func (p *program) pruneLogs(max int64) {
+	active := map[string]bool{}
+	for _, c := range p.children {
+		c.mu.Lock()
+		names := []string{c.Stdout, c.Stderr}
+		for _, f := range c.LogFilters {
+			names = append(names, f.To)
+		}
+		c.mu.Unlock()
+		for _, name := range names {
+			if path := c.childPath(name); path != "" && !strings.EqualFold(name, os.DevNull) {
+				active[filepath.Clean(path)] = true
+			}
+		}
+	}
+	var total int64
+	var rotated []os.FileInfo
+	var paths []string
+	seen := map[string]bool{}
+	for path := range active {
+		if fi, err := os.Stat(path); err == nil {
+			total += fi.Size()
+		}
+		// Rotated copies are <file>.N and <file>.<timestamp>.
+		matches, _ := filepath.Glob(path + ".*")
+		for _, m := range matches {
+			if active[m] || seen[m] {
+				continue
+			}
+			seen[m] = true
+			if fi, err := os.Stat(m); err == nil && !fi.IsDir() {
+				total += fi.Size()
+				rotated = append(rotated, fi)
+				paths = append(paths, m)
+			}
+		}
+	}
+	for total > max && len(rotated) != 0 {
+		oldest := 0
+		for i, fi := range rotated {
+			if fi.ModTime().Before(rotated[oldest].ModTime()) {
+				oldest = i
+			}
+		}
+		if err := os.Remove(paths[oldest]); err != nil {
+			logger.Warningf("Failed to prune log %q: %v", paths[oldest], err)
+		} else {
+			total -= rotated[oldest].Size()
+		}
+		rotated = append(rotated[:oldest], rotated[oldest+1:]...)
+		paths = append(paths[:oldest], paths[oldest+1:]...)
+	}
+}
+
+// lineWriter hands every complete line written to it, including the line
+// ending, to emit. Lines longer than max bytes are cut, the rest of them
+// counted in a "[truncated N bytes]" note. Close emits a last unterminated
+// line, then calls done.
+type lineWriter struct {
+	emit func(line []byte)
+	done func()
+	max  int
+	buf  []byte
+	// head is the start of an overlong line whose rest is being skipped.
+	head    []byte
+	skipped int
+}
+
+func (l *lineWriter) Write(b []byte) (int, error) {
+	n := len(b)
+	for len(b) > 0 {
+		i := bytes.IndexByte(b, '\n')
+		if l.head != nil {
+			if i < 0 {
+				l.skipped += len(b)
+				break
+			}
+			l.skipped += i
+			l.emitTruncated()
+			b = b[i+1:]
+			continue
+		}
+		if i < 0 {
+			l.buf = append(l.buf, b...)
+			b = nil
+		} else {
+			l.buf = append(l.buf, b[:i+1]...)
+			b = b[i+1:]
+			line := l.buf
+			l.buf = nil
+			if l.max > 0 && len(line)-1 > l.max {
+				l.head, l.skipped = line[:l.max], len(line)-1-l.max
+				l.emitTruncated()
+			} else {
+				l.emit(line)
+			}
+			continue
+		}
+		if l.max > 0 && len(l.buf) > l.max {
+			l.head, l.skipped = l.buf[:l.max], len(l.buf)-l.max
+			l.buf = nil
+		}
+	}
+	return n, nil
+}
+
+func (l *lineWriter) emitTruncated() {
+	l.emit(append(l.head, fmt.Sprintf(" [truncated %d bytes]\n", l.skipped)...))
+	l.head, l.skipped = nil, 0
+}
+
+func (l *lineWriter) Close() error {
+	if l.head != nil {
+		l.emitTruncated()
+	}
+	if len(l.buf) != 0 {
+		l.emit(l.buf)
+		l.buf = nil
+	}
+	if l.done != nil {
+		l.done()
+	}
+	return nil
+}
+
+// isBinary tells whether a line looks like binary data rather than text: it
+// holds a NUL or more than a tenth of it is control characters or invalid
+// UTF-8.
+func isBinary(text string) bool {
+	bad := 0
+	for i := 0; i < len(text); {
+		r, size := utf8.DecodeRuneInString(text[i:])
+		switch {
+		case r == 0:
+			return true
+		case r == utf8.RuneError && size == 1, r < 0x20 && r != '\t' && r != '\r', r == 0x7f:
+			bad++
+		}
+		i += size
+	}
+	return bad*10 > len(text)
+}
+
+var (
+	// consoleMu keeps lines of different streams apart on the console.
+	consoleMu sync.Mutex
+	colorOnce sync.Once
+	useColor  bool
+)
+
+// teeConsole returns a writer mirroring a stream to the console, each line
+// marked with the process and stream, stderr in red and stdout in cyan when
+// the console takes colors.
+func (c *child) teeConsole(stream string) *lineWriter {
+	color := colorCyan
+	if stream == "stderr" {
+		color = colorRed
+	}
+	tag := paint(os.Stdout, color, fmt.Sprintf("[%s %s] ", c.label(), stream))
+	return &lineWriter{max: defaultMaxLine, emit: func(line []byte) {
+		consoleMu.Lock()
+		defer consoleMu.Unlock()
+		os.Stdout.WriteString(tag + strings.TrimRight(string(line), "\r\n") + "\n")
+	}}
+}
+
+// openOutput points c.cmd.Stdout and Stderr at the configured files and log
+// forwarders. Plain files are handed to the child directly; rotation, a
+// prefix, filters or forwarding puts wsw in between. The returned function closes
+// the files once the child exited.
+func (c *child) openOutput() (func(), error) {
+	maxSize, err := parseSize(c.LogMaxSize)
+	if err != nil {
+		return nil, err
+	}
+	maxFiles := c.LogMaxFiles
+	if maxFiles == 0 {
+		maxFiles = 5
+	}
+	var closers []io.Closer
+	// Closed last to first, so writers flush into what they feed.
+	closeAll := func() {
+		for i := len(closers) - 1; i >= 0; i-- {
+			closers[i].Close()
+		}
+	}
+	logs := map[string]*logFile{}
+	open := func(name string) (io.Writer, error) {
+		path := c.childPath(name)
+		if strings.EqualFold(name, os.DevNull) {
+			path = os.DevNull
+		} else if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return nil, err
+		}
+		plain := c.LogEncoding == "" || c.LogEncoding == "utf-8"
+		if maxSize == 0 && c.LogPrefix == "" && plain && c.LogNewline == "" {
+			f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0777)
+			if err != nil {
+				return nil, err
+			}
+			closers = append(closers, f)
+			return f, nil
+		}
+		// Stdout and Stderr going to one file share its rotation.
+		l := logs[path]
+		if l == nil {
+			if l, err = openLog(path, maxSize, maxFiles, logHeader(c.LogEncoding)); err != nil {
+				return nil, err
+			}
+			logs[path] = l
+			closers = append(closers, l)
+		}
+		var w io.Writer = l
+		if !plain || c.LogNewline != "" {
+			e := &encodeWriter{w: l, utf16: c.LogEncoding == "utf-16", newline: c.LogNewline}
+			closers = append(closers, e)
+			w = e
+		}
+		if c.LogPrefix == "" {
+			return w, nil
+		}
+		return &prefixWriter{w: w, prefix: strings.Replace(c.LogPrefix, "{name}", c.name, -1)}, nil
+	}
+	var forwarders []*forwarder
+	for i := range c.LogForward {
+		out, err := newLogOutput(&c.LogForward[i], c.name)
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		spill := ""
+		if c.LogForward[i].spills() {
+			dir, err := getStateDir(c.prg.Config)
+			if err != nil {
+				closeAll()
+				return nil, err
+			}
+			spill = filepath.Join(dir, fmt.Sprintf("logbuffer-%s-%d.jsonl", c.name, i))
+		}
+		f := newForwarder(out, c.label(), spill, c.LogForward[i].BufferSize)
+		closers = append(closers, f)
+		forwarders = append(forwarders, f)
+	}
+	// lines runs a stream through the filters and forwarders line by line
+	// on its way to w.
+	lines := func(w io.Writer, stream string) (io.Writer, error) {
+		if len(forwarders) == 0 && len(c.LogFilters) == 0 && !c.LogDedupe && c.LogRateLimit <= 0 &&
+			c.LogMaxLineLength <= 0 && c.LogBinary == "" {
+			return w, nil
+		}
+		var filters []lineFilter
+		for _, f := range c.LogFilters {
+			if f.Stream != "" && f.Stream != stream {
+				continue
+			}
+			lf := lineFilter{re: regexp.MustCompile(f.Match), action: f.Action}
+			if f.Action == "route" {
+				if lf.w, err = open(f.To); err != nil {
+					return nil, fmt.Errorf("Failed to open %q: %v", f.To, err)
+				}
+			}
+			filters = append(filters, lf)
+		}
+		deliver := func(out io.Writer, line []byte, text string, forward bool) {
+			if out != nil {
+				out.Write(line)
+			}
+			if !forward {
+				return
+			}
+			r := &logRecord{Time: time.Now(), Service: c.prg.Name, Process: c.name, Stream: stream, Line: text}
+			for _, f := range forwarders {
+				f.send(r)
+			}
+		}
+		notify := func(notices []string) {
+			for _, n := range notices {
+				deliver(w, []byte(n+"\n"), n, true)
+			}
+		}
+		limiter := &lineLimiter{dedupe: c.LogDedupe, rate: c.LogRateLimit}
+		maxLine := c.LogMaxLineLength
+		if maxLine <= 0 {
+			maxLine = defaultMaxLine
+		}
+		lw := &lineWriter{max: maxLine, emit: func(line []byte) {
+			text := strings.TrimRight(string(line), "\r\n")
+			if c.LogBinary != "" && isBinary(text) {
+				if c.LogBinary == "drop" {
+					text = fmt.Sprintf("wsw: dropped %d bytes of binary output", len(text))
+				} else {
+					text = "wsw: binary output: " + hex.EncodeToString([]byte(text))
+				}
+				line = []byte(text + "\n")
+			}
+			out, forward := filterLine(filters, text, w)
+			if out == nil && !forward {
+				return
+			}
+			notices, ok := limiter.pass(text, time.Now())
+			notify(notices)
+			if ok {
+				deliver(out, line, text, forward)
+			}
+		}}
+		lw.done = func() { notify(limiter.flush()) }
+		closers = append(closers, lw)
+		return lw, nil
+	}
+	var stdout, stderr io.Writer
+	if c.Stderr != "" {
+		if stderr, err = open(c.Stderr); err != nil {
+			closeAll()
+			return nil, fmt.Errorf("Failed to open std err %q: %v", c.Stderr, err)
+		}
+	} else if consoleLogs() {
+		stderr = os.Stderr
+	}
+	if c.Stdout != "" {
+		if stdout, err = open(c.Stdout); err != nil {
+			closeAll()
+			return nil, fmt.Errorf("Failed to open std out %q: %v", c.Stdout, err)
+		}
+	} else if consoleLogs() {
+		stdout = os.Stdout
+	}
+	if stderr, err = lines(stderr, "stderr"); err != nil {
+		closeAll()
+		return nil, err
+	}
+	if stdout, err = lines(stdout, "stdout"); err != nil {
+		closeAll()
+		return nil, err
+	}
+	if interactive() {
+		tee := func(w io.Writer, stream string) io.Writer {
+			t := c.teeConsole(stream)
+			closers = append(closers, t)
+			if w == nil {
+				return t
+			}
+			return io.MultiWriter(w, t)
+		}
+		// Streams already going to the console are not mirrored.
+		if c.Stderr != "" || !consoleLogs() {
+			stderr = tee(stderr, "stderr")
+		}
+		if c.Stdout != "" || !consoleLogs() {
+			stdout = tee(stdout, "stdout")
+		}
+	}
+	// Consoles attached with "wsw -a attach" get what goes through wsw, and
+	// follow the files the child writes to directly.
+	for _, std := range []struct {
+		w      *io.Writer
+		stream string
+	}{{&stdout, "stdout"}, {&stderr, "stderr"}} {
+		var closer io.Closer
+		if *std.w, closer = c.attach.tee(*std.w, std.stream); closer != nil {
+			closers = append(closers, closer)
+		}
+	}
+	tracef("%s: stdout %q, stderr %q, %d forwarders", c.label(), c.childPath(c.Stdout), c.childPath(c.Stderr), len(forwarders))
+	c.output = &outputCounter{}
+	if stderr != nil {
+		c.cmd.Stderr = c.output.wrap(stderr)
+	}
+	if stdout != nil {
+		c.cmd.Stdout = c.output.wrap(stdout)
+	}
+	return closeAll, nil
+}
+
+// pipeOutput replaces Stdout and Stderr writers that are not files with
+// pipes, for launches that can only hand files to the child. Call started
+// once the child was created to drop its pipe ends, and copied after it
+// exited to wait for the remaining output.
+func pipeOutput(cmd *exec.Cmd) (started, copied func(), err error) {
+	var ends []*os.File
+	var wg sync.WaitGroup
+	for _, std := range []*io.Writer{&cmd.Stdout, &cmd.Stderr} {
+		if *std == nil {
+			continue
+		}
+		if _, ok := (*std).(*os.File); ok {
+			continue
+		}
+		r, w, err := os.Pipe()
+		if err != nil {
+			for _, f := range ends {
+				f.Close()
+			}
+			return nil, nil, err
+		}
+		ends = append(ends, w)
+		wg.Add(1)
+		go func(out io.Writer) {
+			defer wg.Done()
+			defer r.Close()
+			io.Copy(out, r)
+		}(*std)
+		*std = w
+	}
+	started = func() {
+		for _, f := range ends {
+			f.Close()
+		}
+	}
+	return started, wg.Wait, nil
+}
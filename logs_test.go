@@ -0,0 +1,58 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLineWriter(t *testing.T) {
+	for _, tt := range []struct {
+		writes []string
+		max    int
+		want   []string
+	}{
+		{[]string{"a\nb\n"}, 0, []string{"a\n", "b\n"}},
+		{[]string{"a", "b\nc", "\n"}, 0, []string{"ab\n", "c\n"}},
+		{[]string{"a\nrest"}, 0, []string{"a\n", "rest"}},
+		{[]string{"\n\n"}, 0, []string{"\n", "\n"}},
+		{[]string{"abcdef\nxy\n"}, 3, []string{"abc [truncated 3 bytes]\n", "xy\n"}},
+		{[]string{"abc\n"}, 3, []string{"abc\n"}},
+		{[]string{"ab", "cd", "ef", "gh\n", "x\n"}, 3, []string{"abc [truncated 5 bytes]\n", "x\n"}},
+		{[]string{"abcdef"}, 3, []string{"abc [truncated 3 bytes]\n"}},
+		{[]string{"abcd", "ef\nxy"}, 3, []string{"abc [truncated 3 bytes]\n", "xy"}},
+	} {
+		var got []string
+		l := &lineWriter{max: tt.max, emit: func(line []byte) {
+			got = append(got, string(line))
+		}}
+		for _, w := range tt.writes {
+			if n, err := l.Write([]byte(w)); n != len(w) || err != nil {
+				t.Fatalf("Write(%q) = %d, %v", w, n, err)
+			}
+		}
+		l.Close()
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("writing %q with max %d emitted %q, want %q", tt.writes, tt.max, got, tt.want)
+		}
+	}
+}
+
+func TestIsBinary(t *testing.T) {
+	for _, tt := range []struct {
+		text string
+		want bool
+	}{
+		{"", false},
+		{"plain text\r\n", false},
+		{"tab\tseparated", false},
+		{"héllo wörld", false},
+		{"a\x00b", true},
+		{"\xff\xfe\xfd\xfc", true},
+		{"one \x1b[31mred\x1b[0m word here", false},
+		{"\x01\x02\x03abc", true},
+	} {
+		if got := isBinary(tt.text); got != tt.want {
+			t.Errorf("isBinary(%q) = %v, want %v", tt.text, got, tt.want)
+		}
+	}
+}
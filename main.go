@@ -5,123 +5,315 @@ import (
 	"flag"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
 
 	"github.com/kardianos/osext"
-	"github.com/mingxi/service"
-	"golang.org/x/sys/windows/registry"
 )
 
 // Config is the runner app config structure.
 type Config struct {
 	Name, DisplayName, Description string
 
-	Dir  string
-	Exec string
-	Args []string
-	Env  []string
+	// Process is the main child. Processes are run alongside it or, without
+	// an Exec, instead of it.
+	Process
+	Processes []Process
 
-	Stderr, Stdout string
+	// StartTimeout, in seconds, keeps the service start pending until every
+	// essential process is ready, failing the start after that long.
+	StartTimeout int
+
+	// Mode is "service" (default) for a long running child, "oneshot" for a
+	// task that runs once, the service then ending with its exit code, or
+	// "scheduled" to start the children whenever the Schedule cron
+	// expression fires.
+	Mode     string
+	Schedule string
+	// Overlap is what a scheduled run does while the previous one is still
+	// going: "skip" (default), "queue" or "kill-previous".
+	Overlap string
+
+	// StopBehavior is "kill" (default) or "detach" to leave the child running
+	// when the service stops.
+	StopBehavior string
+
+	// LogMaxTotalSize, such as "1GB", caps the disk space of all log files
+	// of the service by pruning the oldest rotated ones.
+	LogMaxTotalSize string
+
+	// Dependencies lists services that must run before this one.
+	Dependencies []string
+	// Triggers, when set, install the service as manual and trigger started.
+	Triggers []Trigger
+	// LoadOrderGroup and Tag place the service in the legacy boot ordering
+	// of ServiceGroupOrder and GroupOrderList.
+	LoadOrderGroup string
+	Tag            uint32
+
+	// Portable keeps wsw out of the registry, resolves relative paths
+	// against the wsw directory and stores state in a local .wsw folder.
+	Portable bool
+
+	// KeepVersions caches this many versions of the application, saved by
+	// upgrade and when a Download brings a new executable, for rollback.
+	KeepVersions int
+
+	// UpdateURL is where self-update downloads new wsw builds from.
+	UpdateURL string
+
+	// ConfigURL is fetched on every start and laid over this config. When
+	// ConfigKey (a base64 Ed25519 public key) is set, the response must carry
+	// a valid X-Wsw-Signature header.
+	ConfigURL, ConfigKey string
+
+	// Secrets configures the providers of secret:// references.
+	Secrets Secrets
+
+	// Consul registers the processes with a local Consul agent.
+	Consul *Consul
+	// Etcd publishes the status of the service into etcd.
+	Etcd *Etcd
+	// Health serves an HTTP health endpoint reflecting readiness.
+	Health *Health
+
+	// Firewall lists Windows Firewall rules created on install and removed
+	// on uninstall.
+	Firewall []FirewallRule
+	// URLACLs lists HTTP.SYS URL reservations made on install and removed
+	// on uninstall.
+	URLACLs []URLACL
+	// CertBindings binds certificates to HTTPS ports in HTTP.SYS on
+	// install, removing the bindings on uninstall.
+	CertBindings []CertBinding
+	// SPNs are Service Principal Names registered for the service account
+	// on install and removed on uninstall.
+	SPNs []string
+
+	// Cluster configures the Failover Cluster resource of the service.
+	Cluster *Cluster
+
+	// Proxy is the proxy for wsw's own outbound connections.
+	Proxy *Proxy
+}
+
+// Logger is where the service logs to: the event log, or the console when
+// run interactively.
+type Logger interface {
+	Error(v ...interface{}) error
+	Warning(v ...interface{}) error
+	Info(v ...interface{}) error
+
+	Errorf(format string, a ...interface{}) error
+	Warningf(format string, a ...interface{}) error
+	Infof(format string, a ...interface{}) error
 }
 
-var logger service.Logger
+var logger Logger
+
+// version is set at build time with -ldflags "-X main.version=...".
+var version = "dev"
+
+// Service specific exit codes reported to the SCM when wsw, rather than the
+// child, is why the service ended. Otherwise the child's exit code is used.
+const (
+	// exitStartFailed: the config is invalid or a child could not be
+	// prepared, e.g. its executable is missing.
+	exitStartFailed = 10001
+	// exitLaunchFailed: a child could not be launched.
+	exitLaunchFailed = 10002
+	// exitNotReady: a child did not get ready within its Ready timeout.
+	exitNotReady = 10003
+	// exitCrashLoop: a child hit its RestartLimit.
+	exitCrashLoop = 10004
+)
 
 type program struct {
-	exit    chan struct{}
-	service service.Service
+	exit chan struct{}
+	// done is closed once the program ended on its own, with exitCode the
+	// code to report.
+	done     chan struct{}
+	exitCode int
+	// stopRequests takes the "stop" control command of a wrapper running in
+	// the foreground.
+	stopRequests chan struct{}
+	// agent is set when wsw runs as an agent, see runAgent.
+	agent bool
+	// shuttingDown is set when the system or the console shuts down, which
+	// Windows tells the children too.
+	shuttingDown bool
 
 	*Config
 
-	cmd *exec.Cmd
+	children []*child
+	// etcd publishes the status with Etcd.
+	etcd *etcdPublisher
 }
 
-func (p *program) Start(s service.Service, args ...string) error {
+func (p *program) Start(args ...string) error {
+	traceArgs(args)
+	tracef("Config from %s", configSource)
+	tracef("Mode %q, start parameters %q", p.Mode, args)
 	p.setEnvs()
-	// Look for exec.
-	// Verify home directory.
-	if p.Dir != "" {
-		fi, err := os.Stat(p.Dir)
-		if err != nil {
+	switch p.Mode {
+	case "", "service", "oneshot":
+	case "scheduled":
+		if _, err := parseCron(p.Schedule); err != nil {
 			return err
-		} else if fi.IsDir() {
-			os.Chdir(p.Dir)
 		}
-	} else {
-		dir, _, err := getExecPath()
-		if err != nil {
-			return err
-		} else {
-			os.Chdir(dir)
+		switch p.Overlap {
+		case "", "skip", "queue", "kill-previous":
+		default:
+			return fmt.Errorf("Unknown Overlap %q, expected skip, queue or kill-previous", p.Overlap)
 		}
+	default:
+		return fmt.Errorf("Unknown Mode %q, expected service, oneshot or scheduled", p.Mode)
+	}
+	switch p.StopBehavior {
+	case "", "kill", "detach":
+	default:
+		return fmt.Errorf("Unknown StopBehavior %q, expected kill or detach", p.StopBehavior)
+	}
+	maxLogs, err := parseSize(p.LogMaxTotalSize)
+	if err != nil {
+		return fmt.Errorf("LogMaxTotalSize: %v", err)
 	}
-	fullExec, err := exec.LookPath(p.Exec)
+	children, err := p.newChildren()
 	if err != nil {
-		return fmt.Errorf("Failed to find executable %q: %v", p.Exec, err)
+		return err
+	}
+	if !interactive() {
+		if changed, err := syncService(p.Config); err != nil {
+			logger.Warningf("Failed to update service display name and description: %v", err)
+		} else if changed {
+			logger.Info("Updated service display name and description")
+		}
+	}
+	for _, c := range children {
+		if err := c.prepare(); err != nil {
+			return fmt.Errorf("%s: %v", c.label(), err)
+		}
+		if c.LogRotateOnStart {
+			c.rotateOnStart()
+		}
+	}
+	p.children = children
+	if maxLogs > 0 {
+		go p.capLogs(maxLogs)
+	}
+	if p.Health != nil {
+		if err := p.serveHealth(); err != nil {
+			return err
+		}
 	}
-	p.cmd = exec.Command(fullExec, p.Args...)
-	p.cmd.Env = append(os.Environ(), p.Env...)
+	if p.Etcd != nil {
+		p.etcd = newEtcdPublisher(p)
+		go p.etcd.run()
+	}
+	go p.serveControl()
 	go p.run()
 	return nil
 }
 
+// setEnvs applies Env to wsw's own environment as well, but for PATH.
 func (p *program) setEnvs() {
 	for _, env := range p.Env {
-		kv := strings.SplitN(env, "=", 2)
-		if len(kv) == 2 {
-			if strings.TrimSpace(strings.ToLower(kv[0])) == "path" {
-				pathEnv := os.ExpandEnv(fmt.Sprintf("%s;$PATH", kv[1]))
-				os.Setenv("PATH", pathEnv)
-			} else {
-				os.Setenv(kv[0], kv[1])
-			}
+		e, ok := parseEnvEntry(env)
+		if !ok {
+			continue
+		}
+		e.name = strings.TrimSpace(e.name)
+		if envKeyEqual(e.name, "PATH") {
+			// Only the child's PATH changes, see childEnv: wsw keeps its own
+			// for the tools it runs itself.
+			continue
+		}
+		if value, set := e.apply(os.Getenv(e.name)); set {
+			os.Setenv(e.name, value)
+			tracef("Set %s", redactEnv([]string{e.name + "=" + value})[0])
+		} else {
+			os.Unsetenv(e.name)
+			tracef("Unset %s", e.name)
 		}
 	}
 }
+
+// run starts every child once the processes it comes after are ready. The
+// service stops as soon as an essential child exits.
 func (p *program) run() {
 	logger.Info("Starting ", p.DisplayName)
-	defer func() {
-		if service.Interactive() {
-			p.Stop(p.service)
-		} else {
-			p.service.Stop()
-		}
-	}()
+	traceEvent(etwInfo, etwStart, "Service %s starting, wrapper PID %d", p.Name, os.Getpid())
+	if p.Mode == "scheduled" {
+		p.schedule()
+		return
+	}
 
-	if p.Stderr != "" {
-		f, err := os.OpenFile(p.Stderr, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0777)
-		if err != nil {
-			logger.Warningf("Failed to open std err %q: %v", p.Stderr, err)
-			return
+	exited := make(chan *child, len(p.children))
+	for _, c := range p.children {
+		if len(c.Watch) != 0 {
+			go c.watchFiles()
 		}
-		defer f.Close()
-		p.cmd.Stderr = f
+		go func(c *child) {
+			if c.waitAfter(p.children) {
+				c.supervise()
+			}
+			if c.essential() {
+				exited <- c
+			}
+		}(c)
 	}
-	if p.Stdout != "" {
-		f, err := os.OpenFile(p.Stdout, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0777)
-		if err != nil {
-			logger.Warningf("Failed to open std out %q: %v", p.Stdout, err)
-			return
-		}
-		defer f.Close()
-		p.cmd.Stdout = f
+	var c *child
+	select {
+	case c = <-exited:
+	case <-p.exit:
+		return
 	}
-	err := p.cmd.Run()
-	if err != nil {
-		logger.Warningf("Error running: %v", err)
+	p.exitCode = c.exitStatus()
+	switch {
+	case p.Mode == "oneshot" && p.exitCode == 0:
+		logger.Info(c.label(), " completed")
+	case p.exitCode != 0:
+		logger.Warningf("%s failed with exit code %d", c.label(), p.exitCode)
 	}
-	return
+	close(p.done)
 }
-func (p *program) Stop(s service.Service) error {
+
+func (p *program) Stop() error {
 	close(p.exit)
 	logger.Info("Stopping ", p.DisplayName)
-	if service.Interactive() {
-		os.Exit(0)
+	traceEvent(etwInfo, etwStop, "Service %s stopping", p.Name)
+	if p.StopBehavior == "detach" {
+		logger.Info("Leaving ", p.DisplayName, " running")
 	} else {
-		p.cmd.Process.Kill()
+		// Leave service discovery before going away.
+		for _, c := range p.children {
+			c.deregisterConsul()
+		}
+		if p.etcd != nil {
+			p.etcd.revoke()
+		}
+		p.drain()
+		// Stop sidecars, then the rest in reverse start order, dependents
+		// first.
+		sidecars := p.sidecarSet()
+		for _, c := range p.children {
+			if sidecars[c] {
+				c.stop()
+			}
+		}
+		for i := len(p.children) - 1; i >= 0; i-- {
+			if !sidecars[p.children[i]] {
+				p.children[i].stop()
+			}
+		}
+		if initProcess && p.exitCode == 0 && len(p.children) != 0 {
+			// A container reports how its main process ended.
+			p.exitCode = p.children[0].exitCode
+		}
+	}
+	if interactive() {
+		os.Exit(osExitCode(p.exitCode))
 	}
 	return nil
 }
@@ -146,45 +338,96 @@ func getConfigPath() (string, error) {
 	return filepath.Join(dir, name+".json"), nil
 }
 
-func getConfig() (*Config, error) {
+// fetchingActions run, start or install the service, and so fetch
+// ConfigURL; the other actions use the cached copy.
+var fetchingActions = map[string]bool{
+	"": true, "run": true, "agent": true, "exec": true,
+	"install": true, "start": true, "restart": true, "sync": true,
+}
+
+// getConfig loads the config and lays the remote config over it, fetched
+// when fetch is set and otherwise as last cached.
+func getConfig(fetch bool) (*Config, error) {
+	conf, err := loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	// The remote config is fetched through the local config's proxy.
+	setProxy(conf)
+	if conf, err = applyRemoteConfig(conf, fetch); err != nil {
+		return nil, err
+	}
+	setProxy(conf)
+	return conf, nil
+}
+
+func loadConfig() (*Config, error) {
+	if data, err := getEmbeddedConfig(); err != nil {
+		return nil, err
+	} else if data != nil {
+		configSource = "the config embedded in the executable"
+		conf := &Config{}
+		if err := unmarshalConfig(data, conf); err != nil {
+			return nil, err
+		}
+		return conf, nil
+	}
 	configPath, err := getConfigPath()
 	if err != nil {
 		return nil, err
 	}
 	f, err := os.Open(configPath)
 	if err != nil {
-		_, execname, err := getExecPath()
-		key, err := registry.OpenKey(registry.LOCAL_MACHINE, fmt.Sprintf("SOFTWARE\\%s", execname), registry.READ)
-		if err == nil {
-			defer key.Close()
-			data, _, err := key.GetBinaryValue("config")
-			if err == nil {
-				conf := &Config{}
-				err := json.Unmarshal(data, &conf)
-				if err != nil {
-					return nil, err
-				}
-				return conf, nil
+		if data, err := readPortableConfig(); err != nil {
+			return nil, err
+		} else if data != nil {
+			configSource = "the portable config.json, " + configPath + " not found"
+			conf := &Config{}
+			if err := unmarshalConfig(data, conf); err != nil {
+				return nil, err
+			}
+			return conf, nil
+		}
+		if data, rootPath, err := readRootConfig(); err != nil {
+			return nil, err
+		} else if data != nil {
+			configSource = rootPath + ", " + configPath + " not found"
+			conf := &Config{}
+			if err := unmarshalConfig(data, conf); err != nil {
+				return nil, err
 			}
+			return conf, nil
+		}
+		// A portable wsw never saved its config outside its directory.
+		if isPortableExe() {
+			return nil, fmt.Errorf("No config found, %s and %s are missing", configPath, filepath.Join(portableDir, "config.json"))
+		}
+		data, err := readSavedConfig()
+		if err != nil {
 			return nil, err
 		}
-		return nil, err
+		configSource = savedConfigName + ", " + configPath + " not found"
+		conf := &Config{}
+		if err := unmarshalConfig(data, conf); err != nil {
+			return nil, err
+		}
+		return conf, nil
 	}
 	defer f.Close()
+	configSource = configPath
 	conf := &Config{}
 	data, err := ioutil.ReadAll(f)
 	if err != nil {
 		return nil, err
 	}
-	json.Unmarshal(data, &conf)
-	if err != nil {
+	if err := unmarshalConfig(data, conf); err != nil {
 		return nil, err
 	}
 	return conf, nil
 }
 
 func initConfig() {
-	config := &Config{Name: "srv", DisplayName: "srv", Description: "Service", Exec: "main.exe"}
+	config := &Config{Name: "srv", DisplayName: "srv", Description: "Service", Process: Process{Exec: "main.exe"}}
 	data, err := json.Marshal(&config)
 	if err == nil {
 		cfp, err := getConfigPath()
@@ -195,85 +438,286 @@ func initConfig() {
 }
 
 func createConfig(config *Config) {
-	_, execname, err := getExecPath()
-	if err == nil {
-		key, _, err := registry.CreateKey(registry.LOCAL_MACHINE, fmt.Sprintf("SOFTWARE\\%s", execname), registry.ALL_ACCESS)
+	if config.Portable {
+		data, err := json.Marshal(&config)
 		if err == nil {
-			defer key.Close()
-			data, err := json.Marshal(&config)
-			if err == nil {
-				if err == nil {
-					key.SetBinaryValue("config", data)
-				}
-			}
+			err = writePortableConfig(data)
 		}
+		if err != nil {
+			warnf("Failed to save portable config: %v", err)
+		}
+		return
+	}
+	// Best effort: the snapshot only matters once the config file is gone.
+	if data, err := json.Marshal(&config); err == nil {
+		saveConfig(data)
 	}
 }
 
 func printUsage() {
 	fmt.Println("Usage:")
 	fmt.Println("wsw -a init/start/stop/restart/install/uninstall")
+	fmt.Println("wsw -a package [-o output.exe]")
+	fmt.Println("wsw -a config diff")
+	fmt.Println("wsw -a status [--all [--filter name-glob] [--sort name/state/uptime/restarts/memory]]")
+	fmt.Println("wsw -a top")
+	fmt.Println("wsw -a reload")
+	fmt.Println("wsw -a sync")
+	fmt.Println("wsw -a self-update [--url URL] [--sha256 HEX]")
+	fmt.Println("wsw -a deploy <new dir>")
+	fmt.Println("wsw -a upgrade --exec <new executable or folder>")
+	fmt.Println("wsw -a versions")
+	fmt.Println("wsw -a rollback [id]")
+	fmt.Println("wsw -a history")
+	fmt.Println("wsw -a status/history/versions --json for a JSON array of objects, e.g. for ConvertFrom-Json")
+	fmt.Println("wsw -a start-all/stop-all/restart-all [--filter name-glob]")
+	fmt.Println("wsw -a doctor")
+	fmt.Println("wsw -a clone <name> [--port N] [--dir path] to copy and install the service under a new name")
+	fmt.Println("wsw -a check [-w restarts] [-c restarts] for Nagios/NRPE")
+	fmt.Println("wsw -a cluster-register to make the installed service a Failover Cluster resource")
+	fmt.Println("wsw -a encrypt [value] to encrypt a config value, read from stdin if not given")
+	fmt.Println("wsw -a attach [--process name] [--stdin] to watch a running process's output live, --stdin also typing to it")
+	fmt.Println("wsw -a send [--process name] [line] [--file path] to write to a running process's stdin pipe, from stdin if neither given")
+	fmt.Println("wsw -a exec [process] to run a process once in the console, with the environment wsw builds")
+	fmt.Println("wsw -a run [--init] to run in the foreground, --init as a container entrypoint")
+	fmt.Println("wsw -a agent to run in the foreground without a service, managed by status/stop/restart")
+	fmt.Println("wsw -a install/uninstall/start/stop/restart --as-task [--at logon/startup]")
+	fmt.Println("wsw -a restart [--timeout 30s] [--force], exiting 0 if stopped gracefully, 2 if killed, 1 on failure")
+	fmt.Println("wsw -v (or --trace) to trace every step of starting the service")
+	fmt.Println("wsw -q (or --quiet) to print only warnings and errors, for scripts")
 }
 
 func main() {
 	svcAction := flag.String("a", "", "Control the system service.")
+	outPath := flag.String("o", "", "Output path for the package action.")
+	filter := flag.String("filter", "", "Service name glob for the -all actions.")
+	updateURL := flag.String("url", "", "Download URL for self-update.")
+	updateSum := flag.String("sha256", "", "Expected SHA-256 of the self-update download.")
+	upgradeFrom := flag.String("exec", "", "New executable or version folder for upgrade.")
+	flag.BoolVar(&tracing, "v", false, "Trace every step of starting the service.")
+	flag.BoolVar(&tracing, "trace", false, "Same as -v.")
+	flag.BoolVar(&quiet, "q", false, "Print only warnings and errors.")
+	flag.BoolVar(&quiet, "quiet", false, "Same as -q.")
+	flag.BoolVar(&initProcess, "init", false, "Act as the init process of a container for the run action.")
+	flag.BoolVar(&asTask, "as-task", false, "Use a Scheduled Task instead of a service.")
+	flag.StringVar(&taskTrigger, "at", "logon", "When the --as-task task runs: logon or startup.")
+	process := flag.String("process", "", "Process to send to, when several have a stdin pipe.")
+	attachStdin := flag.Bool("stdin", false, "Have attach pass what is typed to the process's stdin pipe.")
+	sendFile := flag.String("file", "", "File whose contents send writes.")
+	flag.BoolVar(&jsonOutput, "json", false, "Print status, history and versions as JSON.")
+	statusAll := flag.Bool("all", false, "Have status show every installed wsw service.")
+	sortBy := flag.String("sort", "name", "Column status --all sorts by: name, state, uptime, restarts or memory.")
+	clonePort := flag.Int("port", 0, "Port of the clone.")
+	cloneDir := flag.String("dir", "", "Dir of the clone.")
+	stopWait := flag.Duration("timeout", 0, "How long restart waits for the service to stop, such as 30s.")
+	force := flag.Bool("force", false, "Kill the service if restart times out.")
+	warnRestarts := flag.Int("w", 1, "Restarts in the last 24 hours making check a warning, 0 for none.")
+	critRestarts := flag.Int("c", 0, "Restarts in the last 24 hours making check critical, 0 for none.")
 	flag.Parse()
+	// Flags may follow the arguments of an action too, as in
+	// "wsw -a clone app2 --port 8081".
+	var args []string
+	for flag.NArg() > 0 {
+		args = append(args, flag.Arg(0))
+		flag.CommandLine.Parse(flag.Args()[1:])
+	}
+	arg := ""
+	if len(args) != 0 {
+		arg = args[0]
+	}
 	if len(*svcAction) != 0 {
 		if *svcAction == "init" {
 			initConfig()
 			return
 		}
 	}
-	config, err := getConfig()
+	switch *svcAction {
+	case "start-all", "stop-all", "restart-all":
+		if err := bulkControl(strings.TrimSuffix(*svcAction, "-all"), *filter); err != nil {
+			fatal(err)
+		}
+		return
+	case "doctor":
+		if err := runDoctor(); err != nil {
+			fatal(err)
+		}
+		return
+	case "encrypt":
+		if err := encryptValue(arg); err != nil {
+			fatal(err)
+		}
+		return
+	case "status":
+		if *statusAll {
+			if err := printFleetStatus(*filter, *sortBy); err != nil {
+				fatal(err)
+			}
+			return
+		}
+	case "clone":
+		if err := cloneService(arg, *clonePort, *cloneDir); err != nil {
+			fatal(err)
+		}
+		return
+	case "check":
+		config, err := getConfig(false)
+		if err != nil {
+			fmt.Printf("%s %v\n", nagiosStates[nagiosUnknown], err)
+			os.Exit(nagiosUnknown)
+		}
+		os.Exit(checkService(config, *warnRestarts, *critRestarts))
+	}
+	config, err := getConfig(fetchingActions[*svcAction])
 	if err != nil {
-		log.Fatal(err)
+		fatal(err)
 	}
-	createConfig(config)
-	svcConfig := &service.Config{
-		Name:        config.Name,
-		DisplayName: config.DisplayName,
-		Description: config.Description,
+	if *svcAction == "package" {
+		if err := packageConfig(config, *outPath); err != nil {
+			fatal(err)
+		}
+		return
 	}
-
-	prg := &program{
-		exit: make(chan struct{}),
-
-		Config: config,
+	if *svcAction == "config" {
+		if arg != "diff" {
+			fatalf("Unknown config command %q", arg)
+		}
+		if err := diffConfig(); err != nil {
+			fatal(err)
+		}
+		return
 	}
-	s, err := service.New(prg, svcConfig)
-	if err != nil {
-		log.Fatal(err)
+	if *svcAction == "self-update" {
+		if err := selfUpdate(config, *updateURL, *updateSum); err != nil {
+			fatal(err)
+		}
+		return
 	}
-	prg.service = s
-
-	errs := make(chan error, 5)
-	logger, err = s.Logger(errs)
-	if err != nil {
-		log.Fatal(err)
+	if *svcAction == "deploy" {
+		if err := deploy(config, arg); err != nil {
+			fatal(err)
+		}
+		return
 	}
-
-	go func() {
-		for {
-			err := <-errs
-			if err != nil {
-				log.Print(err)
+	if *svcAction == "upgrade" {
+		if err := upgrade(config, *upgradeFrom); err != nil {
+			fatal(err)
+		}
+		return
+	}
+	if *svcAction == "versions" {
+		if err := printVersions(config); err != nil {
+			fatal(err)
+		}
+		return
+	}
+	if *svcAction == "rollback" {
+		if err := rollbackVersion(config, arg); err != nil {
+			fatal(err)
+		}
+		return
+	}
+	if *svcAction == "cluster-register" {
+		if err := clusterRegister(config); err != nil {
+			fatal(err)
+		}
+		return
+	}
+	if *svcAction == "history" {
+		if err := printHistory(config); err != nil {
+			fatal(err)
+		}
+		return
+	}
+	if *svcAction == "top" {
+		if err := top(config); err != nil {
+			fatal(err)
+		}
+		return
+	}
+	if *svcAction == "attach" {
+		if err := attach(config, *process, *attachStdin); err != nil {
+			fatal(err)
+		}
+		return
+	}
+	if *svcAction == "send" {
+		if err := sendStdin(config, *process, strings.Join(args, " "), *sendFile); err != nil {
+			fatal(err)
+		}
+		return
+	}
+	if *svcAction == "status" {
+		if err := printStatus(config); err != nil {
+			fatal(err)
+		}
+		return
+	}
+	if *svcAction == "restart" && (*stopWait > 0 || *force) {
+		if asTask || agentRunning(config.Name) {
+			fatal("--timeout and --force only apply to a service")
+		}
+		os.Exit(forceRestart(config, *stopWait, *force))
+	}
+	if agentAction(config.Name, *svcAction) {
+		return
+	}
+	switch *svcAction {
+	case "restart":
+		// Several processes are restarted one at a time by the running
+		// wrapper so the service keeps serving.
+		if len(config.Processes) != 0 || config.Replicas > 1 {
+			err := sendControl(config.Name, os.Stdout, "restart")
+			if err != errNotRunning {
+				if err != nil {
+					fatal(err)
+				}
+				return
 			}
 		}
-	}()
-	handleAction(s, *svcAction)
-}
-
-func handleAction(s service.Service, action string) {
-	if len(action) != 0 {
-		err := service.Control(s, action)
-		if err != nil {
-			log.Printf("Valid actions: %q\n", service.ControlAction)
-			log.Fatal(err)
+	case "reload":
+		if err := sendControl(config.Name, os.Stdout, "reload"); err != nil {
+			fatal(err)
 		}
-	} else {
-		err := s.Run()
+		return
+	}
+	createConfig(config)
+	if config.Portable {
+		if err := config.resolvePortable(); err != nil {
+			fatal(err)
+		}
+	}
+	if *svcAction == "exec" {
+		code, err := execOnce(config, arg)
 		if err != nil {
-			log.Fatal(err)
+			fatal(err)
+		}
+		os.Exit(code)
+	}
+	if *svcAction == "install" || *svcAction == "sync" {
+		if err := promptSecrets(config); err != nil {
+			fatal(err)
+		}
+	}
+	if *svcAction == "sync" {
+		if _, err := syncService(config); err != nil {
+			fatal(err)
+		}
+		if err := configureService(config); err != nil {
+			fatal(err)
+		}
+		return
+	}
+	prg := &program{
+		exit:         make(chan struct{}),
+		done:         make(chan struct{}),
+		stopRequests: make(chan struct{}, 1),
+
+		Config: config,
+	}
+	runAction(prg, *svcAction)
+	if *svcAction == "uninstall" {
+		if err := forgetSecrets(config); err != nil {
+			fatal(err)
 		}
 	}
 }
@@ -0,0 +1,25 @@
+package main
+
+// addNetworkConfig creates the firewall rules, URL reservations,
+// certificate bindings and SPNs of the config, on install and sync.
+func addNetworkConfig(conf *Config) error {
+	if err := addFirewallRules(conf); err != nil {
+		return err
+	}
+	if err := addURLACLs(conf); err != nil {
+		return err
+	}
+	if err := addCertBindings(conf); err != nil {
+		return err
+	}
+	return addSPNs(conf)
+}
+
+// removeNetworkConfig undoes addNetworkConfig on uninstall, before the
+// service is removed as the SPNs belong to its account.
+func removeNetworkConfig(conf *Config) {
+	removeFirewallRules(conf)
+	removeURLACLs(conf)
+	removeCertBindings(conf)
+	removeSPNs(conf)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// quiet, set by -q or --quiet, leaves only warnings and errors on the
+// console, for scripts.
+var quiet bool
+
+// Console colors, as SGR parameters.
+const (
+	colorRed    = "31"
+	colorGreen  = "32"
+	colorYellow = "33"
+	colorCyan   = "36"
+	colorGray   = "90"
+)
+
+// colors tells whether the console takes colors. NO_COLOR turns them off.
+func colors() bool {
+	colorOnce.Do(func() { useColor = os.Getenv("NO_COLOR") == "" && enableColor() })
+	return useColor
+}
+
+// paint wraps s in color when f is a console taking colors.
+func paint(f *os.File, color, s string) string {
+	if color == "" || !colors() || !isTerminal(f) {
+		return s
+	}
+	return "\x1b[" + color + "m" + s + "\x1b[0m"
+}
+
+// emit writes a line of human text to f, its level prefix in color.
+func emit(f *os.File, color, prefix, msg string) {
+	consoleMu.Lock()
+	defer consoleMu.Unlock()
+	f.WriteString(paint(f, color, prefix) + strings.TrimRight(msg, "\r\n") + "\n")
+}
+
+// infof reports progress or a result on stdout, unless --quiet.
+func infof(format string, a ...interface{}) {
+	if quiet {
+		return
+	}
+	emit(os.Stdout, "", "", fmt.Sprintf(format, a...))
+}
+
+// warnf reports on stderr, in yellow, something that went wrong without
+// stopping the action.
+func warnf(format string, a ...interface{}) {
+	emit(os.Stderr, colorYellow, "warning: ", fmt.Sprintf(format, a...))
+}
+
+// errorf reports an error on stderr, in red.
+func errorf(format string, a ...interface{}) {
+	emit(os.Stderr, colorRed, "error: ", fmt.Sprintf(format, a...))
+}
+
+// fatal reports an error, as fmt.Sprint formats a, and exits with 1.
+func fatal(a ...interface{}) {
+	errorf("%s", fmt.Sprint(a...))
+	os.Exit(1)
+}
+
+// fatalf reports an error and exits with 1.
+func fatalf(format string, a ...interface{}) {
+	errorf(format, a...)
+	os.Exit(1)
+}
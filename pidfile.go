@@ -0,0 +1,28 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// writePidFile records the running child's and the wrapper's PIDs in
+// PidFile for scripts looking for the process.
+func (c *child) writePidFile() {
+	path := c.childPath(c.PidFile)
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err == nil {
+		err = ioutil.WriteFile(path, []byte(fmt.Sprintf("%d\r\n%d\r\n", c.proc.Pid, os.Getpid())), 0644)
+	}
+	if err != nil {
+		logger.Warningf("%s: failed to write PID file: %v", c.label(), err)
+	}
+}
+
+// removePidFile removes PidFile once the child exited.
+func (c *child) removePidFile() {
+	if err := os.Remove(c.childPath(c.PidFile)); err != nil && !os.IsNotExist(err) {
+		logger.Warningf("%s: failed to remove PID file: %v", c.label(), err)
+	}
+}
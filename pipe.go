@@ -0,0 +1,23 @@
+package main
+
+import "os"
+
+// servePipe calls handle for each client of the pipe path, one at a time. It
+// only returns if the pipe cannot be created.
+func servePipe(path string, handle func(f *os.File)) error {
+	return acceptPipe(path, func(f *os.File) {
+		handle(f)
+		f.Close()
+	})
+}
+
+// servePipeConcurrently is servePipe for clients staying connected: each is
+// handled as soon as it connects.
+func servePipeConcurrently(path string, handle func(f *os.File)) error {
+	return acceptPipe(path, func(f *os.File) {
+		go func() {
+			defer f.Close()
+			handle(f)
+		}()
+	})
+}
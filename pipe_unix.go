@@ -0,0 +1,74 @@
+//go:build !windows
+
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+func pipePath(name string) string {
+	return filepath.Join(pipeDir, name+".sock")
+}
+
+// acceptPipe listens on the Unix socket path and calls accept with each
+// client, which must close it. It only returns if the socket cannot be
+// created.
+func acceptPipe(path string, accept func(f *os.File)) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	// The socket is bound in a directory only wsw can enter, restricted,
+	// and only then moved into place, so nobody can connect in between.
+	tmp, err := ioutil.TempDir(filepath.Dir(path), ".listen-")
+	if err != nil {
+		return err
+	}
+	bound := filepath.Join(tmp, "sock")
+	l, err := net.ListenUnix("unix", &net.UnixAddr{Name: bound, Net: "unix"})
+	if err == nil {
+		if err = os.Chmod(bound, 0600); err == nil {
+			// Replaces a socket left by a wrapper that crashed.
+			err = os.Rename(bound, path)
+		}
+		if err != nil {
+			l.Close()
+		}
+	}
+	os.RemoveAll(tmp)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+	defer os.Remove(path)
+	for {
+		conn, err := l.AcceptUnix()
+		if err != nil {
+			return err
+		}
+		f, err := conn.File()
+		conn.Close()
+		if err != nil {
+			continue
+		}
+		accept(f)
+	}
+}
+
+// dialPipe connects to the Unix socket path. A missing socket, or one no
+// wrapper listens on anymore, reports as not existing.
+func dialPipe(path string) (*os.File, error) {
+	conn, err := net.DialUnix("unix", nil, &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		if errors.Is(err, syscall.ENOENT) || errors.Is(err, syscall.ECONNREFUSED) {
+			return nil, &os.PathError{Op: "dial", Path: path, Err: syscall.ENOENT}
+		}
+		return nil, err
+	}
+	defer conn.Close()
+	return conn.File()
+}
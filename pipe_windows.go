@@ -0,0 +1,83 @@
+package main
+
+import (
+	"os"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+func pipePath(name string) string {
+	return `\\.\pipe\wsw-` + name
+}
+
+// pipeSecurity limits the pipe to SYSTEM, Administrators and the account wsw
+// runs as, rather than the default DACL.
+func pipeSecurity() (*windows.SecurityAttributes, error) {
+	user, err := windows.GetCurrentProcessToken().GetTokenUser()
+	if err != nil {
+		return nil, err
+	}
+	sd, err := windows.SecurityDescriptorFromString("D:P(A;;GA;;;SY)(A;;GA;;;BA)(A;;GA;;;" + user.User.Sid.String() + ")")
+	if err != nil {
+		return nil, err
+	}
+	sa := &windows.SecurityAttributes{SecurityDescriptor: sd}
+	sa.Length = uint32(unsafe.Sizeof(*sa))
+	return sa, nil
+}
+
+// acceptPipe listens on the named pipe path and calls accept with each
+// client, which must close it. It only returns if the pipe cannot be
+// created, including when another process already owns the name.
+func acceptPipe(path string, accept func(f *os.File)) error {
+	name, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return err
+	}
+	sa, err := pipeSecurity()
+	if err != nil {
+		return err
+	}
+	create := func(flags uint32) (windows.Handle, error) {
+		return windows.CreateNamedPipe(name, windows.PIPE_ACCESS_DUPLEX|flags,
+			windows.PIPE_TYPE_BYTE|windows.PIPE_READMODE_BYTE|windows.PIPE_WAIT|windows.PIPE_REJECT_REMOTE_CLIENTS,
+			windows.PIPE_UNLIMITED_INSTANCES, 4096, 4096, 0, sa)
+	}
+	h, err := create(windows.FILE_FLAG_FIRST_PIPE_INSTANCE)
+	if err != nil {
+		return err
+	}
+	for {
+		err := windows.ConnectNamedPipe(h, nil)
+		// The next instance is there before this one goes, so the name is
+		// never left free for another process to take.
+		next, nerr := create(0)
+		if nerr != nil {
+			windows.CloseHandle(h)
+			return nerr
+		}
+		if err != nil && err != windows.ERROR_PIPE_CONNECTED {
+			windows.CloseHandle(h)
+		} else {
+			accept(os.NewFile(uintptr(h), path))
+		}
+		h = next
+	}
+}
+
+// dialPipe connects to the named pipe path, waiting while the server is busy
+// with another client.
+func dialPipe(path string) (*os.File, error) {
+	for i := 0; ; i++ {
+		f, err := os.OpenFile(path, os.O_RDWR, 0)
+		if err == nil || i == 50 {
+			return f, err
+		}
+		if perr, ok := err.(*os.PathError); !ok || perr.Err != windows.ERROR_PIPE_BUSY {
+			return nil, err
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// portableDir is the folder next to wsw holding state in portable mode.
+const portableDir = ".wsw"
+
+func getPortableDir() (string, error) {
+	dir, _, err := getExecPath()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, portableDir), nil
+}
+
+// readPortableConfig returns the config snapshot kept in the portable state
+// folder, or nil when there is none.
+func readPortableConfig() ([]byte, error) {
+	dir, err := getPortableDir()
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, "config.json"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return data, err
+}
+
+// isPortableExe tells whether wsw runs in portable mode, having left its
+// .wsw folder next to the executable.
+func isPortableExe() bool {
+	dir, err := getPortableDir()
+	if err != nil {
+		return false
+	}
+	fi, err := os.Stat(dir)
+	return err == nil && fi.IsDir()
+}
+
+func writePortableConfig(data []byte) error {
+	dir, err := getPortableDir()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, "config.json"), data, 0644)
+}
+
+// resolvePortable rewrites the relative paths of a portable config against
+// the directory of the wsw executable, so the result does not depend on the
+// current directory or drive letter.
+func (c *Config) resolvePortable() error {
+	dir, _, err := getExecPath()
+	if err != nil {
+		return err
+	}
+	resolve := func(p string) string {
+		if p == "" || filepath.IsAbs(p) {
+			return p
+		}
+		return filepath.Join(dir, p)
+	}
+	procs := []*Process{&c.Process}
+	for i := range c.Processes {
+		procs = append(procs, &c.Processes[i])
+	}
+	for _, proc := range procs {
+		proc.Dir = resolve(proc.Dir)
+		proc.Stdout = resolve(proc.Stdout)
+		proc.Stderr = resolve(proc.Stderr)
+		if proc.Stdin != "pipe" && !strings.HasPrefix(proc.Stdin, "text:") {
+			proc.Stdin = resolve(proc.Stdin)
+		}
+		// A bare exec name still goes through PATH unless it sits next to wsw.
+		if strings.ContainsAny(proc.Exec, `\/`) {
+			proc.Exec = resolve(proc.Exec)
+		} else if _, err := os.Stat(resolve(proc.Exec)); err == nil && proc.Exec != "" {
+			proc.Exec = resolve(proc.Exec)
+		}
+	}
+	return nil
+}
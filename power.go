@@ -0,0 +1,22 @@
+package main
+
+// sleep stops the children marked StopOnSuspend, dependents first, before
+// the machine goes to sleep.
+func (p *program) sleep() {
+	for i := len(p.children) - 1; i >= 0; i-- {
+		if c := p.children[i]; c.StopOnSuspend {
+			logger.Info("Stopping ", c.label(), " for standby")
+			c.holdStopped()
+		}
+	}
+}
+
+// wake starts the children stopped by sleep again.
+func (p *program) wake() {
+	for _, c := range p.children {
+		if c.StopOnSuspend && c.held() != nil {
+			logger.Info("Starting ", c.label(), " after resume")
+			c.release()
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Probe checks whether a process is ready. With neither TCP nor HTTP set the
+// process is ready once Delay has passed.
+type Probe struct {
+	// TCP is a host:port that must accept connections.
+	TCP string
+	// HTTP is a URL that must answer with a status below 400.
+	HTTP string
+
+	// Delay is how many seconds to wait before the first check, Interval
+	// between checks (default 1) and Timeout until the process is considered
+	// failed (default 60, -1 waits forever).
+	Delay, Interval, Timeout int
+}
+
+func (pr *Probe) check() error {
+	timeout := pr.interval()
+	if pr.TCP != "" {
+		conn, err := net.DialTimeout("tcp", pr.TCP, timeout)
+		if err != nil {
+			return err
+		}
+		conn.Close()
+	}
+	if pr.HTTP != "" {
+		client := &http.Client{Timeout: timeout}
+		resp, err := client.Get(pr.HTTP)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 400 {
+			return fmt.Errorf("%s answered %s", pr.HTTP, resp.Status)
+		}
+	}
+	return nil
+}
+
+func (pr *Probe) interval() time.Duration {
+	if pr.Interval > 0 {
+		return time.Duration(pr.Interval) * time.Second
+	}
+	return time.Second
+}
+
+func (pr *Probe) timeout() time.Duration {
+	switch {
+	case pr.Timeout < 0:
+		return 0
+	case pr.Timeout == 0:
+		return 60 * time.Second
+	}
+	return time.Duration(pr.Timeout) * time.Second
+}
+
+// state is "ready" once the current run passed its Ready probe, "exited"
+// once it ended and "starting" before.
+func (c *child) state() string {
+	ready, exited := c.current()
+	select {
+	case <-exited:
+		return "exited"
+	case <-ready:
+		return "ready"
+	default:
+		return "starting"
+	}
+}
+
+func (c *child) setReady(ready, exited chan struct{}) {
+	close(ready)
+	go c.registerConsul()
+	if c.process().Rollback {
+		go c.keepKnownGood(exited)
+	}
+}
+
+// awaitReady runs the Ready probe until it passes and then closes ready.
+// A child that does not get ready in time is stopped.
+func (c *child) awaitReady(ready, exited chan struct{}) {
+	pr := c.process().Ready
+	if pr == nil {
+		c.setReady(ready, exited)
+		return
+	}
+	var deadline <-chan time.Time
+	if t := pr.timeout(); t > 0 {
+		deadline = time.After(t)
+	}
+	wait := time.Duration(pr.Delay) * time.Second
+	var err error
+	for {
+		select {
+		case <-exited:
+			return
+		case <-deadline:
+			logger.Warningf("%s not ready after %v: %v", c.label(), pr.timeout(), err)
+			traceEvent(etwWarning, etwProbe, "Process %s not ready after %v: %v", c.label(), pr.timeout(), err)
+			c.setFailure(exitNotReady)
+			c.stop()
+			return
+		case <-time.After(wait):
+		}
+		if err = pr.check(); err == nil {
+			logger.Info(c.label(), " is ready")
+			c.setReady(ready, exited)
+			return
+		}
+		wait = pr.interval()
+	}
+}
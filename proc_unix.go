@@ -0,0 +1,94 @@
+//go:build !windows
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// newSysProcAttr returns the process attributes every child starts with: its
+// own process group, so the whole tree can be signalled together.
+func newSysProcAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{Setpgid: true}
+}
+
+// setRawArgs makes cmd run through /bin/sh with args appended verbatim to
+// the quoted executable path and arguments.
+func setRawArgs(cmd *exec.Cmd, args string) {
+	quoted := []string{shellQuote(cmd.Path)}
+	for _, a := range cmd.Args[1:] {
+		quoted = append(quoted, shellQuote(a))
+	}
+	cmd.Path = "/bin/sh"
+	cmd.Args = []string{"/bin/sh", "-c", "exec " + strings.Join(quoted, " ") + " " + args}
+}
+
+// rawCmdLine returns nil: setRawArgs puts the arguments into cmd.Args.
+func rawCmdLine(cmd *exec.Cmd) *string {
+	return nil
+}
+
+// shellQuote quotes s for /bin/sh.
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// setShell rewrites cmd, whose Path is a script resolved from Exec, to run
+// the script through the given interpreter: sh, bash or pwsh. raw, if set,
+// is appended verbatim in place of the arguments.
+func setShell(cmd *exec.Cmd, shell, raw string) error {
+	script, args := cmd.Path, cmd.Args[1:]
+	var prefix []string
+	switch strings.ToLower(shell) {
+	case "sh", "bash":
+	case "pwsh":
+		prefix = []string{"-NoLogo", "-NoProfile", "-NonInteractive", "-File"}
+	default:
+		return fmt.Errorf("Unknown shell %q, expected sh, bash or pwsh", shell)
+	}
+	full, err := exec.LookPath(strings.ToLower(shell))
+	if err != nil {
+		return fmt.Errorf("Failed to find shell %q: %v", shell, err)
+	}
+	cmd.Path = full
+	cmd.Args = append(append(append([]string{full}, prefix...), script), args...)
+	if raw != "" {
+		setRawArgs(cmd, raw)
+	}
+	return nil
+}
+
+// spawnOptions are the CreateProcess features of Windows; there are none
+// here.
+type spawnOptions struct{}
+
+// spawn fails: it only backs Windows launch options.
+func spawn(cmd *exec.Cmd, opts spawnOptions) (*os.Process, error) {
+	return nil, errors.New("Not supported on this platform")
+}
+
+// waitProcess returns a wait function for a process not started by this
+// wrapper, such as an adopted one. It cannot be reaped, so it is polled and
+// its exit code is unknown.
+func waitProcess(proc *os.Process) func() error {
+	return func() error {
+		for {
+			if err := proc.Signal(syscall.Signal(0)); err != nil {
+				return nil
+			}
+			time.Sleep(time.Second)
+		}
+	}
+}
+
+// inForeground keeps cmd in wsw's process group, so it gets the terminal
+// and Ctrl+C.
+func inForeground(cmd *exec.Cmd) {
+	cmd.SysProcAttr.Setpgid = false
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// newSysProcAttr returns the process attributes every child starts with.
+func newSysProcAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{}
+}
+
+// setRawArgs makes cmd start with args appended verbatim to the quoted
+// executable path, bypassing Go's argument escaping.
+func setRawArgs(cmd *exec.Cmd, args string) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.CmdLine = syscall.EscapeArg(cmd.Path) + " " + args
+}
+
+// rawCmdLine returns the command line set by setRawArgs, if any.
+func rawCmdLine(cmd *exec.Cmd) *string {
+	if cmd.SysProcAttr == nil || cmd.SysProcAttr.CmdLine == "" {
+		return nil
+	}
+	return &cmd.SysProcAttr.CmdLine
+}
+
+// setShell rewrites cmd, whose Path is a script resolved from Exec, to run
+// the script through the given interpreter: cmd, powershell or pwsh. raw, if
+// set, is appended verbatim in place of the arguments.
+func setShell(cmd *exec.Cmd, shell, raw string) error {
+	script, args := cmd.Path, cmd.Args[1:]
+	var interp string
+	var prefix []string
+	switch strings.ToLower(shell) {
+	case "cmd":
+		interp = os.Getenv("ComSpec")
+		prefix = []string{"/D", "/S", "/C"}
+	case "powershell", "pwsh":
+		interp = strings.ToLower(shell) + ".exe"
+		prefix = []string{"-NoLogo", "-NoProfile", "-NonInteractive", "-ExecutionPolicy", "Bypass", "-File"}
+	default:
+		return fmt.Errorf("Unknown shell %q, expected cmd, powershell or pwsh", shell)
+	}
+	if interp == "" {
+		interp = "cmd.exe"
+	}
+	full, err := exec.LookPath(interp)
+	if err != nil {
+		return fmt.Errorf("Failed to find shell %q: %v", interp, err)
+	}
+	cmd.Path = full
+	cmd.Args = append(append(append([]string{full}, prefix...), script), args...)
+
+	if raw == "" {
+		quoted := make([]string, len(args))
+		for i, a := range args {
+			quoted[i] = syscall.EscapeArg(a)
+		}
+		raw = strings.Join(quoted, " ")
+	}
+	line := syscall.EscapeArg(full) + " " + strings.Join(prefix, " ") + " "
+	if prefix[0] == "/D" {
+		// With /S cmd strips the outer quotes and runs the rest as typed.
+		line += `""` + script + `" ` + raw + `"`
+	} else {
+		line += syscall.EscapeArg(script) + " " + raw
+	}
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.CmdLine = line
+	return nil
+}
+
+// spawnOptions are the CreateProcess features exec.Cmd does not expose.
+type spawnOptions struct {
+	// pseudoConsole attaches the child to a ConPTY instead of std handles.
+	pseudoConsole windows.Handle
+	// token starts the child as another user via CreateProcessAsUser.
+	token windows.Token
+	// desktop is the window station and desktop the child shows up on.
+	desktop string
+}
+
+// spawn starts cmd with CreateProcess(AsUser) using opts. Unless a pseudo
+// console is used, Stdin, Stdout and Stderr must be *os.File or nil.
+func spawn(cmd *exec.Cmd, opts spawnOptions) (*os.Process, error) {
+	attrs, err := windows.NewProcThreadAttributeList(2)
+	if err != nil {
+		return nil, err
+	}
+	defer attrs.Delete()
+	si := &windows.StartupInfoEx{ProcThreadAttributeList: attrs.List()}
+	si.Cb = uint32(unsafe.Sizeof(*si))
+	// Without explicit std handles the child may inherit ours instead of
+	// attaching to the pseudo console.
+	si.Flags = windows.STARTF_USESTDHANDLES
+	if opts.desktop != "" {
+		if si.Desktop, err = windows.UTF16PtrFromString(opts.desktop); err != nil {
+			return nil, err
+		}
+	}
+
+	var inherit []windows.Handle
+	if opts.pseudoConsole != 0 {
+		hpc := opts.pseudoConsole
+		// The attribute value is the console handle itself, not a pointer to it.
+		if err := attrs.Update(procThreadAttributePseudoConsole, *(*unsafe.Pointer)(unsafe.Pointer(&hpc)), unsafe.Sizeof(hpc)); err != nil {
+			return nil, err
+		}
+	} else {
+		self, _ := windows.GetCurrentProcess()
+		for i, std := range []interface{}{cmd.Stdin, cmd.Stdout, cmd.Stderr} {
+			f, ok := std.(*os.File)
+			if !ok || f == nil {
+				continue
+			}
+			var h windows.Handle
+			err := windows.DuplicateHandle(self, windows.Handle(f.Fd()), self, &h, 0, true, windows.DUPLICATE_SAME_ACCESS)
+			if err != nil {
+				return nil, err
+			}
+			defer windows.CloseHandle(h)
+			inherit = append(inherit, h)
+			switch i {
+			case 0:
+				si.StdInput = h
+			case 1:
+				si.StdOutput = h
+			case 2:
+				si.StdErr = h
+			}
+		}
+		if len(inherit) != 0 {
+			// Only the std handles are inherited, not every inheritable handle.
+			err := attrs.Update(windows.PROC_THREAD_ATTRIBUTE_HANDLE_LIST, unsafe.Pointer(&inherit[0]), uintptr(len(inherit))*unsafe.Sizeof(inherit[0]))
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	line := windows.ComposeCommandLine(cmd.Args)
+	if cmd.SysProcAttr != nil && cmd.SysProcAttr.CmdLine != "" {
+		line = cmd.SysProcAttr.CmdLine
+	}
+	app, err := windows.UTF16PtrFromString(cmd.Path)
+	if err != nil {
+		return nil, err
+	}
+	cmdLine, err := windows.UTF16PtrFromString(line)
+	if err != nil {
+		return nil, err
+	}
+	var dir *uint16
+	if cmd.Dir != "" {
+		if dir, err = windows.UTF16PtrFromString(cmd.Dir); err != nil {
+			return nil, err
+		}
+	}
+	env := os.Environ()
+	if cmd.Env != nil {
+		env = cmd.Env
+	}
+	block, err := windows.UTF16FromString(strings.Join(env, "\x00") + "\x00")
+	if err != nil {
+		return nil, err
+	}
+
+	flags := uint32(windows.EXTENDED_STARTUPINFO_PRESENT | windows.CREATE_UNICODE_ENVIRONMENT)
+	pi := &windows.ProcessInformation{}
+	if opts.token != 0 {
+		err = windows.CreateProcessAsUser(opts.token, app, cmdLine, nil, nil, len(inherit) != 0, flags, &block[0], dir, &si.StartupInfo, pi)
+	} else {
+		err = windows.CreateProcess(app, cmdLine, nil, nil, len(inherit) != 0, flags, &block[0], dir, &si.StartupInfo, pi)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer windows.CloseHandle(pi.Thread)
+	defer windows.CloseHandle(pi.Process)
+	return os.FindProcess(int(pi.ProcessId))
+}
+
+// waitProcess returns a wait function for a process not started by exec.Cmd.
+func waitProcess(proc *os.Process) func() error {
+	return func() error {
+		state, err := proc.Wait()
+		if err == nil && !state.Success() {
+			err = &exec.ExitError{ProcessState: state}
+		}
+		return err
+	}
+}
+
+// inForeground does nothing: the child shares wsw's console already.
+func inForeground(cmd *exec.Cmd) {}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// A config value of {"prompt":true,"name":"<name>"}, read as
+// secret://prompt/<name>, is asked for when the service is installed and
+// stored in its state directory rather than in the config: encrypted with
+// DPAPI for the machine on Windows, in a file only root can read elsewhere.
+
+// promptName matches the name of a prompted value.
+var promptName = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// stdinReader reads the lines typed for prompts, sharing what it buffers
+// between them.
+var stdinReader = bufio.NewReader(os.Stdin)
+
+// readSecret shows prompt and reads a line from stdin without echoing it
+// when stdin is a terminal.
+func readSecret(prompt string) (string, error) {
+	fmt.Fprint(os.Stderr, prompt)
+	restore := echoOff()
+	line, err := stdinReader.ReadString('\n')
+	if restore != nil {
+		restore()
+		fmt.Fprintln(os.Stderr)
+	}
+	if err != nil && line == "" {
+		return "", fmt.Errorf("Failed to read the value: %v", err)
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func storedSecretsPath(config *Config) (string, error) {
+	dir, err := getStateDir(config)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "secrets.json"), nil
+}
+
+// readStoredSecrets returns the sealed values by name.
+func readStoredSecrets(config *Config) (map[string]string, error) {
+	path, err := storedSecretsPath(config)
+	if err != nil {
+		return nil, err
+	}
+	stored := map[string]string{}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return stored, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return stored, json.Unmarshal(data, &stored)
+}
+
+// promptSecrets asks for the prompted values of config not stored yet and
+// stores them.
+func promptSecrets(config *Config) error {
+	data, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+	stored, err := readStoredSecrets(config)
+	if err != nil {
+		return fmt.Errorf("Failed to read stored secrets: %v", err)
+	}
+	var names []string
+	for _, m := range secretRef.FindAllStringSubmatch(string(data), -1) {
+		if m[1] == "prompt" && stored[m[2]] == "" {
+			stored[m[2]] = "-"
+			names = append(names, m[2])
+		}
+	}
+	if len(names) == 0 {
+		return nil
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		value, err := readSecret(fmt.Sprintf("%s for %s: ", name, config.Name))
+		if err != nil {
+			return err
+		}
+		sealed, err := seal([]byte(value))
+		if err != nil {
+			return fmt.Errorf("Failed to encrypt %s: %v", name, err)
+		}
+		stored[name] = base64.StdEncoding.EncodeToString(sealed)
+	}
+	path, err := storedSecretsPath(config)
+	if err != nil {
+		return err
+	}
+	if data, err = json.Marshal(stored); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		return fmt.Errorf("Failed to store secrets: %v", err)
+	}
+	return nil
+}
+
+// forgetSecrets removes the stored values of an uninstalled service.
+func forgetSecrets(config *Config) error {
+	path, err := storedSecretsPath(config)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// promptSecret returns a value stored on install.
+func promptSecret(r *secretResolver, key string) (string, error) {
+	stored, err := readStoredSecrets(r.conf)
+	if err != nil {
+		return "", err
+	}
+	if stored[key] == "" {
+		return "", fmt.Errorf("No value was stored for %s, install the service again to enter it", key)
+	}
+	data, err := base64.StdEncoding.DecodeString(stored[key])
+	if err != nil {
+		return "", fmt.Errorf("Invalid stored value: %v", err)
+	}
+	plain, err := unseal(data)
+	if err != nil {
+		return "", fmt.Errorf("Failed to decrypt: %v", err)
+	}
+	return string(plain), nil
+}
@@ -0,0 +1,32 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"os/exec"
+)
+
+// echoOff stops the terminal on stdin from echoing, returning how to undo it,
+// or nil if stdin is not a terminal.
+func echoOff() func() {
+	stty := func(arg string) error {
+		cmd := exec.Command("stty", arg)
+		cmd.Stdin = os.Stdin
+		return cmd.Run()
+	}
+	if stty("-echo") != nil {
+		return nil
+	}
+	return func() { stty("echo") }
+}
+
+// seal leaves stored values as they are, the file holding them being only
+// readable by root.
+func seal(data []byte) ([]byte, error) {
+	return data, nil
+}
+
+func unseal(data []byte) ([]byte, error) {
+	return data, nil
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// echoOff stops the console on stdin from echoing, returning how to undo it,
+// or nil if stdin is not a console.
+func echoOff() func() {
+	h := windows.Handle(os.Stdin.Fd())
+	var mode uint32
+	if windows.GetConsoleMode(h, &mode) != nil {
+		return nil
+	}
+	if windows.SetConsoleMode(h, mode&^windows.ENABLE_ECHO_INPUT) != nil {
+		return nil
+	}
+	return func() { windows.SetConsoleMode(h, mode) }
+}
+
+// seal encrypts stored values with DPAPI for the machine. The Credential
+// Manager would keep them for the installing user only, out of reach of
+// the account the service runs as.
+func seal(data []byte) ([]byte, error) {
+	return protect(data)
+}
+
+func unseal(data []byte) ([]byte, error) {
+	return unprotect(data)
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Proxy is the proxy wsw's own connections go through: downloads,
+// self-update, remote config, secret providers, service registries and log
+// forwarding. Without one the usual HTTPS_PROXY, HTTP_PROXY and NO_PROXY
+// variables apply.
+type Proxy struct {
+	// URL of the proxy, such as http://proxy.corp:3128.
+	URL string
+	// NoProxy lists hosts, domains (matching their subdomains as well), IP
+	// addresses and CIDR ranges reached directly, or "*" for all. Loopback
+	// and link-local addresses always are.
+	NoProxy []string
+	// Username and Password log in to the proxy with basic authentication.
+	// Password may be a secret:// reference.
+	Username, Password string
+}
+
+var (
+	proxyMu sync.Mutex
+	// proxyConf is the config whose Proxy is used, and proxyUser the
+	// credentials, once resolved.
+	proxyConf *Config
+	proxyUser *url.Userinfo
+	// proxyResolving is set while the password is being resolved. The
+	// requests of that secret provider go without credentials.
+	proxyResolving bool
+)
+
+func newProxyTransport() *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.Proxy = proxyFor
+	return t
+}
+
+// setProxy makes conf's Proxy the one used from now on.
+func setProxy(conf *Config) {
+	proxyMu.Lock()
+	defer proxyMu.Unlock()
+	proxyConf, proxyUser = conf, nil
+}
+
+// proxyFor returns the proxy for a request, nil to connect directly.
+func proxyFor(req *http.Request) (*url.URL, error) {
+	proxyMu.Lock()
+	conf := proxyConf
+	proxyMu.Unlock()
+	if conf == nil || conf.Proxy == nil || conf.Proxy.URL == "" {
+		return http.ProxyFromEnvironment(req)
+	}
+	pr := conf.Proxy
+	if pr.bypass(req.URL.Hostname()) {
+		return nil, nil
+	}
+	u, err := url.Parse(pr.URL)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("Invalid Proxy URL %q", pr.URL)
+	}
+	if pr.Username != "" {
+		if u.User, err = proxyCredentials(conf); err != nil {
+			return nil, err
+		}
+	}
+	return u, nil
+}
+
+func proxyCredentials(conf *Config) (*url.Userinfo, error) {
+	proxyMu.Lock()
+	if proxyUser != nil || proxyResolving {
+		defer proxyMu.Unlock()
+		return proxyUser, nil
+	}
+	proxyResolving = true
+	proxyMu.Unlock()
+	password, err := newSecretResolver(conf).resolve(conf.Proxy.Password)
+	proxyMu.Lock()
+	defer proxyMu.Unlock()
+	proxyResolving = false
+	if err != nil {
+		return nil, fmt.Errorf("Proxy password: %v", err)
+	}
+	if proxyConf == conf {
+		proxyUser = url.UserPassword(conf.Proxy.Username, password)
+	}
+	return url.UserPassword(conf.Proxy.Username, password), nil
+}
+
+// bypass tells whether host is reached directly.
+func (pr *Proxy) bypass(host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	ip := net.ParseIP(host)
+	if host == "localhost" || ip != nil && (ip.IsLoopback() || ip.IsLinkLocalUnicast()) {
+		return true
+	}
+	for _, entry := range pr.NoProxy {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry == "*" {
+			return true
+		}
+		if _, cidr, err := net.ParseCIDR(entry); err == nil {
+			if ip != nil && cidr.Contains(ip) {
+				return true
+			}
+			continue
+		}
+		if entryIP := net.ParseIP(entry); entryIP != nil {
+			if ip != nil && entryIP.Equal(ip) {
+				return true
+			}
+			continue
+		}
+		domain := strings.TrimPrefix(entry, ".")
+		if domain != "" && (host == domain || strings.HasSuffix(host, "."+domain)) {
+			return true
+		}
+	}
+	return false
+}
+
+// dialTCP connects to address, through the proxy with CONNECT when there
+// is one for it.
+func dialTCP(address string, timeout time.Duration) (net.Conn, error) {
+	proxy, err := proxyFor(&http.Request{URL: &url.URL{Scheme: "https", Host: address}})
+	if err != nil {
+		return nil, err
+	}
+	if proxy == nil {
+		return net.DialTimeout("tcp", address, timeout)
+	}
+	host := proxy.Host
+	if proxy.Port() == "" {
+		host = net.JoinHostPort(proxy.Hostname(), "80")
+		if proxy.Scheme == "https" {
+			host = net.JoinHostPort(proxy.Hostname(), "443")
+		}
+	}
+	dialer := &net.Dialer{Timeout: timeout}
+	var conn net.Conn
+	if proxy.Scheme == "https" {
+		conn, err = tls.DialWithDialer(dialer, "tcp", host, nil)
+	} else {
+		conn, err = dialer.Dial("tcp", host)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Proxy %s: %v", proxy.Host, err)
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+	req := "CONNECT " + address + " HTTP/1.1\r\nHost: " + address + "\r\n"
+	if proxy.User != nil {
+		password, _ := proxy.User.Password()
+		req += "Proxy-Authorization: Basic " + base64.StdEncoding.EncodeToString([]byte(proxy.User.Username()+":"+password)) + "\r\n"
+	}
+	if _, err = conn.Write([]byte(req + "\r\n")); err == nil {
+		var resp *http.Response
+		if resp, err = http.ReadResponse(bufio.NewReader(conn), nil); err == nil {
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				err = fmt.Errorf("CONNECT %s answered %s", address, resp.Status)
+			}
+		}
+	}
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("Proxy %s: %v", proxy.Host, err)
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
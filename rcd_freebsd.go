@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/kardianos/osext"
+)
+
+// rcDir is where wsw installs its rc.d scripts.
+const rcDir = "/usr/local/etc/rc.d"
+
+// scriptMarker starts every script wsw writes, after the #! line, telling
+// them apart from others.
+const scriptMarker = "# Written by wsw, change the wsw config and run wsw -a sync instead."
+
+// rcUnsafe matches what rc.conf variable names cannot hold.
+var rcUnsafe = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// rcName is the rc.d name of the service, which prefixes its rc.conf
+// variables such as <name>_enable.
+func rcName(name string) string {
+	return rcUnsafe.ReplaceAllString(name, "_")
+}
+
+func scriptPath(name string) string {
+	return filepath.Join(rcDir, name)
+}
+
+// runRc runs an rc tool such as service or sysrc, returning its output in
+// the error if it fails.
+func runRc(name string, args ...string) error {
+	out, err := exec.Command(name, args...).CombinedOutput()
+	if err != nil {
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			return fmt.Errorf("%s %s: %s", name, strings.Join(args, " "), msg)
+		}
+		return fmt.Errorf("%s %s: %v", name, strings.Join(args, " "), err)
+	}
+	return nil
+}
+
+// renderScript returns the rc.d script running exe for the config through
+// daemon(8). rc.d signals wsw itself, which stops its processes.
+func renderScript(conf *Config, exe string) []byte {
+	rc := rcName(conf.Name)
+	var b bytes.Buffer
+	fmt.Fprintln(&b, "#!/bin/sh")
+	fmt.Fprintln(&b, scriptMarker)
+	if conf.Description != "" {
+		fmt.Fprintf(&b, "# Description: %s\n", strings.Replace(conf.Description, "\n", " ", -1))
+	}
+	fmt.Fprintln(&b, "#")
+	fmt.Fprintf(&b, "# PROVIDE: %s\n", conf.Name)
+	fmt.Fprintf(&b, "# REQUIRE: %s\n", strings.Join(append([]string{"NETWORKING"}, conf.Dependencies...), " "))
+	fmt.Fprintln(&b, "# KEYWORD: shutdown")
+	fmt.Fprintln(&b)
+	fmt.Fprintln(&b, ". /etc/rc.subr")
+	fmt.Fprintln(&b)
+	fmt.Fprintf(&b, "name=%s\n", rc)
+	fmt.Fprintf(&b, "rcvar=%s_enable\n", rc)
+	fmt.Fprintf(&b, "desc=%s\n", shellQuote(conf.DisplayName))
+	fmt.Fprintln(&b)
+	fmt.Fprintln(&b, "load_rc_config $name")
+	fmt.Fprintln(&b)
+	fmt.Fprintf(&b, ": ${%s_enable:=\"NO\"}\n", rc)
+	fmt.Fprintf(&b, "%s_chdir=%s\n", rc, shellQuote(filepath.Dir(exe)))
+	fmt.Fprintln(&b)
+	fmt.Fprintln(&b, `pidfile="/var/run/${name}.pid"`)
+	fmt.Fprintf(&b, "procname=%s\n", shellQuote(exe))
+	fmt.Fprintln(&b, `command="/usr/sbin/daemon"`)
+	fmt.Fprintln(&b, `command_args="-f -p ${pidfile} \"${procname}\""`)
+	fmt.Fprintln(&b, "export WSW_INIT=rc.d")
+	fmt.Fprintln(&b)
+	fmt.Fprintln(&b, `run_rc_command "$1"`)
+	return b.Bytes()
+}
+
+// readScript returns the header comments and single quoted variables of an
+// rc.d script, by name, if wsw wrote it.
+func readScript(path string) (map[string]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	lines := strings.Split(string(data), "\n")
+	if len(lines) < 2 || lines[1] != scriptMarker {
+		return nil, fmt.Errorf("%s was not written by wsw", path)
+	}
+	keys := map[string]string{}
+	for _, line := range lines[2:] {
+		if strings.HasPrefix(line, "# ") {
+			if kv := strings.SplitN(line[2:], ": ", 2); len(kv) == 2 {
+				keys[kv[0]] = kv[1]
+			}
+			continue
+		}
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) == 2 && strings.HasPrefix(kv[1], "'") && strings.HasSuffix(kv[1], "'") {
+			keys[kv[0]] = strings.Replace(kv[1][1:len(kv[1])-1], `'\''`, `'`, -1)
+		}
+	}
+	return keys, nil
+}
+
+// installService writes the rc.d script of the service and enables it in
+// rc.conf.
+func installService(conf *Config) error {
+	path := scriptPath(conf.Name)
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("Init already exists: %s", path)
+	}
+	exe, err := osext.Executable()
+	if err != nil {
+		return err
+	}
+	// The path lands in double quotes in command_args.
+	if strings.ContainsAny(exe, "\"$`\\") {
+		return fmt.Errorf("Cannot run %s from rc.d: the path holds a shell special character", exe)
+	}
+	if err := ioutil.WriteFile(path, renderScript(conf, exe), 0755); err != nil {
+		return err
+	}
+	return runRc("sysrc", rcName(conf.Name)+"_enable=YES")
+}
+
+// uninstallService stops using the service in rc.conf and removes its
+// script.
+func uninstallService(name string) error {
+	path := scriptPath(name)
+	if _, err := readScript(path); err != nil {
+		return err
+	}
+	// rc.conf may not mention the service anymore.
+	runRc("sysrc", "-x", rcName(name)+"_enable")
+	return os.Remove(path)
+}
+
+// sysrcValue returns an rc.conf variable, or "" if it is not set.
+func sysrcValue(name string) string {
+	out, err := exec.Command("sysrc", "-n", name).Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+func queryServiceConfig(name string) (*scmConfig, error) {
+	keys, err := readScript(scriptPath(name))
+	if err != nil {
+		return nil, err
+	}
+	c := &scmConfig{
+		DisplayName: keys["desc"],
+		Description: keys["Description"],
+		BinaryPath:  keys["procname"],
+		Account:     sysrcValue(rcName(name) + "_user"),
+		StartType:   "disabled",
+	}
+	for _, dep := range strings.Fields(keys["REQUIRE"]) {
+		if dep != "NETWORKING" {
+			c.Dependencies = append(c.Dependencies, dep)
+		}
+	}
+	if strings.EqualFold(sysrcValue(rcName(name)+"_enable"), "YES") {
+		c.StartType = "enabled"
+	}
+	return c, nil
+}
+
+func queryServiceState(name string) (string, error) {
+	if _, err := os.Stat(scriptPath(name)); err != nil {
+		return "", fmt.Errorf("Service %s is not installed", name)
+	}
+	// status exits non-zero when the service is not running.
+	if err := exec.Command("service", name, "onestatus").Run(); err != nil {
+		if _, ok := err.(*exec.ExitError); ok {
+			return "stopped", nil
+		}
+		return "", err
+	}
+	return "running", nil
+}
+
+// listWswServices returns the rc.d scripts wsw installed.
+func listWswServices() ([]wswService, error) {
+	paths, err := filepath.Glob(filepath.Join(rcDir, "*"))
+	if err != nil {
+		return nil, err
+	}
+	var services []wswService
+	for _, path := range paths {
+		name := filepath.Base(path)
+		c, err := queryServiceConfig(name)
+		if err != nil {
+			continue
+		}
+		services = append(services, wswService{Name: name, Exe: c.BinaryPath, Dependencies: c.Dependencies})
+	}
+	return services, nil
+}
+
+// startService starts the service, whether or not rc.conf enables it.
+func startService(name string) error {
+	return runRc("service", name, "onestart")
+}
+
+// stopService stops the service; rc.d waits until it exited.
+func stopService(name string) error {
+	return runRc("service", name, "onestop")
+}
+
+func restartService(name string) error {
+	return runRc("service", name, "onerestart")
+}
+
+// syncService rewrites the script when DisplayName or Description changed,
+// returning whether they did.
+func syncService(conf *Config) (bool, error) {
+	c, err := queryServiceConfig(conf.Name)
+	if err != nil {
+		return false, err
+	}
+	if c.DisplayName == conf.DisplayName && c.Description == strings.Replace(conf.Description, "\n", " ", -1) {
+		return false, nil
+	}
+	return true, ioutil.WriteFile(scriptPath(conf.Name), renderScript(conf, c.BinaryPath), 0755)
+}
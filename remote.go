@@ -0,0 +1,155 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// signatureHeader carries the base64 Ed25519 signature of a remote config.
+const signatureHeader = "X-Wsw-Signature"
+
+// httpClient makes wsw's own requests, through the configured Proxy.
+var httpClient = &http.Client{Timeout: 30 * time.Second, Transport: newProxyTransport()}
+
+// applyRemoteConfig fetches conf.ConfigURL and lays it over conf. The last
+// good copy is cached in the state directory and used when the URL cannot be
+// reached, or without fetch, where conf is kept as is if nothing is cached.
+func applyRemoteConfig(conf *Config, fetch bool) (*Config, error) {
+	if conf.ConfigURL == "" {
+		return conf, nil
+	}
+	if !fetch {
+		return applyRemoteCache(conf)
+	}
+	dir, err := getStateDir(conf)
+	if err != nil {
+		return nil, err
+	}
+	cache := filepath.Join(dir, "remote")
+	data, err := fetchRemoteConfig(conf, cache)
+	if err != nil {
+		cached, cerr := readRemoteCache(conf, cache)
+		if cerr != nil {
+			return nil, fmt.Errorf("Failed to fetch config %q: %v", conf.ConfigURL, err)
+		}
+		warnf("Failed to fetch config %q, using cached copy: %v", conf.ConfigURL, err)
+		data = cached
+		configSource += ", overlaid with the cached copy of " + conf.ConfigURL
+	} else {
+		configSource += ", overlaid with " + conf.ConfigURL
+	}
+	return overlayRemote(conf, data)
+}
+
+// applyRemoteCache lays the cached copy of conf.ConfigURL over conf, without
+// creating the state directory.
+func applyRemoteCache(conf *Config) (*Config, error) {
+	dir, err := peekStateDir(conf)
+	if err != nil {
+		return nil, err
+	}
+	data, err := readRemoteCache(conf, filepath.Join(dir, "remote"))
+	if os.IsNotExist(err) {
+		return conf, nil
+	} else if err != nil {
+		warnf("Ignoring the cached copy of %q: %v", conf.ConfigURL, err)
+		return conf, nil
+	}
+	configSource += ", overlaid with the cached copy of " + conf.ConfigURL
+	return overlayRemote(conf, data)
+}
+
+func overlayRemote(conf *Config, data []byte) (*Config, error) {
+	remote := *conf
+	if err := unmarshalConfig(data, &remote); err != nil {
+		return nil, fmt.Errorf("Invalid remote config %q: %v", conf.ConfigURL, err)
+	}
+	// Where the config comes from and who signs it stays under local control.
+	remote.ConfigURL = conf.ConfigURL
+	remote.ConfigKey = conf.ConfigKey
+	return &remote, nil
+}
+
+func fetchRemoteConfig(conf *Config, cache string) ([]byte, error) {
+	req, err := http.NewRequest("GET", conf.ConfigURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if etag, err := ioutil.ReadFile(cache + ".etag"); err == nil {
+		if _, err := os.Stat(cache + ".json"); err == nil {
+			req.Header.Set("If-None-Match", string(etag))
+		}
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified {
+		return readRemoteCache(conf, cache)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Unexpected status %s", resp.Status)
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	sig := resp.Header.Get(signatureHeader)
+	if err := verifyConfig(conf, data, sig); err != nil {
+		return nil, err
+	}
+	ioutil.WriteFile(cache+".json", data, 0644)
+	ioutil.WriteFile(cache+".sig", []byte(sig), 0644)
+	if etag := resp.Header.Get("ETag"); etag != "" {
+		ioutil.WriteFile(cache+".etag", []byte(etag), 0644)
+	} else {
+		os.Remove(cache + ".etag")
+	}
+	return data, nil
+}
+
+func readRemoteCache(conf *Config, cache string) ([]byte, error) {
+	data, err := ioutil.ReadFile(cache + ".json")
+	if err != nil {
+		return nil, err
+	}
+	sig, _ := ioutil.ReadFile(cache + ".sig")
+	if err := verifyConfig(conf, data, string(sig)); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// verifyConfig checks the Ed25519 signature of a remote config when a
+// ConfigKey is configured.
+func verifyConfig(conf *Config, data []byte, sig string) error {
+	return verifySignature(conf, data, sig, "Remote config")
+}
+
+// verifySignature checks the base64 Ed25519 signature sig of data, named
+// what in errors, against ConfigKey when one is configured.
+func verifySignature(conf *Config, data []byte, sig, what string) error {
+	if conf.ConfigKey == "" {
+		return nil
+	}
+	key, err := base64.StdEncoding.DecodeString(conf.ConfigKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return fmt.Errorf("Invalid ConfigKey %q", conf.ConfigKey)
+	}
+	if sig == "" {
+		return fmt.Errorf("%s is not signed", what)
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(sig))
+	if err != nil || !ed25519.Verify(ed25519.PublicKey(key), data, raw) {
+		return fmt.Errorf("%s signature mismatch", what)
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+func (c *child) restartPolicy() string {
+	if c.Restart != "" {
+		return c.Restart
+	}
+	if c.essential() {
+		return "never"
+	}
+	return "always"
+}
+
+func checkRestartPolicy(proc *Process) error {
+	switch proc.Restart {
+	case "", "never", "on-failure", "always":
+		return nil
+	}
+	return fmt.Errorf("Unknown Restart %q, expected never, on-failure or always", proc.Restart)
+}
+
+// restartWindow is the time RestartLimit counts restarts in; a run lasting
+// at least that long also resets the backoff.
+func (c *child) restartWindow() time.Duration {
+	if w := c.process().RestartWindow; w > 0 {
+		return time.Duration(w) * time.Second
+	}
+	return time.Minute
+}
+
+// nextRestart tells whether the child, after exiting from a run that lasted
+// ran, is started again and after what delay.
+func (c *child) nextRestart(ran time.Duration) (time.Duration, bool) {
+	switch c.restartPolicy() {
+	case "never":
+		return 0, false
+	case "on-failure":
+		if c.exitCode == 0 {
+			return 0, false
+		}
+	}
+	now := time.Now()
+	window := c.restartWindow()
+	if c.RestartLimit > 0 {
+		recent := c.recent[:0]
+		for _, t := range c.recent {
+			if now.Sub(t) < window {
+				recent = append(recent, t)
+			}
+		}
+		c.recent = recent
+		if len(recent) >= c.RestartLimit {
+			if c.Rollback && c.rollback() {
+				c.recent, c.backoff = nil, 0
+			} else {
+				logger.Warningf("%s restarted %d times within %v, giving up", c.label(), len(recent), window)
+				c.setFailure(exitCrashLoop)
+				return 0, false
+			}
+		}
+		c.recent = append(c.recent, now)
+	}
+
+	delay := time.Second
+	if c.RestartDelay > 0 {
+		delay = time.Duration(c.RestartDelay) * time.Second
+	}
+	maxDelay := time.Minute
+	if c.RestartMaxDelay > 0 {
+		maxDelay = time.Duration(c.RestartMaxDelay) * time.Second
+	}
+	switch {
+	case ran >= window || c.backoff == 0:
+		c.backoff = delay
+	case c.backoff < maxDelay:
+		c.backoff *= 2
+	}
+	if c.backoff > maxDelay {
+		c.backoff = maxDelay
+	}
+	return c.backoff, true
+}
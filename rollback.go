@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// knownGoodPath is where the last executable of the child that ran stable
+// is kept.
+func (c *child) knownGoodPath() (string, error) {
+	dir, err := getStateDir(c.prg.Config)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "known-good", c.fullName(), filepath.Base(c.exe)), nil
+}
+
+// keepKnownGood saves the executable as known good once the current run
+// stayed up for the restart window.
+func (c *child) keepKnownGood(exited chan struct{}) {
+	select {
+	case <-exited:
+		return
+	case <-c.prg.exit:
+		return
+	case <-time.After(c.restartWindow()):
+	}
+	path, err := c.knownGoodPath()
+	if err != nil {
+		logger.Warningf("Failed to keep known good copy of %s: %v", c.label(), err)
+		return
+	}
+	if same, _ := sameFile(c.exe, path); same {
+		return
+	}
+	if err := copyFile(c.exe, path); err != nil {
+		logger.Warningf("Failed to keep known good copy of %s: %v", c.label(), err)
+	}
+}
+
+// rollback puts the known good executable back in place of a crash looping
+// one. It returns false if there is nothing different to go back to.
+func (c *child) rollback() bool {
+	path, err := c.knownGoodPath()
+	if err != nil {
+		return false
+	}
+	if _, err := os.Stat(path); err != nil {
+		return false
+	}
+	if same, err := sameFile(c.exe, path); err != nil || same {
+		return false
+	}
+	if err := copyFile(path, c.exe); err != nil {
+		logger.Errorf("%s is crash looping and rolling back %q failed: %v", c.label(), c.exe, err)
+		return false
+	}
+	logger.Errorf("%s is crash looping, rolled %q back to the last known good copy", c.label(), c.exe)
+	return true
+}
+
+func sameFile(a, b string) (bool, error) {
+	ha, err := hashFile(a)
+	if err != nil {
+		return false, err
+	}
+	hb, err := hashFile(b)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(ha, hb), nil
+}
+
+func hashFile(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// copyFile replaces dst with a copy of src, mode included, through a
+// temporary file.
+func copyFile(src, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	fi, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(dst), ".wsw-copy-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = io.Copy(tmp, in)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		// TempFile creates the file 0600, which would drop the exec bit.
+		err = os.Chmod(tmp.Name(), fi.Mode())
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), dst)
+}
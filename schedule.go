@@ -0,0 +1,199 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronSchedule is a parsed five field cron expression: minute, hour, day of
+// month, month and day of week.
+type cronSchedule struct {
+	minute, hour, dom, month, dow map[int]bool
+	// anyDom and anyDow are set when the field is "*"; cron matches either
+	// day field when both are restricted.
+	anyDom, anyDow bool
+}
+
+var cronAliases = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+func parseCron(expr string) (*cronSchedule, error) {
+	if alias, ok := cronAliases[strings.TrimSpace(expr)]; ok {
+		expr = alias
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("Invalid schedule %q, expected 5 fields", expr)
+	}
+	s := &cronSchedule{anyDom: fields[2] == "*", anyDow: fields[4] == "*"}
+	var err error
+	for _, f := range []struct {
+		set      *map[int]bool
+		min, max int
+	}{{&s.minute, 0, 59}, {&s.hour, 0, 23}, {&s.dom, 1, 31}, {&s.month, 1, 12}, {&s.dow, 0, 7}} {
+		if *f.set, err = parseCronField(fields[0], f.min, f.max); err != nil {
+			return nil, fmt.Errorf("Invalid schedule %q: %v", expr, err)
+		}
+		fields = fields[1:]
+	}
+	// Both 0 and 7 are Sunday.
+	if s.dow[7] {
+		s.dow[0] = true
+	}
+	return s, nil
+}
+
+// parseCronField reads a comma separated list of "*", "n" or "a-b", each
+// optionally followed by "/step".
+func parseCronField(field string, min, max int) (map[int]bool, error) {
+	set := map[int]bool{}
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("bad step in %q", part)
+			}
+			step, part = n, part[:i]
+		}
+		lo, hi := min, max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			n, err := strconv.Atoi(bounds[0])
+			if err != nil {
+				return nil, fmt.Errorf("bad value %q", part)
+			}
+			lo, hi = n, n
+			if len(bounds) == 2 {
+				if hi, err = strconv.Atoi(bounds[1]); err != nil {
+					return nil, fmt.Errorf("bad range %q", part)
+				}
+			} else if step > 1 {
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return nil, fmt.Errorf("%q is out of range %d-%d", part, min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			set[v] = true
+		}
+	}
+	return set, nil
+}
+
+func (s *cronSchedule) matchDay(t time.Time) bool {
+	dom, dow := s.dom[t.Day()], s.dow[int(t.Weekday())]
+	switch {
+	case s.anyDom && s.anyDow:
+		return true
+	case s.anyDom:
+		return dow
+	case s.anyDow:
+		return dom
+	}
+	return dom || dow
+}
+
+// next returns the first time after t the schedule fires, or the zero time
+// if it never does.
+func (s *cronSchedule) next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	// Every valid schedule fires within a few years, Feb 29 included.
+	end := t.AddDate(5, 0, 0)
+	for t.Before(end) {
+		switch {
+		case !s.month[int(t.Month())]:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !s.matchDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case !s.hour[t.Hour()]:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case !s.minute[t.Minute()]:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// schedule starts every child whenever Schedule fires, until the service
+// stops.
+func (p *program) schedule() {
+	sched, err := parseCron(p.Schedule)
+	if err != nil {
+		logger.Warningf("%v", err)
+		return
+	}
+	for {
+		next := sched.next(time.Now())
+		if next.IsZero() {
+			logger.Warningf("Schedule %q never fires", p.Schedule)
+			return
+		}
+		select {
+		case <-p.exit:
+			return
+		case <-time.After(time.Until(next)):
+		}
+		for _, c := range p.children {
+			c.fire()
+		}
+	}
+}
+
+// fire starts a scheduled run of the child, applying Overlap when the
+// previous run is still going.
+func (c *child) fire() {
+	c.mu.Lock()
+	if !c.running {
+		c.running = true
+		c.mu.Unlock()
+		go c.runScheduled()
+		return
+	}
+	switch c.prg.Overlap {
+	case "queue":
+		c.queued = true
+		c.mu.Unlock()
+		logger.Info(c.label(), " is still running, queueing the next run")
+	case "kill-previous":
+		c.queued = true
+		c.mu.Unlock()
+		logger.Info(c.label(), " is still running, stopping it for the next run")
+		c.stop()
+	default:
+		c.mu.Unlock()
+		logger.Info(c.label(), " is still running, skipping this run")
+	}
+}
+
+// runScheduled runs the child, then once more for every queued run.
+func (c *child) runScheduled() {
+	for {
+		c.takePending()
+		if err := c.prepare(); err != nil {
+			logger.Warningf("%s: %v", c.label(), err)
+		} else {
+			c.run()
+		}
+		c.mu.Lock()
+		if !c.queued {
+			c.running = false
+			c.mu.Unlock()
+			return
+		}
+		c.queued = false
+		c.mu.Unlock()
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCron(t *testing.T) {
+	for _, tt := range []struct {
+		expr string
+		ok   bool
+	}{
+		{"* * * * *", true},
+		{"*/15 0-6 1,15 * 1-5", true},
+		{"@daily", true},
+		{" @hourly ", true},
+		{"30 4 * * 7", true},
+		{"5/10 * * * *", true},
+		{"* * * *", false},
+		{"* * * * * *", false},
+		{"60 * * * *", false},
+		{"* 24 * * *", false},
+		{"* * 0 * *", false},
+		{"* * * 13 *", false},
+		{"* * * * 8", false},
+		{"5-1 * * * *", false},
+		{"*/0 * * * *", false},
+		{"a * * * *", false},
+		{"1-b * * * *", false},
+		{"@often", false},
+	} {
+		if _, err := parseCron(tt.expr); (err == nil) != tt.ok {
+			t.Errorf("parseCron(%q) error = %v, want ok %v", tt.expr, err, tt.ok)
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	at := func(s string) time.Time {
+		v, err := time.Parse("2006-01-02 15:04", s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	for _, tt := range []struct {
+		expr, from, want string
+	}{
+		{"* * * * *", "2024-03-10 12:00", "2024-03-10 12:01"},
+		{"*/15 * * * *", "2024-03-10 12:07", "2024-03-10 12:15"},
+		{"0 * * * *", "2024-03-10 12:00", "2024-03-10 13:00"},
+		{"@daily", "2024-12-31 23:59", "2025-01-01 00:00"},
+		{"30 4 1 * *", "2024-01-31 05:00", "2024-02-01 04:30"},
+		// 2024-03-10 is a Sunday, which both 0 and 7 name.
+		{"0 9 * * 0", "2024-03-09 10:00", "2024-03-10 09:00"},
+		{"0 9 * * 7", "2024-03-09 10:00", "2024-03-10 09:00"},
+		{"0 9 * * 1-5", "2024-03-08 10:00", "2024-03-11 09:00"},
+		// When both day fields are restricted either one matches.
+		{"0 0 15 * 1", "2024-03-12 00:00", "2024-03-15 00:00"},
+		{"0 0 15 * 1", "2024-03-15 00:00", "2024-03-18 00:00"},
+		{"0 0 29 2 *", "2024-03-01 00:00", "2028-02-29 00:00"},
+		{"5/20 * * * *", "2024-03-10 12:30", "2024-03-10 12:45"},
+		{"0 0 31 2 *", "2024-01-01 00:00", ""},
+	} {
+		s, err := parseCron(tt.expr)
+		if err != nil {
+			t.Fatalf("parseCron(%q): %v", tt.expr, err)
+		}
+		got := s.next(at(tt.from))
+		if tt.want == "" {
+			if !got.IsZero() {
+				t.Errorf("%q after %s = %v, want never", tt.expr, tt.from, got)
+			}
+		} else if !got.Equal(at(tt.want)) {
+			t.Errorf("%q after %s = %v, want %s", tt.expr, tt.from, got, tt.want)
+		}
+	}
+}
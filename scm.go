@@ -0,0 +1,13 @@
+package main
+
+// scmConfig is the part of the SCM service configuration, or the systemd
+// unit or rc.d script, wsw cares about.
+type scmConfig struct {
+	DisplayName, Description string
+	StartType                string
+	BinaryPath               string
+	Dependencies             []string
+	LoadOrderGroup           string
+	Tag                      uint32
+	Account                  string
+}
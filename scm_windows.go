@@ -0,0 +1,334 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/registry"
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+func queryServiceConfig(name string) (*scmConfig, error) {
+	m, err := mgr.Connect()
+	if err != nil {
+		return nil, err
+	}
+	defer m.Disconnect()
+	s, err := m.OpenService(name)
+	if err != nil {
+		return nil, err
+	}
+	defer s.Close()
+	c, err := s.Config()
+	if err != nil {
+		return nil, err
+	}
+	return &scmConfig{
+		DisplayName:  c.DisplayName,
+		Description:  c.Description,
+		StartType:    startTypeName(c.StartType, c.DelayedAutoStart),
+		BinaryPath:   c.BinaryPathName,
+		Dependencies: c.Dependencies,
+
+		LoadOrderGroup: c.LoadOrderGroup,
+		Tag:            c.TagId,
+		Account:        c.ServiceStartName,
+	}, nil
+}
+
+func startTypeName(t uint32, delayed bool) string {
+	switch t {
+	case windows.SERVICE_BOOT_START:
+		return "boot"
+	case windows.SERVICE_SYSTEM_START:
+		return "system"
+	case mgr.StartAutomatic:
+		if delayed {
+			return "automatic (delayed)"
+		}
+		return "automatic"
+	case mgr.StartManual:
+		return "manual"
+	case mgr.StartDisabled:
+		return "disabled"
+	}
+	return "unknown"
+}
+
+func queryServiceState(name string) (string, error) {
+	m, err := mgr.Connect()
+	if err != nil {
+		return "", err
+	}
+	defer m.Disconnect()
+	s, err := m.OpenService(name)
+	if err != nil {
+		return "", err
+	}
+	defer s.Close()
+	st, err := s.Query()
+	if err != nil {
+		return "", err
+	}
+	return stateName(st.State), nil
+}
+
+func stateName(s svc.State) string {
+	switch s {
+	case svc.Stopped:
+		return "stopped"
+	case svc.StartPending:
+		return "starting"
+	case svc.StopPending:
+		return "stopping"
+	case svc.Running:
+		return "running"
+	case svc.ContinuePending:
+		return "continuing"
+	case svc.PausePending:
+		return "pausing"
+	case svc.Paused:
+		return "paused"
+	}
+	return "unknown"
+}
+
+// listWswServices returns the installed services whose executable carries a
+// wsw config.
+func listWswServices() ([]wswService, error) {
+	m, err := mgr.Connect()
+	if err != nil {
+		return nil, err
+	}
+	defer m.Disconnect()
+	names, err := m.ListServices()
+	if err != nil {
+		return nil, err
+	}
+	var services []wswService
+	for _, name := range names {
+		s, err := m.OpenService(name)
+		if err != nil {
+			continue
+		}
+		c, err := s.Config()
+		s.Close()
+		if err != nil {
+			continue
+		}
+		exe := binaryExe(c.BinaryPathName)
+		if !isWswService(exe, name) {
+			continue
+		}
+		services = append(services, wswService{Name: name, Exe: exe, Dependencies: c.Dependencies})
+	}
+	return services, nil
+}
+
+// binaryExe returns the executable of an SCM binary path, which may be
+// quoted and followed by arguments.
+func binaryExe(binaryPath string) string {
+	if strings.HasPrefix(binaryPath, `"`) {
+		if i := strings.Index(binaryPath[1:], `"`); i >= 0 {
+			return binaryPath[1 : i+1]
+		}
+	}
+	if i := strings.Index(strings.ToLower(binaryPath), ".exe"); i >= 0 {
+		return binaryPath[:i+4]
+	}
+	return binaryPath
+}
+
+// isWswService tells whether exe is a wsw executable configured to run the
+// named service, looking where loadConfig would find its config.
+func isWswService(exe, name string) bool {
+	configs := [][]byte{}
+	if data, _, err := readEmbedded(exe); err == nil && data != nil {
+		configs = append(configs, data)
+	}
+	dir, execname := filepath.Split(exe)
+	if data, err := ioutil.ReadFile(strings.TrimSuffix(exe, filepath.Ext(exe)) + ".json"); err == nil {
+		configs = append(configs, data)
+	}
+	if data, err := ioutil.ReadFile(filepath.Join(dir, portableDir, "config.json")); err == nil {
+		configs = append(configs, data)
+	}
+	if path := rootConfigPath(exe); path != "" {
+		if data, err := ioutil.ReadFile(path); err == nil {
+			configs = append(configs, data)
+		}
+	}
+	if key, err := registry.OpenKey(registry.LOCAL_MACHINE, fmt.Sprintf("SOFTWARE\\%s", execname), registry.READ); err == nil {
+		if data, _, err := key.GetBinaryValue("config"); err == nil {
+			configs = append(configs, data)
+		}
+		key.Close()
+	}
+	for _, data := range configs {
+		var conf struct{ Name string }
+		if json.Unmarshal(data, &conf) == nil && strings.EqualFold(conf.Name, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// serviceTimeout is how long startService and stopService wait for the
+// service to get there.
+const serviceTimeout = time.Minute
+
+func startService(name string) error {
+	return controlService(name, func(s *mgr.Service) error {
+		err := s.Start()
+		if err == windows.ERROR_SERVICE_ALREADY_RUNNING {
+			return nil
+		}
+		return err
+	}, svc.Running)
+}
+
+func stopService(name string) error {
+	return controlService(name, func(s *mgr.Service) error {
+		_, err := s.Control(svc.Stop)
+		if err == windows.ERROR_SERVICE_NOT_ACTIVE {
+			return nil
+		}
+		return err
+	}, svc.Stopped)
+}
+
+// controlService runs f on the named service and waits until it reaches
+// state.
+func controlService(name string, f func(s *mgr.Service) error, state svc.State) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+	s, err := m.OpenService(name)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	if err := f(s); err != nil {
+		return err
+	}
+	deadline := time.Now().Add(serviceTimeout)
+	for {
+		st, err := s.Query()
+		if err != nil {
+			return err
+		}
+		if st.State == state {
+			return nil
+		}
+		if st.State == svc.Stopped && state == svc.Running {
+			return fmt.Errorf("Service stopped while starting")
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("Service still %s after %v", stateName(st.State), serviceTimeout)
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+}
+
+// configureService applies the parts of the config the service library does
+// not install.
+func configureService(conf *Config) error {
+	if err := addNetworkConfig(conf); err != nil {
+		return err
+	}
+	if len(conf.Triggers) == 0 && conf.LoadOrderGroup == "" && conf.Tag == 0 {
+		return nil
+	}
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+	s, err := m.OpenService(conf.Name)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	if len(conf.Triggers) != 0 {
+		if err := setTriggers(s, conf.Triggers); err != nil {
+			return fmt.Errorf("Failed to set triggers: %v", err)
+		}
+	}
+	if conf.LoadOrderGroup != "" {
+		group, err := windows.UTF16PtrFromString(conf.LoadOrderGroup)
+		if err != nil {
+			return err
+		}
+		err = windows.ChangeServiceConfig(s.Handle, windows.SERVICE_NO_CHANGE, windows.SERVICE_NO_CHANGE,
+			windows.SERVICE_NO_CHANGE, nil, group, nil, nil, nil, nil, nil)
+		if err != nil {
+			return fmt.Errorf("Failed to set load order group: %v", err)
+		}
+	}
+	if conf.Tag != 0 {
+		// The SCM only hands out tags itself; an explicit one is written to
+		// the service key, where GroupOrderList ordering reads it.
+		key, err := registry.OpenKey(registry.LOCAL_MACHINE, `SYSTEM\CurrentControlSet\Services\`+conf.Name, registry.SET_VALUE)
+		if err != nil {
+			return fmt.Errorf("Failed to set tag: %v", err)
+		}
+		defer key.Close()
+		if err := key.SetDWordValue("Tag", conf.Tag); err != nil {
+			return fmt.Errorf("Failed to set tag: %v", err)
+		}
+	}
+	return nil
+}
+
+// syncService pushes DisplayName and Description to the installed service
+// when they changed, returning whether they did.
+func syncService(conf *Config) (bool, error) {
+	m, err := mgr.Connect()
+	if err != nil {
+		return false, err
+	}
+	defer m.Disconnect()
+	s, err := m.OpenService(conf.Name)
+	if err != nil {
+		return false, err
+	}
+	defer s.Close()
+	c, err := s.Config()
+	if err != nil {
+		return false, err
+	}
+	changed := false
+	if c.DisplayName != conf.DisplayName && conf.DisplayName != "" {
+		name, err := windows.UTF16PtrFromString(conf.DisplayName)
+		if err != nil {
+			return false, err
+		}
+		err = windows.ChangeServiceConfig(s.Handle, windows.SERVICE_NO_CHANGE, windows.SERVICE_NO_CHANGE,
+			windows.SERVICE_NO_CHANGE, nil, nil, nil, nil, nil, nil, name)
+		if err != nil {
+			return false, fmt.Errorf("Failed to update display name: %v", err)
+		}
+		changed = true
+	}
+	if c.Description != conf.Description {
+		desc, err := windows.UTF16PtrFromString(conf.Description)
+		if err != nil {
+			return false, err
+		}
+		d := windows.SERVICE_DESCRIPTION{Description: desc}
+		if err := windows.ChangeServiceConfig2(s.Handle, windows.SERVICE_CONFIG_DESCRIPTION, (*byte)(unsafe.Pointer(&d))); err != nil {
+			return false, fmt.Errorf("Failed to update description: %v", err)
+		}
+		changed = true
+	}
+	return changed, nil
+}
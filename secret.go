@@ -0,0 +1,206 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+)
+
+// Args, RawArgs, Env and the Args of hook commands may refer to secrets as
+// secret://<provider>/<key>, which runs to the next space or quote. A
+// reference is resolved right before the process is created and the result
+// is handed to it only: start snapshots, the run state, traces and the
+// config snapshot all keep the reference.
+
+// secretRef matches a secret reference.
+var secretRef = regexp.MustCompile(`secret://([A-Za-z0-9_.-]+)/([^\s"']*)`)
+
+// A secretProvider returns the secret stored under key, r holding the config
+// for the settings of the provider and the process being launched.
+type secretProvider func(r *secretResolver, key string) (string, error)
+
+// secretProviders are the providers by the name references use. It is set
+// in init as providers may resolve references in their own settings.
+var secretProviders map[string]secretProvider
+
+func init() {
+	secretProviders = map[string]secretProvider{
+		// secret://env/NAME is a variable of wsw's own environment, such as one
+		// set by the service manager.
+		"env": func(r *secretResolver, key string) (string, error) {
+			value, ok := os.LookupEnv(key)
+			if !ok {
+				return "", fmt.Errorf("Variable %s is not set", key)
+			}
+			return value, nil
+		},
+		// secret://file/PATH is the content of a file, such as
+		// secret://file//run/secrets/db, less a trailing newline.
+		"file": func(r *secretResolver, key string) (string, error) {
+			data, err := ioutil.ReadFile(key)
+			if err != nil {
+				return "", err
+			}
+			return strings.TrimRight(string(data), "\r\n"), nil
+		},
+		"azkv":   azkvSecret,
+		"vault":  vaultSecret,
+		"awssm":  awssmSecret,
+		"ssm":    ssmSecret,
+		"dpapi":  dpapiSecret,
+		"prompt": promptSecret,
+	}
+}
+
+// Secrets configures the secret providers that need it.
+type Secrets struct {
+	AzureKeyVault *AzureKeyVault
+	Vault         *Vault
+	AWS           *AWS
+}
+
+// checkSecrets fails if one of values refers to an unknown provider, so
+// typos show when the service starts rather than on a later restart.
+func checkSecrets(values ...string) error {
+	for _, v := range values {
+		for _, m := range secretRef.FindAllStringSubmatch(v, -1) {
+			if secretProviders[m[1]] == nil {
+				return fmt.Errorf("Unknown secret provider %q in %s", m[1], m[0])
+			}
+		}
+	}
+	return nil
+}
+
+// secretResolver resolves references for one process, looking each one up
+// once.
+type secretResolver struct {
+	conf  *Config
+	cache map[string]string
+	// reads is where providers keep what they read, so that references to
+	// several fields of one secret share a read.
+	reads map[string]interface{}
+	// child is the child being launched, and done closed once that run
+	// ended, for secrets that must be kept up while it runs. Both are nil
+	// for short-lived commands.
+	child *child
+	done  <-chan struct{}
+	// leasing is set by a provider for a secret that changes on every read,
+	// which leased then lists by reference so that it is not polled.
+	leasing bool
+	leased  map[string]bool
+}
+
+func newSecretResolver(conf *Config) *secretResolver {
+	return &secretResolver{conf: conf, cache: map[string]string{}, reads: map[string]interface{}{}, leased: map[string]bool{}}
+}
+
+// resolve replaces the references in s.
+func (r *secretResolver) resolve(s string) (string, error) {
+	if !strings.Contains(s, "secret://") {
+		return s, nil
+	}
+	var err error
+	out := secretRef.ReplaceAllStringFunc(s, func(ref string) string {
+		if value, ok := r.cache[ref]; ok || err != nil {
+			return value
+		}
+		m := secretRef.FindStringSubmatch(ref)
+		provider := secretProviders[m[1]]
+		if provider == nil {
+			err = fmt.Errorf("Unknown secret provider %q in %s", m[1], ref)
+			return ""
+		}
+		r.leasing = false
+		value, perr := provider(r, m[2])
+		if perr != nil {
+			err = fmt.Errorf("Failed to resolve %s: %v", ref, perr)
+			return ""
+		}
+		r.cache[ref] = value
+		r.leased[ref] = r.leasing
+		return value
+	})
+	return out, err
+}
+
+func (r *secretResolver) resolveAll(values []string) ([]string, error) {
+	var out []string
+	for i, v := range values {
+		resolved, err := r.resolve(v)
+		if err != nil {
+			return nil, err
+		}
+		if resolved != v && out == nil {
+			out = append([]string{}, values...)
+		}
+		if out != nil {
+			out[i] = resolved
+		}
+	}
+	if out == nil {
+		return values, nil
+	}
+	return out, nil
+}
+
+// resolveCmdSecrets resolves the references in the arguments, command line
+// and environment of cmd. restore puts the references back once the process
+// is created.
+func resolveCmdSecrets(r *secretResolver, cmd *exec.Cmd) (restore func(), err error) {
+	args, env := cmd.Args, cmd.Env
+	if cmd.Args, err = r.resolveAll(args); err != nil {
+		cmd.Args = args
+		return nil, err
+	}
+	if cmd.Env, err = r.resolveAll(env); err != nil {
+		cmd.Args, cmd.Env = args, env
+		return nil, err
+	}
+	line := rawCmdLine(cmd)
+	var rawLine string
+	if line != nil {
+		rawLine = *line
+		if *line, err = r.resolve(rawLine); err != nil {
+			cmd.Args, cmd.Env, *line = args, env, rawLine
+			return nil, err
+		}
+	}
+	return func() {
+		cmd.Args, cmd.Env = args, env
+		if line != nil {
+			*line = rawLine
+		}
+	}, nil
+}
+
+// launch starts the child with start, its secrets resolved for just that
+// long.
+func (c *child) launch(start func() error) error {
+	r := newSecretResolver(c.prg.Config)
+	r.child = c
+	_, r.done = c.current()
+	restore, err := resolveCmdSecrets(r, c.cmd)
+	if err != nil {
+		return err
+	}
+	defer restore()
+	if err := c.withErrorMode(start); err != nil {
+		return err
+	}
+	if policy := c.OnSecretChange; policy == "restart" || policy == "hook" {
+		values := map[string]string{}
+		for ref, value := range r.cache {
+			if !r.leased[ref] {
+				values[ref] = value
+			}
+		}
+		if len(values) != 0 {
+			go c.watchSecrets(r.done, values)
+		}
+	}
+	return nil
+}
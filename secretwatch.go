@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+func checkSecretChange(proc *Process) error {
+	switch proc.OnSecretChange {
+	case "", "ignore", "restart":
+		return nil
+	case "hook":
+		if proc.SecretHook == nil {
+			return fmt.Errorf("OnSecretChange \"hook\" needs a SecretHook")
+		}
+		return nil
+	}
+	return fmt.Errorf("Unknown OnSecretChange %q, expected restart, hook or ignore", proc.OnSecretChange)
+}
+
+func (c *child) secretPollInterval() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.SecretPollInterval > 0 {
+		return time.Duration(c.SecretPollInterval) * time.Second
+	}
+	return 5 * time.Minute
+}
+
+// watchSecrets reads the secrets of the child's run again every
+// SecretPollInterval while the run goes on, values holding what it was
+// launched with, and restarts the child or runs SecretHook once one
+// changed.
+func (c *child) watchSecrets(done <-chan struct{}, values map[string]string) {
+	for {
+		select {
+		case <-done:
+			return
+		case <-c.prg.exit:
+			return
+		case <-time.After(c.secretPollInterval()):
+		}
+		r := newSecretResolver(c.prg.Config)
+		var changed []string
+		for ref, old := range values {
+			value, err := r.resolve(ref)
+			if err != nil {
+				logger.Warningf("%s: failed to read %s again: %v", c.label(), ref, err)
+				continue
+			}
+			if value != old {
+				values[ref] = value
+				changed = append(changed, ref)
+			}
+		}
+		if len(changed) == 0 {
+			continue
+		}
+		sort.Strings(changed)
+		c.mu.Lock()
+		policy, hook := c.OnSecretChange, c.SecretHook
+		c.mu.Unlock()
+		if policy == "restart" {
+			logger.Infof("Restarting %s: %s changed", c.label(), strings.Join(changed, ", "))
+			if err := c.restart(); err != nil {
+				logger.Warningf("Failed to restart %s: %v", c.label(), err)
+			}
+			return
+		}
+		if policy != "hook" || hook == nil {
+			return
+		}
+		logger.Infof("%s: %s changed", c.label(), strings.Join(changed, ", "))
+		a := *hook
+		a.env = []string{"WSW_SECRETS_CHANGED=" + strings.Join(changed, " ")}
+		if err := c.runAction(&a); err != nil {
+			logger.Warningf("Secret hook for %s: %v", c.label(), err)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// sendStdin writes to the stdin of a running process with "Stdin": "pipe",
+// through its stdin pipe: text as a line, else the contents of file, else
+// what wsw reads from its own stdin. name picks the process when several
+// have a stdin pipe.
+func sendStdin(conf *Config, name, text, file string) error {
+	p := &program{Config: conf}
+	children, err := p.newChildren()
+	if err != nil {
+		return err
+	}
+	var piped []*child
+	for _, c := range children {
+		if c.Stdin == "pipe" {
+			piped = append(piped, c)
+		}
+	}
+	if len(piped) == 0 {
+		return fmt.Errorf("No process has \"Stdin\": \"pipe\"")
+	}
+	c, err := pickChild(piped, name)
+	if err != nil {
+		return err
+	}
+	var in io.Reader
+	switch {
+	case text != "":
+		in = strings.NewReader(strings.TrimRight(text, "\r\n") + "\n")
+	case file != "":
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		in = strings.NewReader(string(data))
+	default:
+		in = os.Stdin
+	}
+	f, err := dialPipe(pipePath(c.fullName() + "-stdin"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("%s is not running", c.label())
+		}
+		return err
+	}
+	defer f.Close()
+	if _, err := io.Copy(f, in); err != nil {
+		return fmt.Errorf("Failed to write to the stdin of %s: %v", c.label(), err)
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"log/syslog"
+	"os"
+)
+
+// interactive tells whether wsw runs in a terminal rather than from the rc.d
+// script, which sets WSW_INIT.
+func interactive() bool {
+	return os.Getenv("WSW_INIT") == ""
+}
+
+// syslogLogger logs to syslog under the service name; daemon(8) discards
+// the output of the services it starts.
+type syslogLogger struct {
+	w *syslog.Writer
+}
+
+// newLogger logs to syslog when run from rc.d, falling back to stderr, and
+// with timestamps in a terminal.
+func newLogger(name string) Logger {
+	if !interactive() {
+		if w, err := syslog.New(syslog.LOG_DAEMON|syslog.LOG_INFO, name); err == nil {
+			return syslogLogger{w: w}
+		}
+	}
+	return textLogger{l: log.New(os.Stderr, "", log.LstdFlags)}
+}
+
+func (s syslogLogger) Error(v ...interface{}) error   { return s.w.Err(fmt.Sprint(v...)) }
+func (s syslogLogger) Warning(v ...interface{}) error { return s.w.Warning(fmt.Sprint(v...)) }
+func (s syslogLogger) Info(v ...interface{}) error    { return s.w.Info(fmt.Sprint(v...)) }
+
+func (s syslogLogger) Errorf(format string, a ...interface{}) error {
+	return s.w.Err(fmt.Sprintf(format, a...))
+}
+
+func (s syslogLogger) Warningf(format string, a ...interface{}) error {
+	return s.w.Warning(fmt.Sprintf(format, a...))
+}
+
+func (s syslogLogger) Infof(format string, a ...interface{}) error {
+	return s.w.Info(fmt.Sprintf(format, a...))
+}
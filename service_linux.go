@@ -0,0 +1,21 @@
+package main
+
+import (
+	"log"
+	"os"
+)
+
+// interactive tells whether wsw runs in a terminal rather than as a
+// systemd service, which sets INVOCATION_ID for every service it starts.
+func interactive() bool {
+	return os.Getenv("INVOCATION_ID") == ""
+}
+
+// newLogger logs to the journal under systemd, which adds the time, and
+// with timestamps in a terminal.
+func newLogger(name string) Logger {
+	if interactive() {
+		return textLogger{l: log.New(os.Stderr, "", log.LstdFlags)}
+	}
+	return textLogger{l: log.New(os.Stderr, "", 0), priority: true}
+}
@@ -0,0 +1,71 @@
+//go:build !windows
+
+package main
+
+import (
+	"fmt"
+)
+
+// controlActions are the -a actions run by the init system glue.
+var controlActions = []string{"start", "stop", "restart", "install", "uninstall", "run", "agent"}
+
+// osExitCode maps the service specific exit codes, which do not fit the 8
+// bits of a Unix exit status, to 201 and up.
+func osExitCode(code int) int {
+	if code > 10000 {
+		return 200 + code - 10000
+	}
+	return code
+}
+
+// runAction sets up the logger, then carries out action.
+func runAction(prg *program, action string) {
+	if asTask {
+		fatal("--as-task is only supported on Windows")
+	}
+	if action == "agent" {
+		runAgent(prg)
+		return
+	}
+	logger = newLogger(prg.Name)
+	var err error
+	switch action {
+	case "", "run":
+		runForeground(prg)
+		return
+	case "install":
+		if err = installService(prg.Config); err == nil {
+			err = configureService(prg.Config)
+		}
+	case "uninstall":
+		err = uninstallService(prg.Name)
+	case "start":
+		err = startService(prg.Name)
+	case "stop":
+		err = stopService(prg.Name)
+	case "restart":
+		err = restartService(prg.Name)
+	default:
+		err = fmt.Errorf("Unknown action %q, valid actions: %q", action, controlActions)
+	}
+	if err != nil {
+		fatal(err)
+	}
+}
+
+// configureService warns about the parts of the config only Windows has.
+func configureService(conf *Config) error {
+	if len(conf.Triggers) != 0 || conf.LoadOrderGroup != "" || conf.Tag != 0 {
+		warnf("Triggers, LoadOrderGroup and Tag only apply on Windows, ignoring them")
+	}
+	if len(conf.Firewall) != 0 || len(conf.URLACLs) != 0 || len(conf.CertBindings) != 0 || len(conf.SPNs) != 0 {
+		warnf("Firewall, URLACLs, CertBindings and SPNs only apply on Windows, ignoring them")
+	}
+	return nil
+}
+
+// binaryExe returns the executable of a service binary path, which the
+// service manager backends already read without quotes or arguments.
+func binaryExe(binaryPath string) string {
+	return binaryPath
+}
@@ -0,0 +1,117 @@
+package main
+
+import "github.com/mingxi/service"
+
+// serviceProgram adapts program to the service library.
+type serviceProgram struct {
+	*program
+}
+
+func (p serviceProgram) Start(s service.Service, args ...string) error {
+	return p.program.Start(args...)
+}
+
+func (p serviceProgram) Stop(s service.Service) error {
+	return p.program.Stop()
+}
+
+// interactive tells whether wsw runs in a console rather than as a service.
+func interactive() bool {
+	return service.Interactive()
+}
+
+// osExitCode is code: Windows exit codes take service specific codes as
+// they are.
+func osExitCode(code int) int {
+	return code
+}
+
+// runAction sets up the service and its logger, then carries out action.
+func runAction(prg *program, action string) {
+	if asTask {
+		runTaskAction(prg, action)
+		return
+	} else if action == "agent" {
+		runAgent(prg)
+		return
+	}
+	config := prg.Config
+	svcConfig := &service.Config{
+		Name:        config.Name,
+		DisplayName: config.DisplayName,
+		Description: config.Description,
+
+		Dependencies: config.Dependencies,
+	}
+
+	s, err := service.New(serviceProgram{prg}, svcConfig)
+	if err != nil {
+		fatal(err)
+	}
+
+	errs := make(chan error, 5)
+	logger, err = s.Logger(errs)
+	if err != nil {
+		fatal(err)
+	}
+
+	go func() {
+		for {
+			err := <-errs
+			if err != nil {
+				errorf("%v", err)
+			}
+		}
+	}()
+	handleAction(prg, s, action)
+}
+
+// controlAction tells whether the service library carries out action.
+func controlAction(action string) bool {
+	for _, a := range service.ControlAction {
+		if a == action {
+			return true
+		}
+	}
+	return false
+}
+
+func handleAction(prg *program, s service.Service, action string) {
+	if action == "run" {
+		runForeground(prg)
+	} else if len(action) != 0 {
+		if !controlAction(action) {
+			fatalf("Unknown action %q, valid actions: %q", action, append(service.ControlAction[:], "run", "agent"))
+		}
+		if action == "uninstall" {
+			removeNetworkConfig(prg.Config)
+		}
+		err := service.Control(s, action)
+		if err != nil {
+			if action == "install" && !elevated() {
+				warnf("Creating a service takes an administrator; wsw -a install --as-task registers a Scheduled Task instead")
+			}
+			fatal(err)
+		}
+		if action == "install" {
+			if err := configureService(prg.Config); err != nil {
+				fatal(err)
+			}
+		}
+	} else if service.Interactive() {
+		// The library only stops on Ctrl+C, not when the service ends.
+		go func() {
+			select {
+			case <-prg.done:
+			case <-prg.stopRequests:
+			}
+			prg.Stop()
+		}()
+		err := s.Run()
+		if err != nil {
+			fatal(err)
+		}
+	} else if err := runService(prg); err != nil {
+		fatal(err)
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+// sessionChange runs OnSessionChange of every child for a user session
+// event such as "logon" or "lock". {event} and {session} in the action are
+// replaced by the event and the session ID, which commands also get as
+// WSW_SESSION_EVENT and WSW_SESSION_ID.
+func (p *program) sessionChange(event string, session uint32) {
+	id := strconv.Itoa(int(session))
+	r := strings.NewReplacer("{event}", event, "{session}", id)
+	for _, c := range p.children {
+		if c.OnSessionChange == nil {
+			continue
+		}
+		a := *c.OnSessionChange
+		a.Event = r.Replace(a.Event)
+		a.Stdin = r.Replace(a.Stdin)
+		a.Args = nil
+		for _, arg := range c.OnSessionChange.Args {
+			a.Args = append(a.Args, r.Replace(arg))
+		}
+		a.env = []string{"WSW_SESSION_EVENT=" + event, "WSW_SESSION_ID=" + id}
+		if err := c.runAction(&a); err != nil {
+			logger.Warningf("Session %s for %s: %v", event, c.label(), err)
+		}
+	}
+}
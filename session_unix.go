@@ -0,0 +1,11 @@
+//go:build !windows
+
+package main
+
+import "errors"
+
+// userSessionOptions fails: UserSession starts the child on the Windows
+// console session.
+func (c *child) userSessionOptions() (spawnOptions, func(), error) {
+	return spawnOptions{}, nil, errors.New("UserSession is only supported on Windows")
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"errors"
+	"time"
+
+	"golang.org/x/sys/windows"
+)
+
+// userDesktop is the desktop of the interactive user session.
+const userDesktop = `winsta0\default`
+
+var errNoUserSession = errors.New("No user is logged on to the console")
+
+// activeUserToken returns the token of the user logged on to the console
+// session. Only services running as LocalSystem may query it.
+func activeUserToken() (windows.Token, error) {
+	session := windows.WTSGetActiveConsoleSessionId()
+	if session == 0xFFFFFFFF {
+		return 0, errNoUserSession
+	}
+	var token windows.Token
+	if err := windows.WTSQueryUserToken(session, &token); err != nil {
+		if err == windows.ERROR_NO_TOKEN {
+			return 0, errNoUserSession
+		}
+		return 0, err
+	}
+	return token, nil
+}
+
+// waitUserToken waits until a user logs on to the console.
+func (c *child) waitUserToken() (windows.Token, error) {
+	logged := false
+	for {
+		token, err := activeUserToken()
+		if err != errNoUserSession {
+			return token, err
+		}
+		if !logged {
+			logger.Info("Waiting for a user to log on to start ", c.label())
+			logged = true
+		}
+		select {
+		case <-c.prg.exit:
+			return 0, err
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
+
+// userSessionOptions prepares c.cmd to run on the desktop of the logged on
+// user, with that user's environment. The returned function
+// releases the user token.
+func (c *child) userSessionOptions() (spawnOptions, func(), error) {
+	token, err := c.waitUserToken()
+	if err != nil {
+		return spawnOptions{}, nil, err
+	}
+	env, err := token.Environ(false)
+	if err != nil {
+		token.Close()
+		return spawnOptions{}, nil, err
+	}
+	c.cmd.Env = c.childEnv(env)
+	return spawnOptions{token: token, desktop: userDesktop}, func() { token.Close() }, nil
+}
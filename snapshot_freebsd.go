@@ -0,0 +1,13 @@
+package main
+
+import "golang.org/x/sys/unix"
+
+// osVersion returns the system and its release, such as
+// "FreeBSD 13.2-RELEASE-p4".
+func osVersion() string {
+	var u unix.Utsname
+	if err := unix.Uname(&u); err != nil {
+		return "FreeBSD"
+	}
+	return unix.ByteSliceToString(u.Sysname[:]) + " " + unix.ByteSliceToString(u.Release[:])
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"io/ioutil"
+	"strings"
+	"syscall"
+)
+
+// osVersion returns the distribution and kernel release, such as
+// "Ubuntu 22.04.3 LTS, Linux 5.15.0-91-generic".
+func osVersion() string {
+	s := "Linux"
+	if data, err := ioutil.ReadFile("/etc/os-release"); err == nil {
+		for _, line := range strings.Split(string(data), "\n") {
+			if strings.HasPrefix(line, "PRETTY_NAME=") {
+				s = strings.Trim(strings.TrimPrefix(line, "PRETTY_NAME="), `"`) + ", Linux"
+			}
+		}
+	}
+	var u syscall.Utsname
+	if err := syscall.Uname(&u); err == nil {
+		var release []byte
+		for _, c := range u.Release {
+			if c == 0 {
+				break
+			}
+			release = append(release, byte(c))
+		}
+		s += " " + string(release)
+	}
+	return s
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/registry"
+)
+
+// osVersion returns the Windows version and build, including the update
+// revision, such as "Windows 10.0.19045.3570 (2009)".
+func osVersion() string {
+	v := windows.RtlGetVersion()
+	s := fmt.Sprintf("Windows %d.%d.%d", v.MajorVersion, v.MinorVersion, v.BuildNumber)
+	k, err := registry.OpenKey(registry.LOCAL_MACHINE, `SOFTWARE\Microsoft\Windows NT\CurrentVersion`, registry.QUERY_VALUE)
+	if err != nil {
+		return s
+	}
+	defer k.Close()
+	if ubr, _, err := k.GetIntegerValue("UBR"); err == nil {
+		s += fmt.Sprintf(".%d", ubr)
+	}
+	if release, _, err := k.GetStringValue("DisplayVersion"); err == nil {
+		s += " (" + release + ")"
+	} else if release, _, err := k.GetStringValue("ReleaseId"); err == nil {
+		s += " (" + release + ")"
+	}
+	return s
+}
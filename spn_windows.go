@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"golang.org/x/sys/windows"
+)
+
+func setspn(args ...string) (string, error) {
+	out, err := exec.Command("setspn", args...).CombinedOutput()
+	if err != nil {
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			return "", fmt.Errorf("setspn %s: %s", args[0], msg)
+		}
+		return "", fmt.Errorf("setspn %s: %v", args[0], err)
+	}
+	return string(out), nil
+}
+
+// spnAccount returns the account the SPNs belong to: the one the service,
+// or the task, runs as, or the machine for built-in accounts, which
+// authenticate as the machine on the network.
+func spnAccount(conf *Config) (string, error) {
+	var account string
+	if scm, err := queryServiceConfig(conf.Name); err == nil {
+		account = scm.Account
+	} else {
+		user, err := windows.GetCurrentProcessToken().GetTokenUser()
+		if err != nil {
+			return "", err
+		}
+		name, domain, _, err := user.User.Sid.LookupAccount("")
+		if err != nil {
+			return "", err
+		}
+		account = domain + `\` + name
+	}
+	switch strings.ToLower(account) {
+	case "localsystem", `nt authority\system`, `nt authority\networkservice`:
+		return os.Hostname()
+	case `nt authority\localservice`:
+		return "", fmt.Errorf("LocalService has no network identity to register SPNs for")
+	}
+	if strings.HasPrefix(account, `.\`) {
+		return "", fmt.Errorf("%s is a local account, SPNs need a domain account", account)
+	}
+	return account, nil
+}
+
+// addSPNs registers the SPNs the account does not have yet. setspn checks
+// that no other account has them.
+func addSPNs(conf *Config) error {
+	if len(conf.SPNs) == 0 {
+		return nil
+	}
+	account, err := spnAccount(conf)
+	if err != nil {
+		return fmt.Errorf("SPNs: %v", err)
+	}
+	out, err := setspn("-L", account)
+	if err != nil {
+		return err
+	}
+	have := map[string]bool{}
+	for _, line := range strings.Split(out, "\n") {
+		have[strings.ToLower(strings.TrimSpace(line))] = true
+	}
+	for _, spn := range conf.SPNs {
+		if have[strings.ToLower(spn)] {
+			continue
+		}
+		if _, err := setspn("-S", spn, account); err != nil {
+			return err
+		}
+		infof("Registered SPN %s for %s", spn, account)
+	}
+	return nil
+}
+
+// removeSPNs unregisters the SPNs, only warning about those that cannot be,
+// so that uninstalling goes on.
+func removeSPNs(conf *Config) {
+	if len(conf.SPNs) == 0 {
+		return
+	}
+	account, err := spnAccount(conf)
+	if err != nil {
+		warnf("Failed to remove SPNs: %v", err)
+		return
+	}
+	for _, spn := range conf.SPNs {
+		if _, err := setspn("-D", spn, account); err != nil {
+			warnf("Failed to remove SPN %s: %v", spn, err)
+		}
+	}
+}
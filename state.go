@@ -0,0 +1,249 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// getStateDir returns, creating it if needed, the directory wsw keeps its own
+// files in: the .wsw folder in portable mode, %ProgramData%\wsw\<Name>
+// (/var/lib/wsw/<Name> on Linux, /var/db/wsw/<Name> on FreeBSD) otherwise.
+func getStateDir(config *Config) (string, error) {
+	dir, err := peekStateDir(config)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// peekStateDir returns the state directory without creating it, for
+// read-only queries.
+func peekStateDir(config *Config) (string, error) {
+	exeDir, _, err := getExecPath()
+	if err != nil {
+		return "", err
+	}
+	return stateDirOf(config, exeDir), nil
+}
+
+// stateDirOf returns the state directory of the service config describes
+// when run by a wsw in exeDir, without creating it.
+func stateDirOf(config *Config, exeDir string) string {
+	root := systemStateDir()
+	if config.Portable || root == "" {
+		return filepath.Join(exeDir, portableDir)
+	}
+	return filepath.Join(root, config.Name)
+}
+
+// runState is what the running wrapper records about its children, shown
+// by "wsw -a status".
+type runState struct {
+	WrapperPID int
+	Children   []childState
+}
+
+type childState struct {
+	Name    string
+	PID     int
+	Exec    string
+	Args    []string
+	Dir     string
+	Started time.Time
+	// Created is the child's process creation time, telling it apart from
+	// an unrelated process that later got the same PID.
+	Created time.Time
+}
+
+// runStateMu serializes children saving the run state as they start.
+var runStateMu sync.Mutex
+
+func runStatePath(config *Config) (string, error) {
+	dir, err := getStateDir(config)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "run.json"), nil
+}
+
+func (p *program) saveRunState() {
+	runStateMu.Lock()
+	defer runStateMu.Unlock()
+	st := &runState{WrapperPID: os.Getpid()}
+	if old, err := readRunState(p.Config); err == nil && old.WrapperPID == st.WrapperPID {
+		st = old
+	}
+	for _, c := range p.children {
+		if c.proc == nil {
+			continue
+		}
+		cs := childState{
+			Name:    c.name,
+			PID:     c.proc.Pid,
+			Exec:    c.cmd.Path,
+			Args:    c.cmd.Args[1:],
+			Dir:     c.cmd.Dir,
+			Started: time.Now(),
+		}
+		cs.Created, _, _ = processInfo(cs.PID)
+		found := false
+		for i := range st.Children {
+			if st.Children[i].Name == cs.Name {
+				if st.Children[i].PID == cs.PID {
+					cs.Started = st.Children[i].Started
+				}
+				st.Children[i], found = cs, true
+			}
+		}
+		if !found {
+			st.Children = append(st.Children, cs)
+		}
+	}
+	path, err := runStatePath(p.Config)
+	if err == nil {
+		var data []byte
+		data, err = json.Marshal(st)
+		if err == nil {
+			err = ioutil.WriteFile(path, data, 0644)
+		}
+	}
+	if err != nil {
+		logger.Warningf("Failed to save run state: %v", err)
+	}
+}
+
+func readRunState(config *Config) (*runState, error) {
+	path, err := runStatePath(config)
+	if err != nil {
+		return nil, err
+	}
+	return readRunStateFile(path)
+}
+
+func readRunStateFile(path string) (*runState, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	st := &runState{}
+	return st, json.Unmarshal(data, st)
+}
+
+// findOrphan returns the child a previous wrapper left running, as long as
+// its PID still belongs to the same process and executable.
+func (c *child) findOrphan() *os.Process {
+	st, err := readRunState(c.prg.Config)
+	if err != nil || st.WrapperPID == os.Getpid() {
+		return nil
+	}
+	for _, cs := range st.Children {
+		if cs.Name != c.name || cs.PID == 0 {
+			continue
+		}
+		created, image, err := processInfo(cs.PID)
+		if err != nil || !created.Equal(cs.Created) || !strings.EqualFold(image, c.cmd.Path) {
+			return nil
+		}
+		proc, err := os.FindProcess(cs.PID)
+		if err != nil {
+			return nil
+		}
+		return proc
+	}
+	return nil
+}
+
+// statusOf gathers what printStatus shows, for --json.
+func statusOf(config *Config) statusJSON {
+	s := statusJSON{Name: config.Name, State: "unknown", Processes: []processJSON{}}
+	if state, err := queryServiceState(config.Name); err == nil {
+		s.State = state
+	}
+	if agentRunning(config.Name) {
+		s.State, s.Agent = "running", true
+	}
+	st, err := readRunState(config)
+	if err != nil {
+		return s
+	}
+	s.WrapperPID = st.WrapperPID
+	stats, _ := readStats(config)
+	now := time.Now()
+	for _, cs := range st.Children {
+		p := processJSON{
+			Name:    cs.Name,
+			PID:     cs.PID,
+			Exec:    cs.Exec,
+			Args:    append([]string{}, cs.Args...),
+			Dir:     cs.Dir,
+			Started: isoTime(cs.Started),
+		}
+		if cst := stats[cs.Name]; cst != nil {
+			p.TotalRestarts = cst.TotalRestarts
+			p.Restarts24h = len(recentRestarts(cst.Restarts, now))
+			longest := cst.LongestUptime
+			if s.State == "running" && now.Sub(cs.Started) > longest {
+				longest = now.Sub(cs.Started)
+			}
+			p.LongestUptimeSeconds = seconds(longest)
+			if p.LastCrash = isoTime(cst.LastCrash); p.LastCrash != nil {
+				code := exitCodeString(cst.LastExitCode)
+				p.LastExitCode = &code
+			}
+		}
+		s.Processes = append(s.Processes, p)
+	}
+	return s
+}
+
+// printStatus shows the SCM state of the service and what the wrapper last
+// recorded about its children.
+func printStatus(config *Config) error {
+	if jsonOutput {
+		return printJSON([]statusJSON{statusOf(config)})
+	}
+	state, err := queryServiceState(config.Name)
+	if agentRunning(config.Name) {
+		state = "running (agent)"
+	} else if err != nil {
+		state = fmt.Sprintf("unknown (%v)", err)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 1, ' ', 0)
+	fmt.Fprintf(w, "Name:\t%s\n", config.Name)
+	fmt.Fprintf(w, "State:\t%s\n", state)
+	stats, _ := readStats(config)
+	if st, err := readRunState(config); err == nil {
+		fmt.Fprintf(w, "Wrapper PID:\t%d\n", st.WrapperPID)
+		for _, cs := range st.Children {
+			fmt.Fprintf(w, "\n")
+			fmt.Fprintf(w, "Process:\t%s\n", cs.Name)
+			fmt.Fprintf(w, "PID:\t%d\n", cs.PID)
+			fmt.Fprintf(w, "Exec:\t%s\n", cs.Exec)
+			fmt.Fprintf(w, "Args:\t%s\n", strings.Join(cs.Args, " "))
+			fmt.Fprintf(w, "Dir:\t%s\n", cs.Dir)
+			fmt.Fprintf(w, "Started:\t%s\n", cs.Started.Format(time.RFC3339))
+			if s := stats[cs.Name]; s != nil {
+				fmt.Fprintf(w, "Restarts:\t%d total, %d in the last 24h\n", s.TotalRestarts, len(recentRestarts(s.Restarts, time.Now())))
+				longest := s.LongestUptime
+				if state == "running" && time.Since(cs.Started) > longest {
+					longest = time.Since(cs.Started)
+				}
+				fmt.Fprintf(w, "Longest uptime:\t%s\n", longest.Round(time.Second))
+				if !s.LastCrash.IsZero() {
+					fmt.Fprintf(w, "Last crash:\t%s, exit code %s\n", s.LastCrash.Format(time.RFC3339), exitCodeString(s.LastExitCode))
+				}
+			}
+		}
+	}
+	return w.Flush()
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// childStats are the counters kept for a process across wrapper restarts,
+// shown by "wsw -a status".
+type childStats struct {
+	TotalRestarts int
+	// Restarts holds the restart times of the last 24 hours.
+	Restarts      []time.Time
+	LongestUptime time.Duration
+	LastCrash     time.Time
+	LastExitCode  int
+}
+
+// statsMu serializes updates of the stats file.
+var statsMu sync.Mutex
+
+func statsPath(config *Config) (string, error) {
+	dir, err := getStateDir(config)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "stats.json"), nil
+}
+
+func readStats(config *Config) (map[string]*childStats, error) {
+	path, err := statsPath(config)
+	if err != nil {
+		return nil, err
+	}
+	return readStatsFile(path)
+}
+
+func readStatsFile(path string) (map[string]*childStats, error) {
+	stats := map[string]*childStats{}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return stats, err
+	}
+	return stats, json.Unmarshal(data, &stats)
+}
+
+// updateStats applies update to the stats of the child and saves them.
+func (c *child) updateStats(update func(s *childStats)) {
+	statsMu.Lock()
+	defer statsMu.Unlock()
+	stats, _ := readStats(c.prg.Config)
+	s := stats[c.name]
+	if s == nil {
+		s = &childStats{}
+		stats[c.name] = s
+	}
+	update(s)
+	path, err := statsPath(c.prg.Config)
+	if err == nil {
+		var data []byte
+		if data, err = json.Marshal(stats); err == nil {
+			err = ioutil.WriteFile(path, data, 0644)
+		}
+	}
+	if err != nil {
+		logger.Warningf("Failed to save stats: %v", err)
+	}
+}
+
+// recordRestart counts a restart of the child.
+func (c *child) recordRestart() {
+	traceEvent(etwInfo, etwRestart, "Process %s restarting", c.label())
+	c.updateStats(func(s *childStats) {
+		now := time.Now()
+		s.TotalRestarts++
+		s.Restarts = append(recentRestarts(s.Restarts, now), now)
+	})
+}
+
+// recordExit records how long the child ran and, for a non-zero exit code,
+// the crash.
+func (c *child) recordExit(ran time.Duration, code int) {
+	c.updateStats(func(s *childStats) {
+		if ran > s.LongestUptime {
+			s.LongestUptime = ran
+		}
+		if code != 0 {
+			s.LastCrash, s.LastExitCode = time.Now(), code
+		}
+	})
+}
+
+// recentRestarts drops restarts older than 24 hours.
+func recentRestarts(restarts []time.Time, now time.Time) []time.Time {
+	var recent []time.Time
+	for _, t := range restarts {
+		if now.Sub(t) < 24*time.Hour {
+			recent = append(recent, t)
+		}
+	}
+	return recent
+}
+
+// exitCodeString shows NTSTATUS codes in hex, the way they are documented.
+func exitCodeString(code int) string {
+	if isCrash(code) {
+		return fmt.Sprintf("0x%08X", uint32(code))
+	}
+	return fmt.Sprint(code)
+}
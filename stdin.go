@@ -0,0 +1,30 @@
+package main
+
+import (
+	"io"
+	"os"
+)
+
+// setStdin makes w the stdin the pipe feeds, starting the pipe server with
+// the first run of the child.
+func (c *child) setStdin(w io.Writer) {
+	c.mu.Lock()
+	c.stdin = w
+	c.mu.Unlock()
+	c.stdinOnce.Do(func() { go c.serveStdin() })
+}
+
+// serveStdin feeds everything written to the wsw-<Name>-stdin named pipe to
+// the child's stdin. Processes other than the main one use
+// wsw-<Name>-<process>-stdin.
+func (c *child) serveStdin() {
+	path := pipePath(c.fullName() + "-stdin")
+	// Concurrently, so an attached console typing does not hold up others.
+	err := servePipeConcurrently(path, func(f *os.File) {
+		c.mu.Lock()
+		w := c.stdin
+		c.mu.Unlock()
+		io.Copy(w, f)
+	})
+	logger.Warningf("Failed to serve stdin pipe %q: %v", path, err)
+}
@@ -0,0 +1,19 @@
+package main
+
+import "time"
+
+// defaultStopTimeout is how long a child gets to exit before it is killed.
+const defaultStopTimeout = 10 * time.Second
+
+// stopTimeout is how long the child gets to exit once asked to: StopTimeout,
+// cut short in a container to what its runtime waits for.
+func (c *child) stopTimeout() time.Duration {
+	timeout := defaultStopTimeout
+	if t := c.process().StopTimeout; t > 0 {
+		timeout = time.Duration(t) * time.Second
+	}
+	if limit := containerStopWindow(); limit > 0 && timeout > limit {
+		timeout = limit
+	}
+	return timeout
+}
@@ -0,0 +1,74 @@
+//go:build !windows
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// stopSignals are the signals StopSignal may name.
+var stopSignals = map[string]syscall.Signal{
+	"SIGTERM": syscall.SIGTERM,
+	"SIGINT":  syscall.SIGINT,
+	"SIGQUIT": syscall.SIGQUIT,
+	"SIGHUP":  syscall.SIGHUP,
+	"SIGUSR1": syscall.SIGUSR1,
+	"SIGUSR2": syscall.SIGUSR2,
+}
+
+// defaultStopSignal is sent to children without a StopSignal: SIGTERM, or
+// the signal that stopped wsw running in the foreground.
+var defaultStopSignal = syscall.SIGTERM
+
+func parseStopSignal(name string) (syscall.Signal, error) {
+	if name == "" {
+		return defaultStopSignal, nil
+	}
+	name = strings.ToUpper(name)
+	if !strings.HasPrefix(name, "SIG") {
+		name = "SIG" + name
+	}
+	sig, ok := stopSignals[name]
+	if !ok {
+		return 0, fmt.Errorf("Unknown StopSignal %q, expected SIGTERM, SIGINT, SIGQUIT, SIGHUP, SIGUSR1 or SIGUSR2", name)
+	}
+	return sig, nil
+}
+
+func checkStopSignal(name string) error {
+	_, err := parseStopSignal(name)
+	return err
+}
+
+// stop sends StopSignal to the child's process group, waits StopTimeout for
+// the child to exit, then kills whatever of the group is left.
+func (c *child) stop() {
+	group, proc := c.group, c.proc
+	if proc == nil {
+		return
+	}
+	_, exited := c.current()
+	sig, _ := parseStopSignal(c.process().StopSignal)
+	var err error
+	if group != nil {
+		err = syscall.Kill(-group.pgid, sig)
+	} else {
+		err = proc.Signal(sig)
+	}
+	if err == nil {
+		timeout := c.stopTimeout()
+		select {
+		case <-exited:
+		case <-time.After(timeout):
+			logger.Warningf("%s did not exit within %v, killing it", c.label(), timeout)
+		}
+	}
+	if group != nil {
+		group.terminate()
+	} else {
+		proc.Kill()
+	}
+}
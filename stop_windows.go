@@ -0,0 +1,32 @@
+package main
+
+import "time"
+
+// checkStopSignal accepts any StopSignal, which only Unix uses.
+func checkStopSignal(name string) error {
+	return nil
+}
+
+// stop ends the child and the processes it started. When the system or the
+// console is shutting down, the child got the shutdown event itself and has
+// its stop timeout to exit before it is killed.
+func (c *child) stop() {
+	group, proc := c.group, c.proc
+	if proc == nil {
+		return
+	}
+	if c.prg.shuttingDown {
+		_, exited := c.current()
+		timeout := c.stopTimeout()
+		select {
+		case <-exited:
+		case <-time.After(timeout):
+			logger.Warningf("%s did not exit within %v, killing it", c.label(), timeout)
+		}
+	}
+	if group != nil {
+		group.terminate()
+	} else {
+		proc.Kill()
+	}
+}
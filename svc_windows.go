@@ -0,0 +1,163 @@
+package main
+
+import (
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/svc"
+)
+
+// Power broadcast event types.
+const (
+	pbtAPMSuspend         = 0x4
+	pbtAPMResumeSuspend   = 0x7
+	pbtAPMResumeAutomatic = 0x12
+)
+
+// sessionEvents names the session change event types.
+var sessionEvents = map[uint32]string{
+	windows.WTS_CONSOLE_CONNECT:        "console-connect",
+	windows.WTS_CONSOLE_DISCONNECT:     "console-disconnect",
+	windows.WTS_REMOTE_CONNECT:         "remote-connect",
+	windows.WTS_REMOTE_DISCONNECT:      "remote-disconnect",
+	windows.WTS_SESSION_LOGON:          "logon",
+	windows.WTS_SESSION_LOGOFF:         "logoff",
+	windows.WTS_SESSION_LOCK:           "lock",
+	windows.WTS_SESSION_UNLOCK:         "unlock",
+	windows.WTS_SESSION_REMOTE_CONTROL: "remote-control",
+}
+
+// serviceHandler answers the SCM in place of the service library, so the
+// service can report how it ended.
+type serviceHandler struct {
+	prg *program
+}
+
+// runService runs prg as a Windows service until it is stopped or ends on
+// its own.
+func runService(prg *program) error {
+	return svc.Run(prg.Name, &serviceHandler{prg: prg})
+}
+
+func (h *serviceHandler) Execute(args []string, r <-chan svc.ChangeRequest, changes chan<- svc.Status) (bool, uint32) {
+	cmdsAccepted := svc.AcceptStop | svc.AcceptShutdown | svc.AcceptPauseAndContinue
+	if !inContainer() {
+		// Containers have neither power events nor interactive sessions.
+		cmdsAccepted |= svc.AcceptPowerEvent | svc.AcceptSessionChange
+	}
+	p := h.prg
+	changes <- svc.Status{State: svc.StartPending}
+	if err := p.Start(args...); err != nil {
+		logger.Error(err)
+		return true, exitStartFailed
+	}
+	if p.StartTimeout > 0 && p.Mode != "scheduled" && !h.awaitStart(changes) {
+		logger.Warningf("%s not ready after %d seconds", p.DisplayName, p.StartTimeout)
+		p.Stop()
+		return true, exitNotReady
+	}
+	changes <- svc.Status{State: svc.Running, Accepts: cmdsAccepted}
+	for {
+		select {
+		case c := <-r:
+			switch c.Cmd {
+			case svc.Interrogate:
+				changes <- c.CurrentStatus
+			case svc.Pause:
+				changes <- svc.Status{State: svc.PausePending, Accepts: cmdsAccepted}
+				p.pause()
+				changes <- svc.Status{State: svc.Paused, Accepts: cmdsAccepted}
+			case svc.Continue:
+				changes <- svc.Status{State: svc.ContinuePending, Accepts: cmdsAccepted}
+				p.resume()
+				changes <- svc.Status{State: svc.Running, Accepts: cmdsAccepted}
+			case svc.PowerEvent:
+				switch c.EventType {
+				case pbtAPMSuspend:
+					p.sleep()
+				case pbtAPMResumeSuspend, pbtAPMResumeAutomatic:
+					p.wake()
+				}
+			case svc.SessionChange:
+				if event, ok := sessionEvents[c.EventType]; ok && c.Context != 0 {
+					ctx := c.Context
+					n := (*windows.WTSSESSION_NOTIFICATION)(*(*unsafe.Pointer)(unsafe.Pointer(&ctx)))
+					go p.sessionChange(event, n.SessionID)
+				}
+			case svc.Stop:
+				changes <- svc.Status{State: svc.StopPending}
+				p.Stop()
+				return false, 0
+			case svc.Shutdown:
+				p.shuttingDown = true
+				changes <- svc.Status{State: svc.StopPending, WaitHint: h.stopHint()}
+				p.Stop()
+				return false, 0
+			default:
+				if c.Cmd >= 128 && c.Cmd <= 255 {
+					go p.customControl(uint32(c.Cmd))
+				}
+			}
+		case <-p.done:
+			changes <- svc.Status{State: svc.StopPending}
+			p.Stop()
+			// A non-zero code is reported as service specific, so the SCM
+			// shows why the service ended.
+			return p.exitCode != 0, uint32(p.exitCode)
+		}
+	}
+}
+
+// stopHint is the wait hint, in milliseconds, for stopping at shutdown: the
+// longest stop timeout of the children.
+func (h *serviceHandler) stopHint() uint32 {
+	var longest time.Duration
+	for _, c := range h.prg.children {
+		if t := c.stopTimeout(); t > longest {
+			longest = t
+		}
+	}
+	return uint32(longest / time.Millisecond)
+}
+
+// awaitStart reports START_PENDING, with StartTimeout as the wait hint,
+// until every essential child is ready. It returns false on timeout.
+func (h *serviceHandler) awaitStart(changes chan<- svc.Status) bool {
+	p := h.prg
+	timeout := time.Duration(p.StartTimeout) * time.Second
+	hint := uint32(timeout / time.Millisecond)
+	changes <- svc.Status{State: svc.StartPending, WaitHint: hint}
+
+	ready := make(chan struct{})
+	go func() {
+		for _, c := range p.children {
+			if !c.essential() {
+				continue
+			}
+			r, _ := c.current()
+			select {
+			case <-r:
+			case <-p.exit:
+				return
+			}
+		}
+		close(ready)
+	}()
+	deadline := time.After(timeout)
+	tick := time.NewTicker(time.Second)
+	defer tick.Stop()
+	for checkpoint := uint32(1); ; checkpoint++ {
+		select {
+		case <-ready:
+			return true
+		case <-p.done:
+			// The main loop reports how the service ended.
+			return true
+		case <-deadline:
+			return false
+		case <-tick.C:
+			changes <- svc.Status{State: svc.StartPending, CheckPoint: checkpoint, WaitHint: hint}
+		}
+	}
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/kardianos/osext"
+)
+
+// unitDir is where wsw installs its systemd units.
+const unitDir = "/etc/systemd/system"
+
+// unitMarker starts every unit wsw writes, telling them apart from others.
+const unitMarker = "# Written by wsw, change the wsw config and run wsw -a sync instead."
+
+func unitName(name string) string {
+	return name + ".service"
+}
+
+func unitPath(name string) string {
+	return filepath.Join(unitDir, unitName(name))
+}
+
+// systemctl runs systemctl, returning its output in the error if it fails.
+func systemctl(args ...string) error {
+	out, err := exec.Command("systemctl", args...).CombinedOutput()
+	if err != nil {
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			return fmt.Errorf("systemctl %s: %s", strings.Join(args, " "), msg)
+		}
+		return fmt.Errorf("systemctl %s: %v", strings.Join(args, " "), err)
+	}
+	return nil
+}
+
+// unitEscape escapes the specifiers systemd expands in s.
+func unitEscape(s string) string {
+	return strings.NewReplacer("%", "%%", "\n", " ").Replace(s)
+}
+
+// unitQuote quotes a path for an Exec line, which also expands variables.
+func unitQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "%", "%%", "$", "$$").Replace(s) + `"`
+}
+
+// renderUnit returns the systemd unit running exe for the config. wsw stops
+// its processes itself and adopts them after a crash, so systemd only ever
+// signals wsw.
+func renderUnit(conf *Config, exe string) []byte {
+	var b bytes.Buffer
+	fmt.Fprintln(&b, unitMarker)
+	fmt.Fprintln(&b, "[Unit]")
+	fmt.Fprintf(&b, "Description=%s\n", unitEscape(conf.DisplayName))
+	if conf.Description != "" {
+		fmt.Fprintf(&b, "X-Description=%s\n", unitEscape(conf.Description))
+	}
+	after := []string{"network-online.target"}
+	var requires []string
+	for _, dep := range conf.Dependencies {
+		if !strings.Contains(dep, ".") {
+			dep = unitName(dep)
+		}
+		after = append(after, dep)
+		requires = append(requires, dep)
+	}
+	fmt.Fprintln(&b, "Wants=network-online.target")
+	fmt.Fprintf(&b, "After=%s\n", strings.Join(after, " "))
+	if len(requires) != 0 {
+		fmt.Fprintf(&b, "Requires=%s\n", strings.Join(requires, " "))
+	}
+	fmt.Fprintln(&b)
+	fmt.Fprintln(&b, "[Service]")
+	fmt.Fprintln(&b, "Type=simple")
+	fmt.Fprintf(&b, "ExecStart=%s\n", unitQuote(exe))
+	fmt.Fprintf(&b, "WorkingDirectory=%s\n", unitEscape(filepath.Dir(exe)))
+	fmt.Fprintln(&b, "KillMode=process")
+	fmt.Fprintln(&b)
+	fmt.Fprintln(&b, "[Install]")
+	fmt.Fprintln(&b, "WantedBy=multi-user.target")
+	return b.Bytes()
+}
+
+// readUnit returns the settings of a unit file, by key, if wsw wrote it.
+func readUnit(path string) (map[string][]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(data, []byte(unitMarker)) {
+		return nil, fmt.Errorf("%s was not written by wsw", path)
+	}
+	keys := map[string][]string{}
+	for _, line := range strings.Split(string(data), "\n") {
+		kv := strings.SplitN(strings.TrimSpace(line), "=", 2)
+		if len(kv) == 2 && !strings.HasPrefix(kv[0], "#") {
+			keys[kv[0]] = append(keys[kv[0]], strings.Replace(kv[1], "%%", "%", -1))
+		}
+	}
+	return keys, nil
+}
+
+// unitExec returns the executable of an ExecStart line.
+func unitExec(execStart string) string {
+	if strings.HasPrefix(execStart, `"`) {
+		if i := strings.Index(execStart[1:], `"`); i >= 0 {
+			return strings.NewReplacer(`\\`, `\`, `\"`, `"`, "$$", "$").Replace(execStart[1 : i+1])
+		}
+	}
+	return strings.Fields(execStart + " ")[0]
+}
+
+// installService writes and enables the unit of the service.
+func installService(conf *Config) error {
+	path := unitPath(conf.Name)
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("Init already exists: %s", path)
+	}
+	exe, err := osext.Executable()
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path, renderUnit(conf, exe), 0644); err != nil {
+		return err
+	}
+	if err := systemctl("daemon-reload"); err != nil {
+		return err
+	}
+	return systemctl("enable", unitName(conf.Name))
+}
+
+// uninstallService disables and removes the unit of the service.
+func uninstallService(name string) error {
+	path := unitPath(name)
+	if _, err := readUnit(path); err != nil {
+		return err
+	}
+	if err := systemctl("disable", unitName(name)); err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil {
+		return err
+	}
+	return systemctl("daemon-reload")
+}
+
+func queryServiceConfig(name string) (*scmConfig, error) {
+	keys, err := readUnit(unitPath(name))
+	if err != nil {
+		return nil, err
+	}
+	first := func(key string) string {
+		if v := keys[key]; len(v) != 0 {
+			return v[len(v)-1]
+		}
+		return ""
+	}
+	c := &scmConfig{
+		DisplayName: first("Description"),
+		Description: first("X-Description"),
+		BinaryPath:  unitExec(first("ExecStart")),
+		Account:     first("User"),
+	}
+	for _, v := range keys["Requires"] {
+		for _, dep := range strings.Fields(v) {
+			c.Dependencies = append(c.Dependencies, strings.TrimSuffix(dep, ".service"))
+		}
+	}
+	// is-enabled exits non-zero for anything but enabled.
+	out, _ := exec.Command("systemctl", "is-enabled", unitName(name)).Output()
+	c.StartType = strings.TrimSpace(string(out))
+	return c, nil
+}
+
+func queryServiceState(name string) (string, error) {
+	out, err := exec.Command("systemctl", "show", "--property=LoadState,ActiveState", unitName(name)).Output()
+	if err != nil {
+		return "", fmt.Errorf("systemctl show %s: %v", unitName(name), err)
+	}
+	props := map[string]string{}
+	for _, line := range strings.Split(string(out), "\n") {
+		if kv := strings.SplitN(line, "=", 2); len(kv) == 2 {
+			props[kv[0]] = kv[1]
+		}
+	}
+	if props["LoadState"] == "not-found" {
+		return "", fmt.Errorf("Service %s is not installed", name)
+	}
+	switch props["ActiveState"] {
+	case "active", "reloading":
+		return "running", nil
+	case "activating":
+		return "starting", nil
+	case "deactivating":
+		return "stopping", nil
+	case "inactive":
+		return "stopped", nil
+	}
+	return props["ActiveState"], nil
+}
+
+// listWswServices returns the units wsw installed.
+func listWswServices() ([]wswService, error) {
+	paths, err := filepath.Glob(filepath.Join(unitDir, "*.service"))
+	if err != nil {
+		return nil, err
+	}
+	var services []wswService
+	for _, path := range paths {
+		name := strings.TrimSuffix(filepath.Base(path), ".service")
+		c, err := queryServiceConfig(name)
+		if err != nil {
+			continue
+		}
+		services = append(services, wswService{Name: name, Exe: c.BinaryPath, Dependencies: c.Dependencies})
+	}
+	return services, nil
+}
+
+// startService starts the service; systemctl waits until it runs.
+func startService(name string) error {
+	return systemctl("start", unitName(name))
+}
+
+// stopService stops the service; systemctl waits until it stopped.
+func stopService(name string) error {
+	return systemctl("stop", unitName(name))
+}
+
+func restartService(name string) error {
+	return systemctl("restart", unitName(name))
+}
+
+// syncService rewrites the unit when DisplayName or Description changed,
+// returning whether they did.
+func syncService(conf *Config) (bool, error) {
+	c, err := queryServiceConfig(conf.Name)
+	if err != nil {
+		return false, err
+	}
+	if c.DisplayName == conf.DisplayName && c.Description == conf.Description {
+		return false, nil
+	}
+	if err := ioutil.WriteFile(unitPath(conf.Name), renderUnit(conf, c.BinaryPath), 0644); err != nil {
+		return false, err
+	}
+	return true, systemctl("daemon-reload")
+}
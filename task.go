@@ -0,0 +1,11 @@
+package main
+
+// asTask is set by --as-task: install, uninstall, start and stop then use a
+// Scheduled Task running "wsw -a run" instead of a service, which needs no
+// administrator. taskTrigger, set by --at, is "logon" (default) to run it
+// when the current user logs on or "startup" to run it as LocalSystem at
+// boot.
+var (
+	asTask      bool
+	taskTrigger string
+)
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/kardianos/osext"
+	"golang.org/x/sys/windows"
+)
+
+var procFreeConsole = kernel32.NewProc("FreeConsole")
+
+// taskFolder holds the Scheduled Tasks wsw registers, one per service.
+const taskFolder = `\wsw\`
+
+func taskName(name string) string {
+	return taskFolder + name
+}
+
+// schtasks runs schtasks.exe, returning its output in the error if it fails.
+func schtasks(args ...string) error {
+	out, err := exec.Command("schtasks", args...).CombinedOutput()
+	if err != nil {
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			return fmt.Errorf("schtasks %s: %s", args[0], msg)
+		}
+		return fmt.Errorf("schtasks %s: %v", args[0], err)
+	}
+	return nil
+}
+
+// xmlText escapes s for an XML element.
+func xmlText(s string) string {
+	var b bytes.Buffer
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// renderTask returns the task definition running exe in the foreground at
+// logon of account, or at boot as LocalSystem. wsw restarts the children
+// itself; the task only comes back if wsw fails as a whole.
+func renderTask(conf *Config, exe, trigger, account string) []byte {
+	var b bytes.Buffer
+	fmt.Fprintln(&b, `<?xml version="1.0" encoding="UTF-16"?>`)
+	fmt.Fprintln(&b, `<Task version="1.2" xmlns="http://schemas.microsoft.com/windows/2004/02/mit/task">`)
+	fmt.Fprintln(&b, `  <RegistrationInfo>`)
+	desc := conf.DisplayName
+	if conf.Description != "" {
+		desc += ": " + conf.Description
+	}
+	fmt.Fprintf(&b, "    <Description>%s</Description>\n", xmlText(desc))
+	fmt.Fprintf(&b, "    <URI>%s</URI>\n", xmlText(taskName(conf.Name)))
+	fmt.Fprintln(&b, `  </RegistrationInfo>`)
+	fmt.Fprintln(&b, `  <Triggers>`)
+	if trigger == "startup" {
+		fmt.Fprintln(&b, `    <BootTrigger><Enabled>true</Enabled></BootTrigger>`)
+	} else {
+		fmt.Fprintf(&b, "    <LogonTrigger><Enabled>true</Enabled><UserId>%s</UserId></LogonTrigger>\n", xmlText(account))
+	}
+	fmt.Fprintln(&b, `  </Triggers>`)
+	fmt.Fprintln(&b, `  <Principals>`)
+	fmt.Fprintln(&b, `    <Principal id="Author">`)
+	if trigger == "startup" {
+		fmt.Fprintln(&b, `      <UserId>S-1-5-18</UserId>`)
+		fmt.Fprintln(&b, `      <RunLevel>HighestAvailable</RunLevel>`)
+	} else {
+		fmt.Fprintf(&b, "      <UserId>%s</UserId>\n", xmlText(account))
+		fmt.Fprintln(&b, `      <LogonType>InteractiveToken</LogonType>`)
+		fmt.Fprintln(&b, `      <RunLevel>LeastPrivilege</RunLevel>`)
+	}
+	fmt.Fprintln(&b, `    </Principal>`)
+	fmt.Fprintln(&b, `  </Principals>`)
+	fmt.Fprintln(&b, `  <Settings>`)
+	fmt.Fprintln(&b, `    <MultipleInstancesPolicy>IgnoreNew</MultipleInstancesPolicy>`)
+	fmt.Fprintln(&b, `    <DisallowStartIfOnBatteries>false</DisallowStartIfOnBatteries>`)
+	fmt.Fprintln(&b, `    <StopIfGoingOnBatteries>false</StopIfGoingOnBatteries>`)
+	fmt.Fprintln(&b, `    <ExecutionTimeLimit>PT0S</ExecutionTimeLimit>`)
+	fmt.Fprintln(&b, `    <RestartOnFailure><Interval>PT1M</Interval><Count>3</Count></RestartOnFailure>`)
+	fmt.Fprintln(&b, `  </Settings>`)
+	fmt.Fprintln(&b, `  <Actions Context="Author">`)
+	fmt.Fprintln(&b, `    <Exec>`)
+	fmt.Fprintf(&b, "      <Command>%s</Command>\n", xmlText(exe))
+	fmt.Fprintln(&b, `      <Arguments>-a run --as-task</Arguments>`)
+	fmt.Fprintf(&b, "      <WorkingDirectory>%s</WorkingDirectory>\n", xmlText(filepath.Dir(exe)))
+	fmt.Fprintln(&b, `    </Exec>`)
+	fmt.Fprintln(&b, `  </Actions>`)
+	fmt.Fprintln(&b, `</Task>`)
+	return b.Bytes()
+}
+
+// installTask registers the Scheduled Task of the service.
+func installTask(conf *Config, trigger string) error {
+	switch trigger {
+	case "", "logon":
+		trigger = "logon"
+	case "startup":
+	default:
+		return fmt.Errorf("Unknown --at %q, expected logon or startup", trigger)
+	}
+	if exec.Command("schtasks", "/Query", "/TN", taskName(conf.Name)).Run() == nil {
+		return fmt.Errorf("Task %s already exists", taskName(conf.Name))
+	}
+	exe, err := osext.Executable()
+	if err != nil {
+		return err
+	}
+	u, err := user.Current()
+	if err != nil {
+		return err
+	}
+	// schtasks takes the definition as UTF-16 with a byte order mark.
+	var data bytes.Buffer
+	for _, c := range utf16.Encode([]rune("\ufeff" + string(renderTask(conf, exe, trigger, u.Username)))) {
+		binary.Write(&data, binary.LittleEndian, c)
+	}
+	f, err := ioutil.TempFile("", "wsw-task-*.xml")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(data.Bytes())
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if err := schtasks("/Create", "/TN", taskName(conf.Name), "/XML", f.Name()); err != nil {
+		return err
+	}
+	infof("Registered task %s, run at %s", taskName(conf.Name), trigger)
+	return nil
+}
+
+// stopTask asks the wrapper the task runs to stop its processes and exit,
+// ending the task outright if it does not answer.
+func stopTask(name string) error {
+	err := sendControl(name, os.Stdout, "stop")
+	if err == errNotRunning {
+		return nil
+	} else if err != nil {
+		return schtasks("/End", "/TN", taskName(name))
+	}
+	return nil
+}
+
+// runTaskAction carries out action, the wrapper being or running a
+// Scheduled Task instead of a service.
+func runTaskAction(prg *program, action string) {
+	var err error
+	switch action {
+	case "run":
+		if err = newTaskLogger(prg.Config); err == nil {
+			// The task is started without a window of its own to show.
+			procFreeConsole.Call()
+			runForeground(prg)
+			return
+		}
+	case "install":
+		if err = installTask(prg.Config, taskTrigger); err == nil {
+			err = addNetworkConfig(prg.Config)
+		}
+	case "uninstall":
+		removeNetworkConfig(prg.Config)
+		if err = stopTask(prg.Name); err == nil {
+			err = schtasks("/Delete", "/TN", taskName(prg.Name), "/F")
+		}
+	case "start":
+		err = schtasks("/Run", "/TN", taskName(prg.Name))
+	case "stop":
+		err = stopTask(prg.Name)
+	case "restart":
+		if err = stopTask(prg.Name); err == nil {
+			err = schtasks("/Run", "/TN", taskName(prg.Name))
+		}
+	default:
+		err = fmt.Errorf("Unknown action %q with --as-task, expected install, uninstall, start, stop, restart or run", action)
+	}
+	if err != nil {
+		fatal(err)
+	}
+}
+
+// newTaskLogger makes wsw write its own messages to wsw.log in the state
+// directory, as a task has no event log source or console.
+func newTaskLogger(config *Config) error {
+	dir, err := getStateDir(config)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filepath.Join(dir, "wsw.log"), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	logger = textLogger{l: log.New(f, "", log.LstdFlags)}
+	return nil
+}
+
+// elevated tells whether wsw runs with administrator rights, which
+// creating a service takes.
+func elevated() bool {
+	return windows.GetCurrentProcessToken().IsElevated()
+}
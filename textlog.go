@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// textLogger logs lines of text, usually to stderr. With priority set every
+// line starts with the syslog priority, which the systemd journal picks up,
+// and the journal adds the time; otherwise lines are timestamped.
+type textLogger struct {
+	l        *log.Logger
+	priority bool
+}
+
+// Syslog priorities.
+const (
+	priErr     = 3
+	priWarning = 4
+	priInfo    = 6
+)
+
+func (j textLogger) output(priority int, s string) error {
+	if j.priority {
+		s = fmt.Sprintf("<%d>", priority) + strings.Replace(s, "\n", fmt.Sprintf("\n<%d>", priority), -1)
+	}
+	return j.l.Output(3, s)
+}
+
+func (j textLogger) Error(v ...interface{}) error   { return j.output(priErr, fmt.Sprint(v...)) }
+func (j textLogger) Warning(v ...interface{}) error { return j.output(priWarning, fmt.Sprint(v...)) }
+func (j textLogger) Info(v ...interface{}) error    { return j.output(priInfo, fmt.Sprint(v...)) }
+
+func (j textLogger) Errorf(format string, a ...interface{}) error {
+	return j.output(priErr, fmt.Sprintf(format, a...))
+}
+
+func (j textLogger) Warningf(format string, a ...interface{}) error {
+	return j.output(priWarning, fmt.Sprintf(format, a...))
+}
+
+func (j textLogger) Infof(format string, a ...interface{}) error {
+	return j.output(priInfo, fmt.Sprintf(format, a...))
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+type processEntry struct {
+	ppid int
+	name string
+}
+
+// procSample is the resource usage of a process at one point in time.
+type procSample struct {
+	at         time.Time
+	cpu        time.Duration
+	workingSet uint64
+	private    uint64
+	handles    int
+	read       uint64
+	written    uint64
+}
+
+// topRow is a process of the tree shown by "wsw -a top".
+type topRow struct {
+	pid   int
+	depth int
+	name  string
+}
+
+// processTree returns the children recorded in the run state and all their
+// descendants, each followed by its own children.
+func processTree(config *Config) ([]topRow, error) {
+	st, err := readRunState(config)
+	if err != nil {
+		return nil, fmt.Errorf("No run state, is the service running? %v", err)
+	}
+	return runStateTree(st)
+}
+
+// runStateTree returns the live children of st and all their descendants.
+func runStateTree(st *runState) ([]topRow, error) {
+	procs, err := listProcesses()
+	if err != nil {
+		return nil, err
+	}
+	kids := map[int][]int{}
+	for pid, p := range procs {
+		if pid != p.ppid {
+			kids[p.ppid] = append(kids[p.ppid], pid)
+		}
+	}
+	var rows []topRow
+	var add func(pid, depth int, name string)
+	add = func(pid, depth int, name string) {
+		rows = append(rows, topRow{pid: pid, depth: depth, name: name})
+		sort.Ints(kids[pid])
+		for _, k := range kids[pid] {
+			add(k, depth+1, procs[k].name)
+		}
+	}
+	for _, cs := range st.Children {
+		// Skip a child that is gone, or whose PID now belongs to another
+		// process.
+		if _, ok := procs[cs.PID]; !ok || cs.PID == 0 {
+			continue
+		}
+		if created, _, err := processInfo(cs.PID); err != nil || !created.Equal(cs.Created) {
+			continue
+		}
+		add(cs.PID, 0, cs.Name)
+	}
+	return rows, nil
+}
+
+// top redraws the CPU, memory, handle and I/O use of the service's process
+// tree every second until interrupted.
+func top(config *Config) error {
+	enableColor()
+	last := map[int]procSample{}
+	for {
+		rows, err := processTree(config)
+		if err != nil {
+			return err
+		}
+		samples := map[int]procSample{}
+		var b strings.Builder
+		fmt.Fprintf(&b, "%s  %s  %d processes\n\n", config.Name, time.Now().Format("15:04:05"), len(rows))
+		w := tabwriter.NewWriter(&b, 0, 4, 2, ' ', tabwriter.AlignRight)
+		fmt.Fprintf(w, "PID\tCPU%%\tWorking set\tPrivate\tHandles\tRead/s\tWrite/s\t Name\n")
+		for _, r := range rows {
+			s, err := sampleProcess(r.pid)
+			if err != nil {
+				continue
+			}
+			samples[r.pid] = s
+			name := strings.Repeat("  ", r.depth) + r.name
+			prev, ok := last[r.pid]
+			if !ok {
+				fmt.Fprintf(w, "%d\t-\t%s\t%s\t%d\t-\t-\t %s\n", r.pid, formatBytes(s.workingSet), formatBytes(s.private), s.handles, name)
+				continue
+			}
+			secs := s.at.Sub(prev.at).Seconds()
+			cpu := float64(s.cpu-prev.cpu) / float64(time.Second) / secs / float64(runtime.NumCPU()) * 100
+			fmt.Fprintf(w, "%d\t%.1f\t%s\t%s\t%d\t%s\t%s\t %s\n", r.pid, cpu,
+				formatBytes(s.workingSet), formatBytes(s.private), s.handles,
+				formatBytes(uint64(float64(s.read-prev.read)/secs)), formatBytes(uint64(float64(s.written-prev.written)/secs)), name)
+		}
+		w.Flush()
+		if len(rows) == 0 {
+			b.WriteString("\nNo processes running\n")
+		}
+		// Move home and clear the screen before each frame.
+		fmt.Fprint(os.Stdout, "\x1b[H\x1b[2J"+b.String())
+		last = samples
+		time.Sleep(time.Second)
+	}
+}
+
+// formatBytes prints n in the largest binary unit it fills.
+func formatBytes(n uint64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := uint64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
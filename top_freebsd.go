@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// listProcesses returns the parent PID and name of every process.
+func listProcesses() (map[int]processEntry, error) {
+	entries, err := ps()
+	if err != nil {
+		return nil, err
+	}
+	procs := map[int]processEntry{}
+	for _, e := range entries {
+		procs[e.pid] = processEntry{ppid: e.ppid, name: e.name}
+	}
+	return procs, nil
+}
+
+// sampleProcess reads the resource usage of a process. ps reports no
+// private memory or I/O byte counts, which stay 0.
+func sampleProcess(pid int) (procSample, error) {
+	s := procSample{at: time.Now()}
+	out, err := exec.Command("ps", "-o", "rss=,cputime=", "-p", strconv.Itoa(pid)).Output()
+	if err != nil {
+		return s, fmt.Errorf("ps: %v", err)
+	}
+	f := strings.Fields(string(out))
+	if len(f) != 2 {
+		return s, fmt.Errorf("Unexpected ps output %q", out)
+	}
+	// In kB.
+	rss, _ := strconv.ParseUint(f[0], 10, 64)
+	s.workingSet = rss * 1024
+	s.cpu = parseCPUTime(f[1])
+	if out, err := exec.Command("procstat", "-h", "-f", strconv.Itoa(pid)).Output(); err == nil {
+		s.handles = bytes.Count(out, []byte("\n"))
+	}
+	return s, nil
+}
+
+// parseCPUTime parses a ps cputime, minutes and seconds such as
+// "127:03.57".
+func parseCPUTime(s string) time.Duration {
+	kv := strings.SplitN(s, ":", 2)
+	if len(kv) != 2 {
+		return 0
+	}
+	mins, _ := strconv.Atoi(kv[0])
+	secs, _ := strconv.ParseFloat(kv[1], 64)
+	return time.Duration(mins)*time.Minute + time.Duration(secs*float64(time.Second))
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// listProcesses returns the parent PID and name of every process.
+func listProcesses() (map[int]processEntry, error) {
+	dirs, err := ioutil.ReadDir("/proc")
+	if err != nil {
+		return nil, err
+	}
+	procs := map[int]processEntry{}
+	for _, d := range dirs {
+		pid, err := strconv.Atoi(d.Name())
+		if err != nil {
+			continue
+		}
+		if st, err := readProcStat(pid); err == nil {
+			procs[pid] = processEntry{ppid: st.ppid, name: st.name}
+		}
+	}
+	return procs, nil
+}
+
+// sampleProcess reads the resource usage of a process.
+func sampleProcess(pid int) (procSample, error) {
+	s := procSample{at: time.Now()}
+	st, err := readProcStat(pid)
+	if err != nil {
+		return s, err
+	}
+	dir := filepath.Join("/proc", strconv.Itoa(pid))
+	s.cpu = time.Duration(st.utime+st.stime) * time.Second / clockTicks
+	s.workingSet = st.rss * uint64(os.Getpagesize())
+	if status, err := readProcKeys(filepath.Join(dir, "status")); err == nil {
+		// In kB.
+		s.private = status["RssAnon"] * 1024
+	}
+	if fds, err := ioutil.ReadDir(filepath.Join(dir, "fd")); err == nil {
+		s.handles = len(fds)
+	}
+	if io, err := readProcKeys(filepath.Join(dir, "io")); err == nil {
+		s.read, s.written = io["rchar"], io["wchar"]
+	}
+	return s, nil
+}
+
+// readProcKeys reads the "key: value" lines of a /proc file, keeping the
+// leading number of each value.
+func readProcKeys(path string) (map[string]uint64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	keys := map[string]uint64{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		kv := strings.SplitN(scanner.Text(), ":", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		if fields := strings.Fields(kv[1]); len(fields) != 0 {
+			keys[kv[0]], _ = strconv.ParseUint(fields[0], 10, 64)
+		}
+	}
+	return keys, scanner.Err()
+}
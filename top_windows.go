@@ -0,0 +1,84 @@
+package main
+
+import (
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var (
+	procK32GetProcessMemoryInfo = kernel32.NewProc("K32GetProcessMemoryInfo")
+	procGetProcessHandleCount   = kernel32.NewProc("GetProcessHandleCount")
+	procGetProcessIoCounters    = kernel32.NewProc("GetProcessIoCounters")
+)
+
+// PROCESS_MEMORY_COUNTERS
+type processMemoryCounters struct {
+	cb                         uint32
+	PageFaultCount             uint32
+	PeakWorkingSetSize         uintptr
+	WorkingSetSize             uintptr
+	QuotaPeakPagedPoolUsage    uintptr
+	QuotaPagedPoolUsage        uintptr
+	QuotaPeakNonPagedPoolUsage uintptr
+	QuotaNonPagedPoolUsage     uintptr
+	PagefileUsage              uintptr
+	PeakPagefileUsage          uintptr
+}
+
+// IO_COUNTERS
+type ioCounters struct {
+	ReadOperationCount, WriteOperationCount, OtherOperationCount uint64
+	ReadTransferCount, WriteTransferCount, OtherTransferCount    uint64
+}
+
+// listProcesses returns the parent PID and image name of every process.
+func listProcesses() (map[int]processEntry, error) {
+	snap, err := windows.CreateToolhelp32Snapshot(windows.TH32CS_SNAPPROCESS, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer windows.CloseHandle(snap)
+	procs := map[int]processEntry{}
+	e := windows.ProcessEntry32{Size: uint32(unsafe.Sizeof(windows.ProcessEntry32{}))}
+	for err = windows.Process32First(snap, &e); err == nil; err = windows.Process32Next(snap, &e) {
+		procs[int(e.ProcessID)] = processEntry{ppid: int(e.ParentProcessID), name: windows.UTF16ToString(e.ExeFile[:])}
+	}
+	return procs, nil
+}
+
+// sampleProcess reads the resource usage of a process.
+func sampleProcess(pid int) (procSample, error) {
+	s := procSample{at: time.Now()}
+	h, err := windows.OpenProcess(windows.PROCESS_QUERY_LIMITED_INFORMATION, false, uint32(pid))
+	if err != nil {
+		return s, err
+	}
+	defer windows.CloseHandle(h)
+	var created, exited, kernel, user windows.Filetime
+	if err := windows.GetProcessTimes(h, &created, &exited, &kernel, &user); err != nil {
+		return s, err
+	}
+	s.cpu = filetimeDuration(kernel) + filetimeDuration(user)
+	var mem processMemoryCounters
+	mem.cb = uint32(unsafe.Sizeof(mem))
+	if r, _, _ := procK32GetProcessMemoryInfo.Call(uintptr(h), uintptr(unsafe.Pointer(&mem)), uintptr(mem.cb)); r != 0 {
+		s.workingSet, s.private = uint64(mem.WorkingSetSize), uint64(mem.PagefileUsage)
+	}
+	var handles uint32
+	if r, _, _ := procGetProcessHandleCount.Call(uintptr(h), uintptr(unsafe.Pointer(&handles))); r != 0 {
+		s.handles = int(handles)
+	}
+	var io ioCounters
+	if r, _, _ := procGetProcessIoCounters.Call(uintptr(h), uintptr(unsafe.Pointer(&io))); r != 0 {
+		s.read, s.written = io.ReadTransferCount, io.WriteTransferCount
+	}
+	return s, nil
+}
+
+// filetimeDuration converts a Filetime holding a duration in 100ns units;
+// Filetime.Nanoseconds would subtract the 1601 epoch.
+func filetimeDuration(ft windows.Filetime) time.Duration {
+	return time.Duration(int64(ft.HighDateTime)<<32+int64(ft.LowDateTime)) * 100
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+// tracing, set by -v or --trace on the command line or among the service
+// start parameters, logs every step of starting the children.
+var tracing bool
+
+// configSource tells where loadConfig found the config, for the trace.
+var configSource string
+
+// tracef logs a step of the start in trace mode, to the service log once
+// there is one.
+func tracef(format string, a ...interface{}) {
+	if !tracing {
+		return
+	}
+	if logger != nil {
+		logger.Infof("trace: "+format, a...)
+		return
+	}
+	emit(os.Stderr, colorGray, "trace: ", fmt.Sprintf(format, a...))
+}
+
+// traceArgs turns on tracing when the service start parameters ask for it.
+func traceArgs(args []string) {
+	for _, a := range args {
+		switch a {
+		case "-v", "-trace", "--trace":
+			tracing = true
+		}
+	}
+}
+
+// traceCommand logs what CreateProcess is about to be called with.
+func (c *child) traceCommand(cmd *exec.Cmd) {
+	if !tracing {
+		return
+	}
+	tracef("%s: application %q", c.label(), cmd.Path)
+	tracef("%s: command line %s", c.label(), commandLine(cmd))
+	tracef("%s: directory %q", c.label(), cmd.Dir)
+	tracef("%s: environment of %d variables", c.label(), len(cmd.Env))
+	for _, kv := range redactEnv(cmd.Env) {
+		tracef("%s:   %s", c.label(), kv)
+	}
+	tracef("%s: stdin %T, stdout %T, stderr %T", c.label(), cmd.Stdin, cmd.Stdout, cmd.Stderr)
+	tracef("%s: %s, ConPTY %v, user session %v", c.label(), creationParams(cmd), c.ConPTY, c.UserSession)
+}
@@ -0,0 +1,24 @@
+//go:build !windows
+
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// commandLine returns the arguments execve gets for cmd, with secrets
+// redacted.
+func commandLine(cmd *exec.Cmd) string {
+	return strings.Join(quoteArgs(redactArgs(cmd.Args)), " ")
+}
+
+// creationParams describes the process attributes cmd starts with.
+func creationParams(cmd *exec.Cmd) string {
+	attr := cmd.SysProcAttr
+	if attr == nil {
+		return "default process attributes"
+	}
+	return fmt.Sprintf("own process group %v, other credentials %v", attr.Setpgid, attr.Credential != nil)
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+
+	"golang.org/x/sys/windows"
+)
+
+// commandLine returns the command line CreateProcess gets for cmd, with
+// secrets redacted.
+func commandLine(cmd *exec.Cmd) string {
+	if cmd.SysProcAttr != nil && cmd.SysProcAttr.CmdLine != "" {
+		return redactRawArgs(cmd.SysProcAttr.CmdLine)
+	}
+	return windows.ComposeCommandLine(redactArgs(cmd.Args))
+}
+
+// creationParams describes the flags and token CreateProcess gets for cmd.
+func creationParams(cmd *exec.Cmd) string {
+	attr := cmd.SysProcAttr
+	if attr == nil {
+		return "default creation flags, wrapper's token"
+	}
+	token := "wrapper's token"
+	if attr.Token != 0 {
+		token = "user token"
+	}
+	return fmt.Sprintf("creation flags 0x%08X, hide window %v, %s", attr.CreationFlags, attr.HideWindow, token)
+}
@@ -0,0 +1,16 @@
+package main
+
+// Trigger starts or stops the installed service on a system event, so a
+// rarely needed service does not have to run permanently.
+type Trigger struct {
+	// Type is "network" (the first IP address arrives, or the last one goes
+	// away for a stop trigger), "domain-join" (the machine joins, or leaves,
+	// a domain), "group-policy" (machine policy changes), "device" (a device
+	// of the GUID interface class arrives, optionally only one matching
+	// HardwareID) or "custom" (the ETW provider GUID writes an event).
+	Type       string
+	GUID       string
+	HardwareID string
+	// Action is "start" (default) or "stop".
+	Action string
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+const (
+	serviceTriggerTypeDeviceInterfaceArrival = 1
+	serviceTriggerTypeIPAddressAvailability  = 3
+	serviceTriggerTypeDomainJoin             = 2
+	serviceTriggerTypeGroupPolicy            = 5
+	serviceTriggerTypeCustom                 = 20
+
+	serviceTriggerActionStart = 1
+	serviceTriggerActionStop  = 2
+
+	serviceTriggerDataTypeString = 2
+)
+
+// Trigger subtypes, from the Windows SDK.
+var triggerGUIDs = map[string][2]string{
+	"network":      {"4f27f2de-14e2-430b-a549-7cd48cbc8245", "cc4ba62a-162e-4648-847a-b6bdf993e335"},
+	"domain-join":  {"1ce20aba-9851-4421-9430-1ddeb766e809", "ddaf516e-58c2-4866-9574-c3b615d42ea1"},
+	"group-policy": {"659fcae6-5bdb-4da9-b1ff-ca2a178d46e0", "659fcae6-5bdb-4da9-b1ff-ca2a178d46e0"},
+}
+
+// SERVICE_TRIGGER_SPECIFIC_DATA_ITEM
+type serviceTriggerDataItem struct {
+	dataType uint32
+	size     uint32
+	data     *byte
+}
+
+// SERVICE_TRIGGER
+type serviceTrigger struct {
+	triggerType uint32
+	action      uint32
+	subtype     *windows.GUID
+	items       uint32
+	dataItems   *serviceTriggerDataItem
+}
+
+// SERVICE_TRIGGER_INFO
+type serviceTriggerInfo struct {
+	triggers    uint32
+	allTriggers *serviceTrigger
+	reserved    *byte
+}
+
+// setTriggers replaces the triggers of s and makes it a manual, trigger
+// started service.
+func setTriggers(s *mgr.Service, triggers []Trigger) error {
+	list := make([]serviceTrigger, len(triggers))
+	for i, t := range triggers {
+		st := &list[i]
+		st.action = serviceTriggerActionStart
+		stop := 0
+		switch t.Action {
+		case "", "start":
+		case "stop":
+			st.action, stop = serviceTriggerActionStop, 1
+		default:
+			return fmt.Errorf("Unknown trigger Action %q, expected start or stop", t.Action)
+		}
+		guid := t.GUID
+		switch t.Type {
+		case "network":
+			st.triggerType = serviceTriggerTypeIPAddressAvailability
+		case "domain-join":
+			st.triggerType = serviceTriggerTypeDomainJoin
+		case "group-policy":
+			st.triggerType = serviceTriggerTypeGroupPolicy
+		case "device":
+			st.triggerType = serviceTriggerTypeDeviceInterfaceArrival
+		case "custom":
+			st.triggerType = serviceTriggerTypeCustom
+		default:
+			return fmt.Errorf("Unknown trigger Type %q", t.Type)
+		}
+		if known, ok := triggerGUIDs[t.Type]; ok {
+			guid = known[stop]
+		} else if guid == "" {
+			return fmt.Errorf("Trigger %q needs a GUID", t.Type)
+		}
+		g, err := windows.GUIDFromString("{" + guid + "}")
+		if err != nil {
+			return fmt.Errorf("Invalid trigger GUID %q: %v", guid, err)
+		}
+		st.subtype = &g
+		if t.HardwareID != "" {
+			// A REG_MULTI_SZ style list: the ID, then an empty string.
+			id, err := windows.UTF16FromString(t.HardwareID)
+			if err != nil {
+				return err
+			}
+			id = append(id, 0)
+			st.items = 1
+			st.dataItems = &serviceTriggerDataItem{
+				dataType: serviceTriggerDataTypeString,
+				size:     uint32(len(id) * 2),
+				data:     (*byte)(unsafe.Pointer(&id[0])),
+			}
+		}
+	}
+	info := &serviceTriggerInfo{triggers: uint32(len(list))}
+	if len(list) != 0 {
+		info.allTriggers = &list[0]
+	}
+	if err := windows.ChangeServiceConfig2(s.Handle, windows.SERVICE_CONFIG_TRIGGER_INFO, (*byte)(unsafe.Pointer(info))); err != nil {
+		return err
+	}
+	if len(list) == 0 {
+		return nil
+	}
+	return windows.ChangeServiceConfig(s.Handle, windows.SERVICE_NO_CHANGE, windows.SERVICE_DEMAND_START,
+		windows.SERVICE_NO_CHANGE, nil, nil, nil, nil, nil, nil, nil)
+}
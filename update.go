@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/kardianos/osext"
+)
+
+// selfUpdate replaces the running wsw executable with the build at url,
+// after checking it against sum (hex SHA-256) or a signature by ConfigKey.
+// Services run by this executable are stopped for the swap and started
+// again; their registrations keep pointing at the same path.
+func selfUpdate(conf *Config, url, sum string) error {
+	if url == "" {
+		url = conf.UpdateURL
+	}
+	if url == "" {
+		return errors.New("No update URL, pass -url or set UpdateURL")
+	}
+	exe, err := osext.Executable()
+	if err != nil {
+		return err
+	}
+	data, sig, err := download(url)
+	if err != nil {
+		return fmt.Errorf("Failed to download %q: %v", url, err)
+	}
+	if err := verifyUpdate(conf, data, sum, sig); err != nil {
+		return err
+	}
+	// A packaged wsw keeps its embedded config.
+	if embedded, _, err := readEmbedded(exe); err != nil {
+		return err
+	} else if embedded != nil {
+		trailer := make([]byte, 8, 8+len(embedMagic))
+		binary.LittleEndian.PutUint64(trailer, uint64(len(embedded)))
+		data = append(append(data, embedded...), append(trailer, embedMagic...)...)
+	}
+	newPath, oldPath := exe+".new", exe+".old"
+	if err := ioutil.WriteFile(newPath, data, 0755); err != nil {
+		return err
+	}
+	defer os.Remove(newPath)
+
+	services, err := listWswServices()
+	if err != nil {
+		return err
+	}
+	var running []wswService
+	for _, s := range services {
+		if !strings.EqualFold(filepath.Clean(s.Exe), filepath.Clean(exe)) {
+			continue
+		}
+		if state, err := queryServiceState(s.Name); err == nil && state == "running" {
+			running = append(running, s)
+		}
+	}
+	order := orderServices(running)
+	for i := len(order) - 1; i >= 0; i-- {
+		infof("Stopping %s", order[i])
+		if err := stopService(order[i]); err != nil {
+			// Bring back the ones already stopped, leaving the old build.
+			for _, name := range order[i+1:] {
+				infof("Starting %s", name)
+				if serr := startService(name); serr != nil {
+					warnf("Failed to start %s: %v", name, serr)
+				}
+			}
+			return fmt.Errorf("Failed to stop %s, not updating: %v", order[i], err)
+		}
+	}
+
+	// A running executable can be renamed but not overwritten. The copy
+	// left by the previous update is removed first.
+	os.Remove(oldPath)
+	err = os.Rename(exe, oldPath)
+	if err == nil {
+		if err = os.Rename(newPath, exe); err != nil {
+			os.Rename(oldPath, exe)
+		}
+	}
+	if err != nil {
+		err = fmt.Errorf("Failed to replace %q: %v", exe, err)
+	} else {
+		infof("Updated %s", exe)
+	}
+	for _, name := range order {
+		infof("Starting %s", name)
+		if serr := startService(name); serr != nil && err == nil {
+			err = fmt.Errorf("Failed to start %s, the previous build is %q: %v", name, oldPath, serr)
+		}
+	}
+	return err
+}
+
+// downloadClient fetches builds and artifacts. Unlike httpClient it has no
+// overall Timeout, which would cut off any large download; a stalled one
+// fails on the transport's timeouts or, once the body flows, on
+// downloadIdleTimeout.
+var downloadClient = &http.Client{Transport: newDownloadTransport()}
+
+// downloadIdleTimeout is how long a download may go without receiving data.
+const downloadIdleTimeout = time.Minute
+
+func newDownloadTransport() *http.Transport {
+	t := newProxyTransport()
+	t.ResponseHeaderTimeout = 30 * time.Second
+	return t
+}
+
+// doDownload sends req with downloadClient, giving up on the body once no
+// data has arrived for downloadIdleTimeout.
+func doDownload(req *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithCancel(req.Context())
+	resp, err := downloadClient.Do(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	b := &idleBody{ReadCloser: resp.Body, cancel: cancel}
+	b.timer = time.AfterFunc(downloadIdleTimeout, func() {
+		atomic.StoreInt32(&b.idle, 1)
+		cancel()
+	})
+	resp.Body = b
+	return resp, nil
+}
+
+// idleBody cancels its request when reads stall.
+type idleBody struct {
+	io.ReadCloser
+	timer  *time.Timer
+	cancel context.CancelFunc
+	idle   int32
+}
+
+func (b *idleBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil && atomic.LoadInt32(&b.idle) != 0 {
+		return n, fmt.Errorf("No data received for %v", downloadIdleTimeout)
+	}
+	b.timer.Reset(downloadIdleTimeout)
+	return n, err
+}
+
+func (b *idleBody) Close() error {
+	b.timer.Stop()
+	b.cancel()
+	return b.ReadCloser.Close()
+}
+
+func download(url string) ([]byte, string, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	resp, err := doDownload(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("Unexpected status %s", resp.Status)
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	return data, resp.Header.Get(signatureHeader), err
+}
+
+// verifyUpdate refuses a build that matches neither the checksum given on
+// the command line nor a ConfigKey signature. A checksum served next to the
+// build would only catch corruption, so it is not looked for.
+func verifyUpdate(conf *Config, data []byte, sum, sig string) error {
+	if sum == "" && conf.ConfigKey == "" {
+		return errors.New("Refusing an unverified update: pass -sha256 or set ConfigKey")
+	}
+	if sum != "" {
+		got := sha256.Sum256(data)
+		if !strings.EqualFold(hex.EncodeToString(got[:]), sum) {
+			return fmt.Errorf("Update checksum mismatch: got %x, want %s", got, sum)
+		}
+	}
+	return verifySignature(conf, data, sig, "Update")
+}
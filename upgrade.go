@@ -0,0 +1,169 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// upgrade replaces the application with a new build: from is either a new
+// executable, copied over Exec, or a new version folder taking the place of
+// Dir. The service is stopped for the swap, started again and must get
+// ready; otherwise the previous version is put back. Both outcomes are
+// recorded in the history.
+func upgrade(conf *Config, from string) error {
+	if from == "" {
+		return errors.New("Usage: wsw -a upgrade --exec <new executable or folder>")
+	}
+	if conf.Exec == "" {
+		return errors.New("No Exec to upgrade")
+	}
+	if conf.Portable {
+		if err := conf.resolvePortable(); err != nil {
+			return err
+		}
+	}
+	from, err := filepath.Abs(from)
+	if err != nil {
+		return err
+	}
+	fi, err := os.Stat(from)
+	if err != nil {
+		return err
+	}
+	c := &child{Process: &conf.Process, prg: &program{Config: conf}, name: conf.Name, instance: -1}
+	dir, err := c.workDir()
+	if err != nil {
+		return err
+	}
+	exe, err := lookExec(dir, conf.Exec)
+	if err != nil {
+		return fmt.Errorf("Failed to find executable %q: %v", conf.Exec, err)
+	}
+
+	// swap puts the new version in place, keeping the old one as ".old";
+	// restore undoes it.
+	var target string
+	var swap func() error
+	if fi.IsDir() {
+		if conf.Dir == "" {
+			return errors.New("Upgrading from a folder needs Dir")
+		}
+		if _, err := os.Readlink(dir); err == nil {
+			return fmt.Errorf("Dir %q is a link, use deploy", dir)
+		}
+		target = dir
+		swap = func() error { return copyTree(from, dir) }
+		rel, err := filepath.Rel(dir, exe)
+		if err != nil {
+			return err
+		}
+		c.dir = from
+		err = c.check(filepath.Join(from, rel))
+		c.dir = dir
+		if err != nil {
+			return err
+		}
+	} else {
+		target = exe
+		swap = func() error { return copyFile(from, exe) }
+		c.dir = dir
+		if err := c.check(from); err != nil {
+			return err
+		}
+	}
+	backup := target + ".old"
+	restore := func() error {
+		if err := os.RemoveAll(target); err != nil {
+			return err
+		}
+		return os.Rename(backup, target)
+	}
+
+	oldVersion := fileVersion(exe)
+	_, serr := queryServiceState(conf.Name)
+	installed := serr == nil
+	if installed {
+		infof("Stopping %s", conf.Name)
+		if err := stopService(conf.Name); err != nil {
+			return err
+		}
+	}
+	if err := saveVersion(conf, "upgrade", target, exe, fi.IsDir()); err != nil {
+		warnf("Failed to cache the current version: %v", err)
+	}
+	if err := os.RemoveAll(backup); err != nil {
+		return err
+	}
+	if err := os.Rename(target, backup); err != nil {
+		return err
+	}
+	if err := swap(); err != nil {
+		if rerr := restore(); rerr != nil {
+			return fmt.Errorf("Failed to swap in %q: %v; restoring failed: %v", from, err, rerr)
+		}
+		return fmt.Errorf("Failed to swap in %q: %v", from, err)
+	}
+	newVersion := fileVersion(exe)
+	infof("Swapped %s from %s to %s", target, oldVersion, newVersion)
+	if !installed {
+		recordHistory(conf, "upgrade", fmt.Sprintf("%s -> %s", oldVersion, newVersion))
+		return nil
+	}
+
+	err = startReady(conf)
+	if err == nil {
+		recordHistory(conf, "upgrade", fmt.Sprintf("%s -> %s", oldVersion, newVersion))
+		if err := saveVersion(conf, "upgrade", target, exe, fi.IsDir()); err != nil {
+			warnf("Failed to cache the new version: %v", err)
+		}
+		return nil
+	}
+	warnf("Upgrade failed, restoring %s: %v", oldVersion, err)
+	recordHistory(conf, "upgrade-failed", fmt.Sprintf("%s -> %s: %v", oldVersion, newVersion, err))
+	if serr := stopService(conf.Name); serr != nil {
+		return fmt.Errorf("%v; stopping the service failed: %v", err, serr)
+	}
+	if rerr := restore(); rerr != nil {
+		return fmt.Errorf("%v; restoring the previous version failed: %v", err, rerr)
+	}
+	if serr := startService(conf.Name); serr != nil {
+		return fmt.Errorf("%v; starting the previous version failed: %v", err, serr)
+	}
+	return fmt.Errorf("Upgrade failed, restored %s: %v", oldVersion, err)
+}
+
+// startReady starts the service and waits for its processes to be ready.
+func startReady(conf *Config) error {
+	infof("Starting %s", conf.Name)
+	if err := startService(conf.Name); err != nil {
+		return err
+	}
+	// The wrapper opens its control pipe right after reporting running.
+	for i := 0; ; i++ {
+		err := sendControl(conf.Name, os.Stdout, "wait-ready")
+		if err != errNotRunning || i == 10 {
+			return err
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+}
+
+// copyTree copies the files under src into dst.
+func copyTree(src, dst string) error {
+	return filepath.Walk(src, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			return os.MkdirAll(filepath.Join(dst, rel), 0755)
+		}
+		return copyFile(path, filepath.Join(dst, rel))
+	})
+}
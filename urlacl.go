@@ -0,0 +1,22 @@
+package main
+
+import "strings"
+
+// URLACL reserves an HTTP.SYS URL prefix for an account to listen on, as
+// "netsh http add urlacl" does, for apps using HttpListener or Kestrel on
+// HTTP.SYS under an account that is not an administrator.
+type URLACL struct {
+	// URL is the prefix, such as "http://+:8080/" or
+	// "https://*:8443/api/"; a missing trailing slash is added.
+	URL string
+	// User may listen on it, by default the account the service (or task)
+	// runs as.
+	User string
+}
+
+func (a *URLACL) prefix() string {
+	if strings.HasSuffix(a.URL, "/") {
+		return a.URL
+	}
+	return a.URL + "/"
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var (
+	httpapi                            = windows.NewLazySystemDLL("httpapi.dll")
+	procHttpInitialize                 = httpapi.NewProc("HttpInitialize")
+	procHttpTerminate                  = httpapi.NewProc("HttpTerminate")
+	procHttpSetServiceConfiguration    = httpapi.NewProc("HttpSetServiceConfiguration")
+	procHttpDeleteServiceConfiguration = httpapi.NewProc("HttpDeleteServiceConfiguration")
+)
+
+const (
+	// httpAPIVersion1 is HTTPAPI_VERSION 1.0, passed by value.
+	httpAPIVersion1          = 1
+	httpInitializeConfig     = 2
+	httpServiceConfigSSLCert = 1
+	httpServiceConfigURLACL  = 2
+)
+
+type httpServiceConfigURLACLSet struct {
+	URLPrefix          *uint16
+	SecurityDescriptor *uint16
+}
+
+// httpServiceConfig calls proc, HttpSetServiceConfiguration or
+// HttpDeleteServiceConfiguration, with the config record info of type id.
+func httpServiceConfig(proc *windows.LazyProc, id uintptr, info unsafe.Pointer, size uintptr) error {
+	if r, _, _ := procHttpInitialize.Call(httpAPIVersion1, httpInitializeConfig, 0); r != 0 {
+		return windows.Errno(r)
+	}
+	defer procHttpTerminate.Call(httpInitializeConfig, 0)
+	if r, _, _ := proc.Call(0, id, uintptr(info), size, 0); r != 0 {
+		return windows.Errno(r)
+	}
+	return nil
+}
+
+// httpConfig sets or deletes the reservation of prefix.
+func httpConfig(proc *windows.LazyProc, prefix, sddl string) error {
+	set := httpServiceConfigURLACLSet{}
+	var err error
+	if set.URLPrefix, err = windows.UTF16PtrFromString(prefix); err != nil {
+		return err
+	}
+	if sddl != "" {
+		if set.SecurityDescriptor, err = windows.UTF16PtrFromString(sddl); err != nil {
+			return err
+		}
+	}
+	return httpServiceConfig(proc, httpServiceConfigURLACL, unsafe.Pointer(&set), unsafe.Sizeof(set))
+}
+
+// urlACLSID returns the SID of the account an URLACL is for.
+func urlACLSID(conf *Config, a *URLACL) (string, error) {
+	account := a.User
+	if account == "" {
+		if scm, err := queryServiceConfig(conf.Name); err == nil {
+			account = scm.Account
+		}
+	}
+	switch strings.ToLower(account) {
+	case "":
+		// A task runs as the user installing it.
+		user, err := windows.GetCurrentProcessToken().GetTokenUser()
+		if err != nil {
+			return "", err
+		}
+		return user.User.Sid.String(), nil
+	case "localsystem":
+		return "S-1-5-18", nil
+	}
+	sid, _, _, err := windows.LookupSID("", strings.TrimPrefix(account, `.\`))
+	if err != nil {
+		return "", fmt.Errorf("Failed to look up %s: %v", account, err)
+	}
+	return sid.String(), nil
+}
+
+// addURLACLs reserves the URLACLs, replacing existing reservations of the
+// same prefixes.
+func addURLACLs(conf *Config) error {
+	for i := range conf.URLACLs {
+		a := &conf.URLACLs[i]
+		sid, err := urlACLSID(conf, a)
+		if err != nil {
+			return fmt.Errorf("URL ACL %s: %v", a.prefix(), err)
+		}
+		// The reservation may not exist yet.
+		httpConfig(procHttpDeleteServiceConfiguration, a.prefix(), "")
+		// GX grants listening, as netsh's listen=yes does.
+		if err := httpConfig(procHttpSetServiceConfiguration, a.prefix(), "D:(A;;GX;;;"+sid+")"); err != nil {
+			return fmt.Errorf("Failed to reserve %s: %v", a.prefix(), err)
+		}
+		infof("Reserved %s for %s", a.prefix(), sid)
+	}
+	return nil
+}
+
+// removeURLACLs deletes the reservations, only warning about those that
+// cannot be, so that uninstalling goes on.
+func removeURLACLs(conf *Config) {
+	for i := range conf.URLACLs {
+		prefix := conf.URLACLs[i].prefix()
+		err := httpConfig(procHttpDeleteServiceConfiguration, prefix, "")
+		if err != nil && err != windows.ERROR_FILE_NOT_FOUND {
+			warnf("Failed to remove the reservation of %s: %v", prefix, err)
+		}
+	}
+}
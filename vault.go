@@ -0,0 +1,325 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Vault configures secret://vault/<path>[#<field>], which reads a secret of
+// HashiCorp Vault: a KV version 2 secret, such as secret/myapp#password, or
+// one Vault generates with a lease, such as database/creds/myapp#username.
+// The field may be left out when the secret has only one.
+type Vault struct {
+	// Address, such as https://vault:8200, and Namespace default to
+	// VAULT_ADDR and VAULT_NAMESPACE.
+	Address, Namespace string
+	// Token, by default VAULT_TOKEN, is used as it is. Without one wsw logs
+	// in with the AppRole RoleID and SecretID, at AppRoleMount (default
+	// "approle"). Token and SecretID may be secret:// references themselves.
+	Token            string
+	RoleID, SecretID string
+	AppRoleMount     string
+	// RestartOnRotate restarts a process whose leased secret can no longer
+	// be renewed, so that it gets a new one. Otherwise wsw only warns.
+	RestartOnRotate bool
+}
+
+// vaultClient holds the token of a Vault, renewing it or logging in again
+// before it expires.
+type vaultClient struct {
+	mu      sync.Mutex
+	conf    Vault
+	token   string
+	expires time.Time // Zero when the token does not expire.
+	renew   bool
+}
+
+var (
+	vaultClientsMu sync.Mutex
+	vaultClients   = map[Vault]*vaultClient{}
+)
+
+// vaultRead is a secret as Vault returns it.
+type vaultRead struct {
+	Data          map[string]json.RawMessage
+	LeaseID       string `json:"lease_id"`
+	LeaseDuration int    `json:"lease_duration"`
+	Renewable     bool
+}
+
+func vaultSecret(r *secretResolver, key string) (string, error) {
+	path, field := key, ""
+	if i := strings.LastIndex(key, "#"); i >= 0 {
+		path, field = key[:i], key[i+1:]
+	}
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return "", fmt.Errorf("Expected secret://vault/<path>[#<field>]")
+	}
+	conf := Vault{}
+	if r.conf.Secrets.Vault != nil {
+		conf = *r.conf.Secrets.Vault
+	}
+	v := getVaultClient(conf)
+	read, ok := r.reads["vault:"+path].(*vaultRead)
+	if !ok {
+		var err error
+		if read, err = v.read(r, path); err != nil {
+			return "", err
+		}
+		r.reads["vault:"+path] = read
+		if read.LeaseID != "" && r.done != nil {
+			go v.keepLease(r.child, r.done, path, read)
+		}
+	}
+	r.leasing = read.LeaseID != ""
+	if field == "" {
+		if len(read.Data) != 1 {
+			fields := []string{}
+			for f := range read.Data {
+				fields = append(fields, f)
+			}
+			sort.Strings(fields)
+			return "", fmt.Errorf("%s has the fields %s, add #<field>", path, strings.Join(fields, ", "))
+		}
+		for f := range read.Data {
+			field = f
+		}
+	}
+	raw, ok := read.Data[field]
+	if !ok {
+		return "", fmt.Errorf("%s has no field %q", path, field)
+	}
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		return s, nil
+	}
+	// Numbers and such are passed on as JSON.
+	return string(raw), nil
+}
+
+func getVaultClient(conf Vault) *vaultClient {
+	vaultClientsMu.Lock()
+	defer vaultClientsMu.Unlock()
+	key := conf
+	v := vaultClients[key]
+	if v == nil {
+		if conf.Address == "" {
+			conf.Address = os.Getenv("VAULT_ADDR")
+		}
+		if conf.Namespace == "" {
+			conf.Namespace = os.Getenv("VAULT_NAMESPACE")
+		}
+		if conf.Token == "" && conf.RoleID == "" {
+			conf.Token = os.Getenv("VAULT_TOKEN")
+		}
+		if conf.AppRoleMount == "" {
+			conf.AppRoleMount = "approle"
+		}
+		v = &vaultClient{conf: conf}
+		vaultClients[key] = v
+	}
+	return v
+}
+
+// read reads the secret at path, through its KV version 2 data path when
+// the mount is one.
+func (v *vaultClient) read(r *secretResolver, path string) (*vaultRead, error) {
+	var mount struct {
+		Data struct {
+			Path    string
+			Type    string
+			Options map[string]string
+		}
+	}
+	if err := v.do(r, "GET", "sys/internal/ui/mounts/"+path, nil, &mount); err != nil {
+		return nil, fmt.Errorf("Failed to look up the mount of %s: %v", path, err)
+	}
+	m := mount.Data
+	if m.Type == "kv" && m.Options["version"] == "2" {
+		var kv struct {
+			Data struct {
+				Data map[string]json.RawMessage
+			}
+		}
+		if err := v.do(r, "GET", m.Path+"data/"+strings.TrimPrefix(path, m.Path), nil, &kv); err != nil {
+			return nil, fmt.Errorf("Failed to read %s: %v", path, err)
+		}
+		return &vaultRead{Data: kv.Data.Data}, nil
+	}
+	read := &vaultRead{}
+	if err := v.do(r, "GET", path, nil, read); err != nil {
+		return nil, fmt.Errorf("Failed to read %s: %v", path, err)
+	}
+	return read, nil
+}
+
+// keepLease renews the lease of the secret read from path while the child's
+// run goes on. Once Vault will not extend it any more the child is
+// restarted to get a new secret, with RestartOnRotate.
+func (v *vaultClient) keepLease(c *child, done <-chan struct{}, path string, read *vaultRead) {
+	ttl := time.Duration(read.LeaseDuration) * time.Second
+	renewable := read.Renewable
+	for ttl > 0 {
+		wait := ttl * 2 / 3
+		if !renewable {
+			// Rotate shortly before the secret stops working.
+			wait = ttl - ttl/10
+		}
+		select {
+		case <-done:
+			return
+		case <-time.After(wait):
+		}
+		if renewable {
+			var renewed vaultRead
+			body := map[string]interface{}{"lease_id": read.LeaseID, "increment": read.LeaseDuration}
+			if err := v.do(newSecretResolver(c.prg.Config), "PUT", "sys/leases/renew", body, &renewed); err != nil {
+				logger.Warningf("%s: failed to renew the lease of %s: %v", c.label(), path, err)
+				renewable = false
+				ttl -= wait
+				continue
+			}
+			// A lease at its maximum TTL comes back shorter than asked.
+			renewable = renewed.LeaseDuration >= read.LeaseDuration
+			ttl = time.Duration(renewed.LeaseDuration) * time.Second
+			continue
+		}
+		if !v.conf.RestartOnRotate {
+			logger.Warningf("%s: the lease of %s ends and cannot be renewed, set RestartOnRotate to restart with a new secret", c.label(), path)
+			return
+		}
+		logger.Infof("Restarting %s: the lease of %s ends", c.label(), path)
+		if err := c.restart(); err != nil {
+			logger.Warningf("Failed to restart %s: %v", c.label(), err)
+		}
+		return
+	}
+}
+
+// do sends a request to the Vault API and decodes the response into out, r
+// resolving references in the credentials when logging in.
+func (v *vaultClient) do(r *secretResolver, method, path string, body, out interface{}) error {
+	if v.conf.Address == "" {
+		return fmt.Errorf("No Vault address, set Secrets.Vault.Address or VAULT_ADDR")
+	}
+	token, err := v.getToken(r)
+	if err != nil {
+		return err
+	}
+	return v.send(method, path, token, body, out)
+}
+
+func (v *vaultClient) send(method, path, token string, body, out interface{}) error {
+	var data []byte
+	if body != nil {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequest(method, strings.TrimSuffix(v.conf.Address, "/")+"/v1/"+path, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	if token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+	if v.conf.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.conf.Namespace)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err = ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode/100 != 2 {
+		var e struct{ Errors []string }
+		if json.Unmarshal(data, &e) == nil && len(e.Errors) != 0 {
+			return fmt.Errorf("%s: %s", resp.Status, strings.Join(e.Errors, "; "))
+		}
+		return fmt.Errorf("Unexpected status %s", resp.Status)
+	}
+	if out == nil || len(data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, out)
+}
+
+// vaultAuth is the part of a login or token lookup wsw keeps.
+type vaultAuth struct {
+	Auth struct {
+		ClientToken   string `json:"client_token"`
+		LeaseDuration int    `json:"lease_duration"`
+		Renewable     bool
+	}
+	Data struct {
+		TTL       int
+		Renewable bool
+	}
+}
+
+// getToken returns a token good for another minute, renewing it or logging
+// in again as needed.
+func (v *vaultClient) getToken(r *secretResolver) (string, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.token != "" && (v.expires.IsZero() || time.Until(v.expires) > time.Minute) {
+		return v.token, nil
+	}
+	if v.token != "" && v.renew {
+		var a vaultAuth
+		if err := v.send("PUT", "auth/token/renew-self", v.token, nil, &a); err == nil {
+			v.setToken(v.token, a.Auth.LeaseDuration, a.Auth.Renewable)
+			if time.Until(v.expires) > time.Minute {
+				return v.token, nil
+			}
+		}
+	}
+	if v.conf.RoleID == "" {
+		if v.conf.Token == "" {
+			return "", fmt.Errorf("No Vault token, set Secrets.Vault.Token, VAULT_TOKEN or an AppRole RoleID")
+		}
+		token, err := r.resolve(v.conf.Token)
+		if err != nil {
+			return "", err
+		}
+		var a vaultAuth
+		if err := v.send("GET", "auth/token/lookup-self", token, nil, &a); err != nil {
+			return "", fmt.Errorf("Failed to look up the Vault token: %v", err)
+		}
+		v.setToken(token, a.Data.TTL, a.Data.Renewable)
+		return v.token, nil
+	}
+	secretID, err := r.resolve(v.conf.SecretID)
+	if err != nil {
+		return "", err
+	}
+	var a vaultAuth
+	body := map[string]string{"role_id": v.conf.RoleID, "secret_id": secretID}
+	if err := v.send("PUT", "auth/"+strings.Trim(v.conf.AppRoleMount, "/")+"/login", "", body, &a); err != nil {
+		return "", fmt.Errorf("Failed to log in to Vault with AppRole: %v", err)
+	}
+	v.setToken(a.Auth.ClientToken, a.Auth.LeaseDuration, a.Auth.Renewable)
+	return v.token, nil
+}
+
+func (v *vaultClient) setToken(token string, ttl int, renewable bool) {
+	v.token, v.renew = token, renewable
+	v.expires = time.Time{}
+	if ttl > 0 {
+		v.expires = time.Now().Add(time.Duration(ttl) * time.Second)
+	}
+}
@@ -0,0 +1,16 @@
+package main
+
+import "encoding/hex"
+
+// fileVersion returns the file version from the executable's version
+// resource, or the start of its SHA-256 when it has none.
+func fileVersion(path string) string {
+	if v := versionResource(path); v != "" {
+		return v
+	}
+	sum, err := hashFile(path)
+	if err != nil {
+		return "unknown"
+	}
+	return "sha256:" + hex.EncodeToString(sum)[:12]
+}
@@ -0,0 +1,8 @@
+//go:build !windows
+
+package main
+
+// versionResource returns "": executables carry no version resource.
+func versionResource(path string) string {
+	return ""
+}
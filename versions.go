@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+// versionEntry is one cached version in the versions/manifest.json of the
+// state directory. Target is the executable, or the folder for versions
+// upgraded as a whole, and Exec the executable identifying the version.
+type versionEntry struct {
+	ID      int
+	Version string
+	SHA256  string
+	Saved   time.Time
+	Source  string
+	Target  string
+	Exec    string
+	Dir     bool
+}
+
+func versionsDir(conf *Config) (string, error) {
+	dir, err := getStateDir(conf)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "versions"), nil
+}
+
+func readManifest(conf *Config) ([]versionEntry, error) {
+	dir, err := versionsDir(conf)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, "manifest.json"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var entries []versionEntry
+	return entries, json.Unmarshal(data, &entries)
+}
+
+func writeManifest(conf *Config, entries []versionEntry) error {
+	dir, err := versionsDir(conf)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, "manifest.json"), data, 0644)
+}
+
+// path returns where the cached copy of the entry lives.
+func (e *versionEntry) path(dir string) string {
+	p := filepath.Join(dir, strconv.Itoa(e.ID))
+	if e.Dir {
+		return p
+	}
+	return filepath.Join(p, filepath.Base(e.Target))
+}
+
+// saveVersion caches a copy of target, the executable exe or the folder
+// holding it, unless that version is cached already, and drops the oldest
+// copies beyond KeepVersions.
+func saveVersion(conf *Config, source, target, exe string, isDir bool) error {
+	if conf.KeepVersions <= 0 {
+		return nil
+	}
+	sum, err := hashFile(exe)
+	if err != nil {
+		return err
+	}
+	entries, err := readManifest(conf)
+	if err != nil {
+		return err
+	}
+	e := versionEntry{
+		ID:      1,
+		Version: fileVersion(exe),
+		SHA256:  hex.EncodeToString(sum),
+		Saved:   time.Now(),
+		Source:  source,
+		Target:  target,
+		Exec:    exe,
+		Dir:     isDir,
+	}
+	for _, old := range entries {
+		if old.SHA256 == e.SHA256 && old.Target == e.Target {
+			return nil
+		}
+		if old.ID >= e.ID {
+			e.ID = old.ID + 1
+		}
+	}
+	dir, err := versionsDir(conf)
+	if err != nil {
+		return err
+	}
+	if isDir {
+		err = copyTree(target, e.path(dir))
+	} else {
+		err = copyFile(target, e.path(dir))
+	}
+	if err != nil {
+		os.RemoveAll(filepath.Join(dir, strconv.Itoa(e.ID)))
+		return err
+	}
+	entries = append(entries, e)
+	for len(entries) > conf.KeepVersions {
+		os.RemoveAll(filepath.Join(dir, strconv.Itoa(entries[0].ID)))
+		entries = entries[1:]
+	}
+	return writeManifest(conf, entries)
+}
+
+// isCurrent tells whether the entry's version is the one in place.
+func (e *versionEntry) isCurrent() bool {
+	sum, err := hashFile(e.Exec)
+	return err == nil && hex.EncodeToString(sum) == e.SHA256
+}
+
+// printVersions lists the cached versions, marking the ones in place.
+func printVersions(conf *Config) error {
+	entries, err := readManifest(conf)
+	if err != nil {
+		return err
+	}
+	if jsonOutput {
+		out := make([]versionJSON, 0, len(entries))
+		for i := len(entries) - 1; i >= 0; i-- {
+			e := &entries[i]
+			out = append(out, versionJSON{ID: e.ID, Version: e.Version, SHA256: e.SHA256, Saved: isoTime(e.Saved), Source: e.Source, Target: e.Target, Current: e.isCurrent()})
+		}
+		return printJSON(out)
+	}
+	if len(entries) == 0 {
+		infof("No versions cached, set KeepVersions")
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "\tID\tVersion\tSaved\tSource\tTarget")
+	for i := len(entries) - 1; i >= 0; i-- {
+		e := &entries[i]
+		mark := ""
+		if e.isCurrent() {
+			mark = "*"
+		}
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\t%s\n", mark, e.ID, e.Version, e.Saved.Format(time.RFC3339), e.Source, e.Target)
+	}
+	return w.Flush()
+}
+
+// rollbackVersion puts a cached version back in place, by default the newest
+// one that is not in place, restarting the service around the switch.
+func rollbackVersion(conf *Config, id string) error {
+	entries, err := readManifest(conf)
+	if err != nil {
+		return err
+	}
+	var e *versionEntry
+	for i := len(entries) - 1; i >= 0 && e == nil; i-- {
+		if id == "" && !entries[i].isCurrent() || id == strconv.Itoa(entries[i].ID) {
+			e = &entries[i]
+		}
+	}
+	if e == nil {
+		if id == "" {
+			return errors.New("No cached version to roll back to, see wsw -a versions")
+		}
+		return fmt.Errorf("No cached version %s, see wsw -a versions", id)
+	}
+	dir, err := versionsDir(conf)
+	if err != nil {
+		return err
+	}
+	from := fileVersion(e.Exec)
+
+	_, serr := queryServiceState(conf.Name)
+	installed := serr == nil
+	if installed {
+		infof("Stopping %s", conf.Name)
+		if err := stopService(conf.Name); err != nil {
+			return err
+		}
+	}
+	if e.Dir {
+		if err := os.RemoveAll(e.Target); err != nil {
+			return err
+		}
+		err = copyTree(e.path(dir), e.Target)
+	} else {
+		err = copyFile(e.path(dir), e.Target)
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to restore version %d: %v", e.ID, err)
+	}
+	infof("Rolled %s back from %s to %s", e.Target, from, e.Version)
+	recordHistory(conf, "rollback", fmt.Sprintf("%s -> %s", from, e.Version))
+	if !installed {
+		return nil
+	}
+	return startReady(conf)
+}
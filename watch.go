@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// watchInterval is how often watched files are checked for changes.
+const watchInterval = time.Second
+
+type fileStamp struct {
+	size    int64
+	modTime time.Time
+}
+
+// watchFiles restarts the child gracefully when a file matching its Watch
+// globs is added, removed or modified, once the files have been quiet for
+// WatchDelay seconds.
+func (c *child) watchFiles() {
+	last := c.watchedFiles()
+	var changed time.Time
+	tick := time.NewTicker(watchInterval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-c.prg.exit:
+			return
+		case <-tick.C:
+		}
+		files := c.watchedFiles()
+		if !sameStamps(files, last) {
+			last = files
+			changed = time.Now()
+			continue
+		}
+		if changed.IsZero() || time.Since(changed) < c.watchDelay() {
+			continue
+		}
+		changed = time.Time{}
+		logger.Info(c.label(), ": watched files changed")
+		if err := c.restart(); err != nil {
+			logger.Warningf("Failed to restart %s: %v", c.label(), err)
+		}
+	}
+}
+
+func checkWatch(proc *Process) error {
+	for _, pattern := range proc.Watch {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("Invalid Watch pattern %q", pattern)
+		}
+	}
+	return nil
+}
+
+func (c *child) watchDelay() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.WatchDelay > 0 {
+		return time.Duration(c.WatchDelay) * time.Second
+	}
+	return 2 * time.Second
+}
+
+// watchedFiles returns the size and modification time of every file matching
+// the Watch globs.
+func (c *child) watchedFiles() map[string]fileStamp {
+	c.mu.Lock()
+	patterns := c.Watch
+	c.mu.Unlock()
+	files := map[string]fileStamp{}
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(c.childPath(pattern))
+		if err != nil {
+			continue
+		}
+		for _, path := range matches {
+			if fi, err := os.Stat(path); err == nil && !fi.IsDir() {
+				files[path] = fileStamp{fi.Size(), fi.ModTime()}
+			}
+		}
+	}
+	return files
+}
+
+func sameStamps(a, b map[string]fileStamp) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for path, s := range a {
+		if t, ok := b[path]; !ok || s.size != t.size || !s.modTime.Equal(t.modTime) {
+			return false
+		}
+	}
+	return true
+}
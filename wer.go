@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// WER configures Windows Error Reporting for a process.
+type WER struct {
+	// NoDialog keeps crashes from showing a dialog nobody on a headless
+	// server would ever close, which hangs the process.
+	NoDialog bool
+	// DumpFolder (default the dumps folder next to the logs), DumpCount
+	// (default CrashDumps, or 10) and DumpType, "mini" (default) or "full",
+	// set up local crash dumps. MaxDumpSize caps the folder's total size.
+	DumpFolder  string
+	DumpCount   int
+	DumpType    string
+	MaxDumpSize string
+}
+
+func checkWER(proc *Process) error {
+	if proc.WER == nil {
+		return nil
+	}
+	switch proc.WER.DumpType {
+	case "", "mini", "full":
+	default:
+		return fmt.Errorf("Unknown WER DumpType %q, expected mini or full", proc.WER.DumpType)
+	}
+	if _, err := parseSize(proc.WER.MaxDumpSize); err != nil {
+		return fmt.Errorf("WER MaxDumpSize: %v", err)
+	}
+	return nil
+}
+
+// dumpsEnabled tells whether crash dumps of the process are kept.
+func (c *child) dumpsEnabled() bool {
+	if c.CrashDumps > 0 {
+		return true
+	}
+	w := c.WER
+	return w != nil && (w.DumpFolder != "" || w.DumpCount > 0 || w.DumpType != "" || w.MaxDumpSize != "")
+}
+
+// dumpCount and dumpType are the LocalDumps settings for the process.
+func (c *child) dumpCount() int {
+	switch {
+	case c.WER != nil && c.WER.DumpCount > 0:
+		return c.WER.DumpCount
+	case c.CrashDumps > 0:
+		return c.CrashDumps
+	}
+	return 10
+}
+
+func (c *child) dumpType() uint32 {
+	if c.WER != nil && c.WER.DumpType == "full" {
+		return 2
+	}
+	return 1
+}
+
+// pruneDumps deletes the oldest dumps beyond MaxDumpSize.
+func (c *child) pruneDumps() {
+	if c.WER == nil || c.WER.MaxDumpSize == "" {
+		return
+	}
+	max, _ := parseSize(c.WER.MaxDumpSize)
+	matches, _ := filepath.Glob(filepath.Join(c.dumpDir(), "*.dmp"))
+	type dump struct {
+		path string
+		size int64
+		mod  time.Time
+	}
+	var dumps []dump
+	var total int64
+	for _, m := range matches {
+		if fi, err := os.Stat(m); err == nil {
+			dumps = append(dumps, dump{m, fi.Size(), fi.ModTime()})
+			total += fi.Size()
+		}
+	}
+	sort.Slice(dumps, func(i, j int) bool { return dumps[i].mod.Before(dumps[j].mod) })
+	for _, d := range dumps {
+		if total <= max {
+			break
+		}
+		if err := os.Remove(d.path); err == nil {
+			total -= d.size
+		}
+	}
+}